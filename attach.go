@@ -0,0 +1,57 @@
+package container
+
+type AttachInterfaceKind string
+
+const (
+	AttachInterfaceVeth    AttachInterfaceKind = "veth"
+	AttachInterfaceMacvlan AttachInterfaceKind = "macvlan"
+	AttachInterfaceIPvlan  AttachInterfaceKind = "ipvlan"
+)
+
+// AttachInterfaceSpec describes an extra network interface to attach to a running container.
+type AttachInterfaceSpec struct {
+	// Name is the name of the interface inside the container, eg: net1
+	Name string `json:"name"`
+
+	Kind AttachInterfaceKind `json:"kind"`
+
+	// HostName is the name of the host-side end of a veth, a random vethXXXXXXXX name if empty.
+	// Only for veth.
+	HostName string `json:"hostName,omitempty"`
+
+	// Parent is the name of the host interface the macvlan/ipvlan is created on.
+	// Only for macvlan and ipvlan.
+	Parent string `json:"parent,omitempty"`
+
+	// Mode is the macvlan mode (private, vepa, bridge, passthru), bridge if empty,
+	// or the ipvlan mode (l2, l3, l3s), l2 if empty.
+	Mode string `json:"mode,omitempty"`
+
+	MTU          int    `json:"mtu,omitempty"`
+	HardwareAddr string `json:"hardwareAddr,omitempty"`
+
+	// Addresses are assigned to the interface, in CIDR notation, eg: 192.168.100.2/24
+	Addresses []string `json:"addresses,omitempty"`
+
+	// Routes are added inside the container via the interface.
+	Routes []AttachRoute `json:"routes,omitempty"`
+}
+
+type AttachRoute struct {
+	// Dst is the destination in CIDR notation, or "default"
+	Dst string `json:"dst"`
+
+	// Gateway is optional, the route is a link-scope route without it.
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// AttachedInterface is the result of attaching an interface to a container.
+type AttachedInterface struct {
+	Interface    string `json:"interface"`
+	Index        int    `json:"index"`
+	HardwareAddr string `json:"hardwareAddr"`
+
+	// HostInterface is the host-side end of a veth, or the parent of a macvlan/ipvlan.
+	HostInterface string `json:"hostInterface"`
+	HostIndex     int    `json:"hostIndex"`
+}
@@ -0,0 +1,334 @@
+package container
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// attachInterface creates the interface described by spec in the host network namespace at hostNetNSPath,
+// moves its container end into the network namespace at netnsPath, then configures and brings it up there.
+// Everything created is removed again if any step fails.
+func attachInterface(ctx context.Context, netnsPath, hostNetNSPath string, spec AttachInterfaceSpec) (_ *AttachedInterface, err error) {
+	if spec.Name == "" {
+		return nil, fmt.Errorf("interface name can not be empty")
+	}
+
+	var hwAddr net.HardwareAddr
+	if spec.HardwareAddr != "" {
+		if hwAddr, err = net.ParseMAC(spec.HardwareAddr); err != nil {
+			return nil, fmt.Errorf("parse hardware address failed, err: %s", err)
+		}
+	}
+
+	var addrs []*netlink.Addr
+	for _, address := range spec.Addresses {
+		addr, err := netlink.ParseAddr(address)
+		if err != nil {
+			return nil, fmt.Errorf("parse address (%s) failed, err: %s", address, err)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	var routes []*netlink.Route
+	for _, r := range spec.Routes {
+		route, err := parseAttachRoute(r)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+
+	containerNS, err := netns.GetFromPath(netnsPath)
+	if err != nil {
+		return nil, fmt.Errorf("open netns (%s) failed, err: %s", netnsPath, err)
+	}
+	defer containerNS.Close()
+
+	hostNS, err := netns.GetFromPath(hostNetNSPath)
+	if err != nil {
+		return nil, fmt.Errorf("open netns (%s) failed, err: %s", hostNetNSPath, err)
+	}
+	defer hostNS.Close()
+
+	hostHandle, err := netlink.NewHandleAt(hostNS)
+	if err != nil {
+		return nil, fmt.Errorf("create netlink handle in netns (%s) failed, err: %s", hostNetNSPath, err)
+	}
+	defer hostHandle.Delete()
+
+	containerHandle, err := netlink.NewHandleAt(containerNS)
+	if err != nil {
+		return nil, fmt.Errorf("create netlink handle in netns (%s) failed, err: %s", netnsPath, err)
+	}
+	defer containerHandle.Delete()
+
+	// cleanup deletes the link once it is created, on any later failure.
+	var cleanup func()
+	defer func() {
+		if err != nil && cleanup != nil {
+			cleanup()
+		}
+	}()
+
+	result := &AttachedInterface{
+		Interface: spec.Name,
+	}
+
+	// tmpName is used in the host network namespace before the link is moved into the container,
+	// so that spec.Name can not collide with the names of host interfaces.
+	tmpName, err := randomLinkName("gcu")
+	if err != nil {
+		return nil, err
+	}
+
+	switch spec.Kind {
+	case AttachInterfaceVeth:
+		hostName := spec.HostName
+		if hostName == "" {
+			if hostName, err = randomLinkName("veth"); err != nil {
+				return nil, err
+			}
+		}
+
+		veth := &netlink.Veth{
+			LinkAttrs:     netlink.LinkAttrs{Name: hostName, MTU: spec.MTU},
+			PeerName:      spec.Name,
+			PeerNamespace: netlink.NsFd(int(containerNS)),
+		}
+		if err := hostHandle.LinkAdd(veth); err != nil {
+			return nil, fmt.Errorf("create veth (%s) failed, err: %s", hostName, err)
+		}
+		// deleting the host end also deletes the container end of a veth.
+		cleanup = func() {
+			deleteLinkByName(hostHandle, hostName)
+		}
+
+		hostLink, err := hostHandle.LinkByName(hostName)
+		if err != nil {
+			return nil, fmt.Errorf("get link (%s) failed, err: %s", hostName, err)
+		}
+		result.HostInterface = hostName
+		result.HostIndex = hostLink.Attrs().Index
+
+		if err := hostHandle.LinkSetUp(hostLink); err != nil {
+			return nil, fmt.Errorf("set link (%s) up failed, err: %s", hostName, err)
+		}
+
+	case AttachInterfaceMacvlan, AttachInterfaceIPvlan:
+		parent, err := hostHandle.LinkByName(spec.Parent)
+		if err != nil {
+			return nil, fmt.Errorf("get parent link (%s) failed, err: %s", spec.Parent, err)
+		}
+		result.HostInterface = spec.Parent
+		result.HostIndex = parent.Attrs().Index
+
+		attrs := netlink.LinkAttrs{Name: tmpName, MTU: spec.MTU, ParentIndex: parent.Attrs().Index}
+
+		var link netlink.Link
+		if spec.Kind == AttachInterfaceMacvlan {
+			mode, err := parseMacvlanMode(spec.Mode)
+			if err != nil {
+				return nil, err
+			}
+			link = &netlink.Macvlan{LinkAttrs: attrs, Mode: mode}
+		} else {
+			mode, err := parseIPvlanMode(spec.Mode)
+			if err != nil {
+				return nil, err
+			}
+			link = &netlink.IPVlan{LinkAttrs: attrs, Mode: mode}
+		}
+
+		if err := hostHandle.LinkAdd(link); err != nil {
+			return nil, fmt.Errorf("create %s on (%s) failed, err: %s", spec.Kind, spec.Parent, err)
+		}
+		cleanup = func() {
+			deleteLinkByName(hostHandle, tmpName)
+		}
+
+		if err := hostHandle.LinkSetNsFd(link, int(containerNS)); err != nil {
+			return nil, fmt.Errorf("move %s into netns (%s) failed, err: %s", spec.Kind, netnsPath, err)
+		}
+		cleanup = func() {
+			deleteLinkByName(containerHandle, tmpName)
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown interface kind: (%s)", spec.Kind)
+	}
+
+	err = doInNamespaces(ctx, map[NamespaceType]string{NamespaceNet: netnsPath}, func() error {
+		name := spec.Name
+		if spec.Kind != AttachInterfaceVeth {
+			name = tmpName
+		}
+
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			return fmt.Errorf("get link (%s) failed, err: %s", name, err)
+		}
+		if spec.Kind != AttachInterfaceVeth {
+			// the link may be renamed by the configuration, it is deleted by its index.
+			index := link.Attrs().Index
+			cleanup = func() {
+				if link, err := containerHandle.LinkByIndex(index); err == nil {
+					containerHandle.LinkDel(link)
+				}
+			}
+		}
+
+		if err := configureAttachedLink(link, spec.Name, hwAddr, addrs, routes); err != nil {
+			return err
+		}
+
+		link, err = netlink.LinkByIndex(link.Attrs().Index)
+		if err != nil {
+			return fmt.Errorf("get link (%s) failed, err: %s", spec.Name, err)
+		}
+		result.Index = link.Attrs().Index
+		result.HardwareAddr = link.Attrs().HardwareAddr.String()
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed inside ns, err: %s", err)
+	}
+
+	return result, nil
+}
+
+// deleteLinkByName deletes the link name of the network namespace of handle, if it exists.
+func deleteLinkByName(handle *netlink.Handle, name string) {
+	if link, err := handle.LinkByName(name); err == nil {
+		handle.LinkDel(link)
+	}
+}
+
+func configureAttachedLink(link netlink.Link, name string, hwAddr net.HardwareAddr, addrs []*netlink.Addr, routes []*netlink.Route) error {
+	if link.Attrs().Name != name {
+		if err := netlink.LinkSetName(link, name); err != nil {
+			return fmt.Errorf("rename link (%s) to (%s) failed, err: %s", link.Attrs().Name, name, err)
+		}
+	}
+
+	if hwAddr != nil {
+		if err := netlink.LinkSetHardwareAddr(link, hwAddr); err != nil {
+			return fmt.Errorf("set hardware address of (%s) failed, err: %s", name, err)
+		}
+	}
+
+	for _, addr := range addrs {
+		if err := netlink.AddrAdd(link, addr); err != nil {
+			return fmt.Errorf("add address (%s) to (%s) failed, err: %s", addr.IPNet, name, err)
+		}
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("set link (%s) up failed, err: %s", name, err)
+	}
+
+	for _, route := range routes {
+		route.LinkIndex = link.Attrs().Index
+		if err := netlink.RouteAdd(route); err != nil {
+			return fmt.Errorf("add route (%s) via (%s) failed, err: %s", route.Dst, name, err)
+		}
+	}
+
+	return nil
+}
+
+// detachInterface deletes the interface from the network namespace at netnsPath.
+// The addresses and routes of the interface and the host end of a veth are removed with it.
+func detachInterface(ctx context.Context, netnsPath, name string) error {
+	err := doInNamespaces(ctx, map[NamespaceType]string{NamespaceNet: netnsPath}, func() error {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			return fmt.Errorf("get link (%s) failed, err: %s", name, err)
+		}
+
+		if err := netlink.LinkDel(link); err != nil {
+			return fmt.Errorf("delete link (%s) failed, err: %s", name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed inside ns, err: %s", err)
+	}
+
+	return nil
+}
+
+func parseAttachRoute(r AttachRoute) (*netlink.Route, error) {
+	route := &netlink.Route{
+		Scope: netlink.SCOPE_UNIVERSE,
+	}
+
+	if r.Gateway != "" {
+		route.Gw = net.ParseIP(r.Gateway)
+		if route.Gw == nil {
+			return nil, fmt.Errorf("parse gateway (%s) failed", r.Gateway)
+		}
+	} else {
+		route.Scope = netlink.SCOPE_LINK
+	}
+
+	if r.Dst == "default" || r.Dst == "" {
+		// netlink needs an explicit destination to tell the address family of a link-scope default route.
+		dst := "0.0.0.0/0"
+		if route.Gw != nil && route.Gw.To4() == nil {
+			dst = "::/0"
+		}
+		_, route.Dst, _ = net.ParseCIDR(dst)
+		return route, nil
+	}
+
+	_, dst, err := net.ParseCIDR(r.Dst)
+	if err != nil {
+		return nil, fmt.Errorf("parse route destination (%s) failed, err: %s", r.Dst, err)
+	}
+	route.Dst = dst
+
+	return route, nil
+}
+
+func parseMacvlanMode(mode string) (netlink.MacvlanMode, error) {
+	switch mode {
+	case "", "bridge":
+		return netlink.MACVLAN_MODE_BRIDGE, nil
+	case "private":
+		return netlink.MACVLAN_MODE_PRIVATE, nil
+	case "vepa":
+		return netlink.MACVLAN_MODE_VEPA, nil
+	case "passthru":
+		return netlink.MACVLAN_MODE_PASSTHRU, nil
+	default:
+		return 0, fmt.Errorf("unknown macvlan mode: (%s)", mode)
+	}
+}
+
+func parseIPvlanMode(mode string) (netlink.IPVlanMode, error) {
+	switch mode {
+	case "", "l2":
+		return netlink.IPVLAN_MODE_L2, nil
+	case "l3":
+		return netlink.IPVLAN_MODE_L3, nil
+	case "l3s":
+		return netlink.IPVLAN_MODE_L3S, nil
+	default:
+		return 0, fmt.Errorf("unknown ipvlan mode: (%s)", mode)
+	}
+}
+
+func randomLinkName(prefix string) (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate link name failed, err: %s", err)
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
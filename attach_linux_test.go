@@ -0,0 +1,142 @@
+package container
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+func Test_attachInterface(t *testing.T) {
+	requireRoot(t)
+
+	ctx := context.Background()
+	hostPath := newTestNetNS(t, "gcu-test-attach-host")
+	containerPath := newTestNetNS(t, "gcu-test-attach-ctr")
+
+	hostNS, err := netns.GetFromPath(hostPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hostNS.Close()
+	hostHandle, err := netlink.NewHandleAt(hostNS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hostHandle.Delete()
+
+	// the parent of the macvlan
+	if err := hostHandle.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "uplink0"}, PeerName: "uplink1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	vethResult, err := attachInterface(ctx, containerPath, hostPath, AttachInterfaceSpec{
+		Name:         "net1",
+		Kind:         AttachInterfaceVeth,
+		HostName:     "vethtest1",
+		MTU:          1450,
+		HardwareAddr: "02:42:ac:11:00:02",
+		Addresses:    []string{"192.168.100.2/24", "fd00:100::2/64"},
+		Routes: []AttachRoute{
+			{Dst: "10.96.0.0/12", Gateway: "192.168.100.1"},
+			{Dst: "172.30.0.0/16"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vethResult.HostInterface != "vethtest1" || vethResult.HardwareAddr != "02:42:ac:11:00:02" {
+		t.Errorf("unexpected result: %+v", vethResult)
+	}
+
+	_, err = attachInterface(ctx, containerPath, hostPath, AttachInterfaceSpec{
+		Name:      "net2",
+		Kind:      AttachInterfaceMacvlan,
+		Parent:    "uplink0",
+		Addresses: []string{"192.168.200.2/24"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := networkStateInNetNS(ctx, containerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interfaces := map[string]InterfaceState{}
+	for _, intf := range state.Interfaces {
+		interfaces[intf.Name] = intf
+	}
+
+	net1, ok := interfaces["net1"]
+	if !ok || net1.MTU != 1450 || net1.OperState == "down" || len(net1.Addresses) < 2 {
+		t.Errorf("unexpected net1: %+v", net1)
+	}
+	if net2, ok := interfaces["net2"]; !ok || net2.Type != "macvlan" || len(net2.Addresses) != 1 {
+		t.Errorf("unexpected net2: %+v", net2)
+	}
+
+	var gotRoutes int
+	for _, route := range state.Routes {
+		if route.Interface != "net1" {
+			continue
+		}
+		if route.Dst == "10.96.0.0/12" && route.Gateway == "192.168.100.1" || route.Dst == "172.30.0.0/16" && route.Scope == "link" {
+			gotRoutes++
+		}
+	}
+	if gotRoutes != 2 {
+		t.Errorf("routes via net1 not found in %+v", state.Routes)
+	}
+
+	if _, err := attachInterface(ctx, containerPath, hostPath, AttachInterfaceSpec{Name: "net1", Kind: AttachInterfaceVeth, HostName: "vethtest2"}); err == nil {
+		t.Errorf("expected an error for duplicated interface name")
+	}
+	if _, err := hostHandle.LinkByName("vethtest2"); err == nil {
+		t.Errorf("host end of the failed veth is left behind")
+	}
+
+	// the created links are deleted when the configuration fails inside the netns, or is not even started.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	for _, spec := range []AttachInterfaceSpec{
+		{Name: "net3", Kind: AttachInterfaceVeth, HostName: "vethtest3"},
+		{Name: "net3", Kind: AttachInterfaceMacvlan, Parent: "uplink0"},
+	} {
+		if _, err := attachInterface(cancelled, containerPath, hostPath, spec); err == nil {
+			t.Errorf("%s: expected an error for a cancelled context", spec.Kind)
+		}
+	}
+	if _, err := attachInterface(ctx, containerPath, hostPath, AttachInterfaceSpec{
+		Name: "net3", Kind: AttachInterfaceMacvlan, Parent: "uplink0", Routes: []AttachRoute{{Dst: "10.0.0.0/8", Gateway: "172.31.255.1"}},
+	}); err == nil {
+		t.Errorf("expected an error for an unreachable gateway")
+	}
+	links, err := hostHandle.LinkList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 4 {
+		t.Errorf("unexpected links left in the host netns: %d", len(links))
+	}
+
+	for _, name := range []string{"net1", "net2"} {
+		if err := detachInterface(ctx, containerPath, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := hostHandle.LinkByName("vethtest1"); err == nil {
+		t.Errorf("host end of the veth still exists after detach")
+	}
+
+	state, err = networkStateInNetNS(ctx, containerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Interfaces) != 1 {
+		t.Errorf("unexpected interfaces after detach: %+v", state.Interfaces)
+	}
+}
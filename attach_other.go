@@ -0,0 +1,15 @@
+//go:build !linux
+
+package container
+
+import (
+	"context"
+)
+
+func attachInterface(ctx context.Context, netnsPath, hostNetNSPath string, spec AttachInterfaceSpec) (*AttachedInterface, error) {
+	return nil, ErrNotImplemented
+}
+
+func detachInterface(ctx context.Context, netnsPath, name string) error {
+	return ErrNotImplemented
+}
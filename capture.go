@@ -0,0 +1,96 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/bpf"
+)
+
+type CaptureFormat string
+
+const (
+	CaptureFormatPcap   CaptureFormat = "pcap"
+	CaptureFormatPcapNG CaptureFormat = "pcapng"
+)
+
+// DefaultCaptureSnaplen is the snaplen of tcpdump, used when CaptureOptions.Snaplen is 0.
+const DefaultCaptureSnaplen = 262144
+
+// CaptureOptions are the limits and the output format of a packet capture.
+// The capture stops at the first limit reached, or when the context is done.
+type CaptureOptions struct {
+	// Snaplen is the maximum number of bytes saved of each packet.
+	Snaplen int `json:"snaplen,omitempty"`
+
+	// Count is the maximum number of packets captured, no limit if 0.
+	Count int `json:"count,omitempty"`
+
+	// Duration is the maximum duration of the capture, no limit if 0.
+	Duration time.Duration `json:"duration,omitempty"`
+
+	// Format is the format of the output, pcap if empty.
+	Format CaptureFormat `json:"format,omitempty"`
+
+	// HostSide captures on the host-side veth peer of the interface instead of inside the container.
+	HostSide bool `json:"hostSide,omitempty"`
+}
+
+// CaptureStats are the counters of a finished capture.
+type CaptureStats struct {
+	// Packets is the number of packets written.
+	Packets int `json:"packets"`
+
+	// Dropped is the number of packets dropped by the kernel because the capture could not keep up.
+	Dropped int `json:"dropped"`
+}
+
+// ParseBPF parses a classic BPF program in the decimal format printed by `tcpdump -ddd`,
+// eg: `tcpdump -i eth0 -ddd 'tcp port 80'`, with the instructions separated by newlines or commas.
+// The program must be compiled for the link type of the captured interface.
+func ParseBPF(s string) ([]bpf.RawInstruction, error) {
+	lines := strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == ',' })
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("empty bpf program")
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(lines[0]))
+	if err != nil {
+		return nil, fmt.Errorf("parse instruction count (%s) failed, err: %s", lines[0], err)
+	}
+	if count != len(lines)-1 {
+		return nil, fmt.Errorf("instruction count %d does not match %d instructions", count, len(lines)-1)
+	}
+	if count > bpfMaxInstructions {
+		return nil, fmt.Errorf("too many instructions: %d", count)
+	}
+
+	var insts = make([]bpf.RawInstruction, 0, count)
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected instruction: (%s)", line)
+		}
+
+		var values [4]uint64
+		for i, bits := range []int{16, 8, 8, 32} {
+			if values[i], err = strconv.ParseUint(fields[i], 10, bits); err != nil {
+				return nil, fmt.Errorf("parse instruction (%s) failed, err: %s", line, err)
+			}
+		}
+
+		insts = append(insts, bpf.RawInstruction{
+			Op: uint16(values[0]),
+			Jt: uint8(values[1]),
+			Jf: uint8(values[2]),
+			K:  uint32(values[3]),
+		})
+	}
+
+	return insts, nil
+}
+
+// bpfMaxInstructions is BPF_MAXINSNS of the kernel.
+const bpfMaxInstructions = 4096
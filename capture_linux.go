@@ -0,0 +1,167 @@
+package container
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// capture captures the packets of the interface iface inside the network namespace at netnsPath
+// with an AF_PACKET socket, and writes those accepted by filter to w.
+// It returns when a limit of opts is reached or ctx is done, which are not errors.
+func capture(ctx context.Context, netnsPath string, iface string, filter []bpf.RawInstruction, w io.Writer, opts CaptureOptions) (*CaptureStats, error) {
+	snaplen := opts.Snaplen
+	if snaplen == 0 {
+		snaplen = DefaultCaptureSnaplen
+	}
+	if snaplen < 0 || opts.Count < 0 || opts.Duration < 0 {
+		return nil, fmt.Errorf("invalid capture options: %+v", opts)
+	}
+	if len(filter) > bpfMaxInstructions {
+		return nil, fmt.Errorf("too many bpf instructions: %d", len(filter))
+	}
+
+	var fd, ifindex, linkType int
+
+	// the socket belongs to the network namespace it is created in, and can be used from any thread afterwards.
+	err := doInNamespaces(ctx, map[NamespaceType]string{NamespaceNet: netnsPath}, func() error {
+		link, err := netlink.LinkByName(iface)
+		if err != nil {
+			return fmt.Errorf("get link (%s) failed, err: %s", iface, err)
+		}
+		ifindex = link.Attrs().Index
+
+		// links without an ethernet header, eg: tun, wireguard, ipip, are captured from the network header.
+		sockType := unix.SOCK_DGRAM
+		linkType = linkTypeRaw
+		if encap := link.Attrs().EncapType; encap == "ether" || encap == "loopback" {
+			sockType = unix.SOCK_RAW
+			linkType = linkTypeEthernet
+		}
+
+		fd, err = unix.Socket(unix.AF_PACKET, sockType|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, int(htons(unix.ETH_P_ALL)))
+		if err != nil {
+			return fmt.Errorf("create packet socket failed, err: %s", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed inside ns, err: %s", err)
+	}
+
+	f := os.NewFile(uintptr(fd), "packet:"+iface)
+	defer f.Close()
+
+	if err := setupPacketSocket(fd, ifindex, filter); err != nil {
+		return nil, err
+	}
+
+	pw, err := newPacketWriter(w, opts.Format, linkType, snaplen, iface)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Duration > 0 {
+		f.SetReadDeadline(time.Now().Add(opts.Duration))
+	}
+	stop := context.AfterFunc(ctx, func() {
+		f.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &CaptureStats{}
+	buf := make([]byte, snaplen)
+
+	for opts.Count == 0 || stats.Packets < opts.Count {
+		var n int
+		var recvErr error
+		err := rc.Read(func(fd uintptr) bool {
+			// with MSG_TRUNC, n is the length of the packet even if it is longer than buf.
+			n, _, recvErr = unix.Recvfrom(int(fd), buf, unix.MSG_TRUNC)
+			return recvErr != unix.EAGAIN
+		})
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		}
+		if err == nil {
+			err = recvErr
+		}
+		if err != nil {
+			return stats, fmt.Errorf("receive packet failed, err: %s", err)
+		}
+
+		if err := pw.writePacket(time.Now(), buf[:min(n, len(buf))], n); err != nil {
+			return stats, fmt.Errorf("write packet failed, err: %s", err)
+		}
+		stats.Packets++
+	}
+
+	rc.Control(func(fd uintptr) {
+		if s, err := unix.GetsockoptTpacketStats(int(fd), unix.SOL_PACKET, unix.PACKET_STATISTICS); err == nil {
+			stats.Dropped = int(s.Drops)
+		}
+	})
+
+	return stats, nil
+}
+
+// setupPacketSocket binds the packet socket to the interface and attaches the filter.
+// A filter dropping everything is attached before binding, and the packets received from other
+// interfaces before the bind are drained, so that none of them leaks into the capture.
+func setupPacketSocket(fd int, ifindex int, filter []bpf.RawInstruction) error {
+	dropAll := []bpf.RawInstruction{{Op: 0x06, K: 0}} // ret #0
+	if err := attachSocketFilter(fd, dropAll); err != nil {
+		return err
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: ifindex}); err != nil {
+		return fmt.Errorf("bind packet socket failed, err: %s", err)
+	}
+
+	buf := make([]byte, 1)
+	for {
+		if _, _, err := unix.Recvfrom(fd, buf, unix.MSG_DONTWAIT); err != nil {
+			break
+		}
+	}
+
+	if len(filter) == 0 {
+		filter = []bpf.RawInstruction{{Op: 0x06, K: 0xffffffff}} // ret #-1
+	}
+
+	return attachSocketFilter(fd, filter)
+}
+
+func attachSocketFilter(fd int, filter []bpf.RawInstruction) error {
+	insts := make([]unix.SockFilter, len(filter))
+	for i, inst := range filter {
+		insts[i] = unix.SockFilter{Code: inst.Op, Jt: inst.Jt, Jf: inst.Jf, K: inst.K}
+	}
+
+	prog := unix.SockFprog{Len: uint16(len(insts)), Filter: &insts[0]}
+	if err := unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &prog); err != nil {
+		return fmt.Errorf("attach bpf filter failed, err: %s", err)
+	}
+
+	return nil
+}
+
+// htons converts v to network byte order.
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return binary.NativeEndian.Uint16(b[:])
+}
@@ -0,0 +1,109 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/bpf"
+)
+
+func Test_capture(t *testing.T) {
+	requireRoot(t)
+
+	ctx := context.Background()
+	netnsPath := newTestNetNS(t, "gcu-test-capture")
+
+	var conn net.Conn
+	err := doInNamespaces(ctx, map[NamespaceType]string{NamespaceNet: netnsPath}, func() error {
+		lo, err := netlink.LinkByName("lo")
+		if err != nil {
+			return err
+		}
+		if err := netlink.LinkSetUp(lo); err != nil {
+			return err
+		}
+		conn, err = net.Dial("udp", "127.0.0.1:9999")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// udp packets to port 9999 over ethernet and ipv4 without options
+	filter, err := bpf.Assemble([]bpf.Instruction{
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x0800, SkipFalse: 5},
+		bpf.LoadAbsolute{Off: 23, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 17, SkipFalse: 3},
+		bpf.LoadAbsolute{Off: 36, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 9999, SkipFalse: 1},
+		bpf.RetConstant{Val: 0xffff},
+		bpf.RetConstant{Val: 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendPackets := func() {
+		payload := bytes.Repeat([]byte("x"), 200)
+		for i := 0; i < 50; i++ {
+			conn.Write(payload)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	var buf bytes.Buffer
+	go sendPackets()
+	stats, err := capture(ctx, netnsPath, "lo", filter, &buf, CaptureOptions{Snaplen: 64, Count: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Packets != 3 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	b := buf.Bytes()
+	le := binary.LittleEndian
+	if len(b) < 24 || le.Uint32(b) != 0xa1b2c3d4 || le.Uint32(b[20:]) != linkTypeEthernet {
+		t.Fatalf("unexpected pcap header: %x", b)
+	}
+	b = b[24:]
+	for i := 0; i < 3; i++ {
+		inclLen, origLen := le.Uint32(b[8:]), le.Uint32(b[12:])
+		// ethernet + ipv4 + udp headers + payload
+		if inclLen != 64 || origLen != 14+20+8+200 {
+			t.Errorf("packet %d: unexpected lengths %d/%d", i, inclLen, origLen)
+		}
+		if port := binary.BigEndian.Uint16(b[16+36:]); port != 9999 {
+			t.Errorf("packet %d: unexpected port %d", i, port)
+		}
+		b = b[16+inclLen:]
+	}
+	if len(b) != 0 {
+		t.Errorf("unexpected trailing bytes: %d", len(b))
+	}
+
+	// the duration limit stops a capture without matching packets
+	start := time.Now()
+	buf.Reset()
+	stats, err = capture(ctx, netnsPath, "lo", []bpf.RawInstruction{{Op: 0x06, K: 0}}, &buf, CaptureOptions{Duration: 200 * time.Millisecond, Format: CaptureFormatPcapNG})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); stats.Packets != 0 || elapsed < 200*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("unexpected stats %+v after %s", stats, elapsed)
+	}
+
+	// so does the cancellation of the context
+	cctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := capture(cctx, netnsPath, "lo", nil, &buf, CaptureOptions{}); err != nil {
+		t.Errorf("cancelled capture failed, err: %s", err)
+	}
+}
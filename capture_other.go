@@ -0,0 +1,14 @@
+//go:build !linux
+
+package container
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/net/bpf"
+)
+
+func capture(ctx context.Context, netnsPath string, iface string, filter []bpf.RawInstruction, w io.Writer, opts CaptureOptions) (*CaptureStats, error) {
+	return nil, ErrNotImplemented
+}
@@ -0,0 +1,108 @@
+package container
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/bpf"
+)
+
+func TestParseBPF(t *testing.T) {
+	// tcpdump -ddd 'udp'
+	got, err := ParseBPF(`5
+40 0 0 12
+21 0 2 2048
+48 0 0 23
+21 0 1 17
+6 0 0 262144
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []bpf.RawInstruction{
+		{Op: 40, K: 12},
+		{Op: 21, Jf: 2, K: 2048},
+		{Op: 48, K: 23},
+		{Op: 21, Jf: 1, K: 17},
+		{Op: 6, K: 262144},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+
+	if got, err := ParseBPF("2,6 0 0 0,6 0 0 1"); err != nil || len(got) != 2 {
+		t.Errorf("comma separated: got %v, err: %v", got, err)
+	}
+
+	for _, s := range []string{"", "2\n6 0 0 0\n", "1\n6 0 0\n", "1\n6 0 256 0\n"} {
+		if _, err := ParseBPF(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func Test_pcapWriter(t *testing.T) {
+	var buf bytes.Buffer
+	pw, err := newPacketWriter(&buf, CaptureFormatPcap, linkTypeEthernet, 64, "eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Unix(1700000000, 123456000)
+	if err := pw.writePacket(ts, []byte{1, 2, 3}, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	if len(b) != 24+16+3 {
+		t.Fatalf("unexpected length %d", len(b))
+	}
+	le := binary.LittleEndian
+	if le.Uint32(b[0:]) != 0xa1b2c3d4 || le.Uint32(b[16:]) != 64 || le.Uint32(b[20:]) != linkTypeEthernet {
+		t.Errorf("unexpected header: %x", b[:24])
+	}
+	if le.Uint32(b[24:]) != 1700000000 || le.Uint32(b[28:]) != 123456 || le.Uint32(b[32:]) != 3 || le.Uint32(b[36:]) != 100 {
+		t.Errorf("unexpected record header: %x", b[24:40])
+	}
+}
+
+func Test_pcapngWriter(t *testing.T) {
+	var buf bytes.Buffer
+	pw, err := newPacketWriter(&buf, CaptureFormatPcapNG, linkTypeRaw, 64, "eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.writePacket(time.UnixMicro(1700000000123456), []byte{1, 2, 3, 4, 5}, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	// walk the blocks, checking that both length fields agree
+	le := binary.LittleEndian
+	var types []uint32
+	b := buf.Bytes()
+	for len(b) > 0 {
+		if len(b) < 12 {
+			t.Fatalf("truncated block: %x", b)
+		}
+		total := le.Uint32(b[4:])
+		if total%4 != 0 || int(total) > len(b) || le.Uint32(b[total-4:]) != total {
+			t.Fatalf("invalid block length %d", total)
+		}
+		types = append(types, le.Uint32(b))
+
+		if le.Uint32(b) == 6 {
+			micros := uint64(le.Uint32(b[12:]))<<32 | uint64(le.Uint32(b[16:]))
+			if micros != 1700000000123456 || le.Uint32(b[20:]) != 5 || !bytes.Equal(b[28:33], []byte{1, 2, 3, 4, 5}) {
+				t.Errorf("unexpected enhanced packet block: %x", b[:total])
+			}
+		}
+		b = b[total:]
+	}
+
+	if !reflect.DeepEqual(types, []uint32{0x0a0d0d0a, 1, 6}) {
+		t.Errorf("unexpected blocks: %x", types)
+	}
+}
@@ -0,0 +1,164 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ChangeKind is the kind of a change of the filesystem of a container, like the A, C and D of `docker diff`.
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeModified ChangeKind = "modified"
+	ChangeDeleted  ChangeKind = "deleted"
+)
+
+// Change is a path of the filesystem of a container which differs from the image.
+type Change struct {
+	// Path is the absolute path inside the container, eg: /etc/passwd
+	Path string     `json:"path"`
+	Kind ChangeKind `json:"kind"`
+
+	// Mode, Size, UID, GID and ModTime are the ones of the file in the container,
+	// or of the file of the image for a deleted path.
+	Mode    fs.FileMode `json:"mode"`
+	Size    int64       `json:"size"`
+	UID     int         `json:"uid"`
+	GID     int         `json:"gid"`
+	ModTime time.Time   `json:"modTime"`
+
+	// Digest is the sha256 digest of the content of an added or modified regular file,
+	// eg: "sha256:e3b0c442...", if requested by ChangesOptions.Digest.
+	Digest string `json:"digest,omitempty"`
+}
+
+type ChangesOptions struct {
+	// Digest computes the digest of the content of the added and modified regular files.
+	Digest bool
+}
+
+// hostOverlayDirs returns the overlay dirs, separated by ":", as returned by GetOverlayDirs, under hostRoot.
+func hostOverlayDirs(hostRoot, dirs string) string {
+	var ret []string
+	for _, dir := range strings.Split(dirs, ":") {
+		if dir != "" {
+			ret = append(ret, hostRunPath(hostRoot, dir))
+		}
+	}
+	return strings.Join(ret, ":")
+}
+
+// overlayChanges walks the upper dir and returns the changes relative to the lower dirs, sorted by path.
+//
+// A whiteout is a deleted path, and the entries of the lower dirs hidden by an opaque dir are deleted
+// as well. The other entries of the upper dir are modified if the lower dirs have the same path,
+// and added otherwise, directories included, like `docker diff` reports the parents of a changed file.
+func overlayChanges(ctx context.Context, lowerDir, upperDir string, opts ChangesOptions) ([]Change, error) {
+	lower := NewOverlayView(lowerDir, "")
+	merged := NewOverlayView(lowerDir, upperDir)
+
+	changes := []Change{}
+
+	err := filepath.WalkDir(upperDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(upperDir, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		lowerInfo, err := lower.Lstat(name)
+		inLower := err == nil
+		if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, syscall.ENOTDIR) {
+			return err
+		}
+
+		if isOverlayWhiteout(fi) {
+			if inLower {
+				changes = append(changes, newChange(name, ChangeDeleted, lowerInfo))
+			}
+			return nil
+		}
+
+		change := newChange(name, ChangeAdded, fi)
+		if inLower {
+			change.Kind = ChangeModified
+		}
+
+		if fi.Mode().IsRegular() && opts.Digest {
+			// the content of a metacopy file is in the lower dirs.
+			digest, err := fileDigest(merged, name)
+			if err != nil {
+				return err
+			}
+			change.Digest = digest
+		}
+		changes = append(changes, change)
+
+		// the entries of the lower dir hidden by an opaque dir and not replaced in the upper dir are deleted.
+		if fi.IsDir() && inLower && lowerInfo.IsDir() && merged.isOpaque(p) {
+			entries, err := lower.ReadDir(name)
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				if _, err := os.Lstat(filepath.Join(p, entry.Name())); err == nil {
+					continue
+				}
+				info, err := entry.Info()
+				if err != nil {
+					return err
+				}
+				changes = append(changes, newChange(path.Join(name, entry.Name()), ChangeDeleted, info))
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk upper dir (%s) failed, err: %w", upperDir, err)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes, nil
+}
+
+func newChange(name string, kind ChangeKind, fi fs.FileInfo) Change {
+	uid, gid := fileOwner(fi)
+	return Change{
+		Path:    "/" + name,
+		Kind:    kind,
+		Mode:    fi.Mode(),
+		Size:    fi.Size(),
+		UID:     uid,
+		GID:     gid,
+		ModTime: fi.ModTime(),
+	}
+}
@@ -0,0 +1,80 @@
+package container
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_overlayChanges(t *testing.T) {
+	requireRoot(t)
+
+	opts, _ := newTestOverlayLayers(t)
+
+	changes, err := overlayChanges(context.Background(), opts.LowerDir, opts.UpperDir, ChangesOptions{Digest: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := map[string]ChangeKind{}
+	digests := map[string]string{}
+	for _, change := range changes {
+		kinds[change.Path] = change.Kind
+		if change.Digest != "" {
+			digests[change.Path] = change.Digest
+		}
+	}
+
+	expected := map[string]ChangeKind{
+		"/data":          ChangeModified,
+		"/data/big":      ChangeModified,
+		"/doc":           ChangeAdded,
+		"/etc":           ChangeModified,
+		"/etc/hosts":     ChangeDeleted,
+		"/etc/new":       ChangeAdded,
+		"/link-doc":      ChangeAdded,
+		"/opt":           ChangeModified,
+		"/opt/app":       ChangeDeleted,
+		"/opt/renamed":   ChangeAdded,
+		"/usr":           ChangeModified,
+		"/usr/bin":       ChangeModified,
+		"/usr/bin/tool":  ChangeModified,
+		"/usr/share":     ChangeModified,
+		"/usr/share/doc": ChangeDeleted,
+		"/var":           ChangeModified,
+		"/var/cache":     ChangeModified,
+		"/var/cache/x":   ChangeModified,
+		"/var/cache/x/1": ChangeDeleted,
+		"/var/cache/x/2": ChangeDeleted,
+		"/var/cache/x/3": ChangeAdded,
+	}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("got %v, expected %v", kinds, expected)
+	}
+
+	sha := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	expectedDigests := map[string]string{
+		"/data/big":      sha(strings.Repeat("x", 1<<16)),
+		"/etc/new":       sha("new"),
+		"/usr/bin/tool":  sha("tool v3"),
+		"/var/cache/x/3": sha("3"),
+	}
+	if !reflect.DeepEqual(digests, expectedDigests) {
+		t.Errorf("got %v, expected %v", digests, expectedDigests)
+	}
+
+	for _, change := range changes {
+		if change.Path == "/usr/bin/tool" && (change.Mode.Perm() != 0644 || change.Size != 7) {
+			t.Errorf("unexpected change: %+v", change)
+		}
+		if change.Path == "/etc/hosts" && change.Size != int64(len("127.0.0.1 localhost\n")) {
+			t.Errorf("unexpected deleted change: %+v", change)
+		}
+	}
+}
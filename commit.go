@@ -0,0 +1,430 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/manifest"
+	"github.com/regclient/regclient/types/mediatype"
+	v1 "github.com/regclient/regclient/types/oci/v1"
+	"github.com/regclient/regclient/types/platform"
+	"github.com/regclient/regclient/types/ref"
+)
+
+type CommitOptions struct {
+	// Ref is the reference of the new image, eg: registry.example.com/team/app:debug
+	Ref string
+
+	// BaseImage is the reference of the image the container was created from, by default the image
+	// of the container, by digest. It must be pullable, the layers of the new image are the ones of its manifest,
+	// which must have as many layers as the lower dirs of the container.
+	BaseImage string
+
+	// Platform is the platform of the base image, eg: linux/amd64, by default linux and the arch of the host.
+	Platform string
+
+	// Author and Message are recorded in the config and in the history entry of the new layer.
+	Author  string
+	Message string
+
+	// Changes are Dockerfile instructions applied to the config of the new image, like the --change
+	// of `docker commit`, eg: `ENV DEBUG=1`. CMD, ENTRYPOINT, ENV, EXPOSE, LABEL, STOPSIGNAL, USER,
+	// VOLUME and WORKDIR are supported.
+	Changes []string
+
+	// Pause pauses the container while its upper dir is read, so that the layer is consistent.
+	// A container which is not running, or already paused, is left as it is.
+	Pause bool
+
+	// SaveDir, if not empty, writes the new image as a docker-archive tar in the layout of DownloadImageTarFile
+	// under SaveDir instead of pushing it to the registry of Ref.
+	SaveDir string
+}
+
+// CommitResult describes an image created by Commit.
+type CommitResult struct {
+	Ref string `json:"ref"`
+
+	// Digest is the digest of the manifest, ImageID the one of the config.
+	Digest  digest.Digest `json:"digest"`
+	ImageID digest.Digest `json:"imageID"`
+
+	// Layer is the layer of the upper dir appended to the layers of the base image.
+	Layer *ExportedLayer `json:"layer"`
+
+	// TarFilePath is the path of the docker-archive tar if CommitOptions.SaveDir is set.
+	TarFilePath string `json:"tarFilePath,omitempty"`
+}
+
+// commitImage appends the layer written by exportLayer to the base image of opts, which must have lowerLayers
+// layers, and pushes the image to opts.Ref, or writes it under opts.SaveDir.
+//
+// The registries are accessed with the credentials of the docker config of the user.
+func commitImage(ctx context.Context, exportLayer func(w io.Writer) (*ExportedLayer, error), lowerLayers int, opts CommitOptions) (*CommitResult, error) {
+	if opts.Ref == "" {
+		return nil, fmt.Errorf("ref can not be empty")
+	}
+	if opts.BaseImage == "" {
+		return nil, fmt.Errorf("base image can not be empty")
+	}
+	if opts.Platform == "" {
+		opts.Platform = "linux/" + runtime.GOARCH
+	}
+
+	// the changes are checked before anything is exported or pushed.
+	var imageConfig v1.ImageConfig
+	if err := applyCommitChanges(&imageConfig, opts.Changes); err != nil {
+		return nil, err
+	}
+
+	rc := regclient.New(regclient.WithDockerCreds())
+
+	baseRef, err := ref.New(opts.BaseImage)
+	if err != nil {
+		return nil, fmt.Errorf("create ref of base image failed, err: %s", err)
+	}
+	p, err := platform.Parse(opts.Platform)
+	if err != nil {
+		return nil, fmt.Errorf("parse platform failed, err: %s", err)
+	}
+
+	m, err := rc.ManifestGet(ctx, baseRef, regclient.WithManifestPlatform(p))
+	if err != nil {
+		return nil, fmt.Errorf("get manifest of base image failed, err: %s", err)
+	}
+	mi, ok := m.(manifest.Imager)
+	if !ok {
+		return nil, fmt.Errorf("manifest does not implement Imager interface")
+	}
+	// the blobs are fetched from the manifest of the platform.
+	baseRef = baseRef.SetDigest(m.GetDescriptor().Digest.String())
+
+	configDesc, err := mi.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("get image config failed, err: %s", err)
+	}
+	config, err := rc.BlobGetOCIConfig(ctx, baseRef, configDesc)
+	if err != nil {
+		return nil, fmt.Errorf("get image config blob failed, err: %s", err)
+	}
+	layers, err := mi.GetLayers()
+	if err != nil {
+		return nil, fmt.Errorf("get image layers failed, err: %s", err)
+	}
+	// a base image which is not the one of the container would put the layer on other lower layers.
+	if n := len(config.GetConfig().RootFS.DiffIDs); n != lowerLayers {
+		return nil, fmt.Errorf("base image (%s) has %d layers, the container has %d lower layers", opts.BaseImage, n, lowerLayers)
+	}
+
+	layerFile, err := os.CreateTemp("", ".commit-layer-")
+	if err != nil {
+		return nil, fmt.Errorf("create layer file failed, err: %s", err)
+	}
+	defer os.Remove(layerFile.Name())
+	defer layerFile.Close()
+
+	layer, err := exportLayer(layerFile)
+	if err != nil {
+		return nil, fmt.Errorf("export upper layer failed, err: %w", err)
+	}
+	if _, err := layerFile.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek layer file failed, err: %s", err)
+	}
+
+	layerMediaType, err := commitLayerMediaType(m.GetDescriptor().MediaType, layer.MediaType)
+	if err != nil {
+		return nil, err
+	}
+	layerDesc := descriptor.Descriptor{MediaType: layerMediaType, Digest: layer.Digest, Size: layer.Size}
+
+	image := config.GetConfig()
+	now := time.Now().UTC()
+	image.Created = &now
+	if opts.Author != "" {
+		image.Author = opts.Author
+	}
+	image.RootFS.DiffIDs = append(image.RootFS.DiffIDs, layer.DiffID)
+	image.History = append(image.History, v1.History{
+		Created:   &now,
+		CreatedBy: "commit",
+		Author:    opts.Author,
+		Comment:   opts.Message,
+	})
+	if err := applyCommitChanges(&image.Config, opts.Changes); err != nil {
+		return nil, err
+	}
+	config.SetConfig(image)
+	configBody, err := config.RawBody()
+	if err != nil {
+		return nil, fmt.Errorf("marshal image config failed, err: %s", err)
+	}
+	configDesc = config.GetDescriptor()
+
+	if err := mi.SetConfig(configDesc); err != nil {
+		return nil, fmt.Errorf("set image config failed, err: %s", err)
+	}
+	if err := mi.SetLayers(append(layers, layerDesc)); err != nil {
+		return nil, fmt.Errorf("set image layers failed, err: %s", err)
+	}
+
+	result := &CommitResult{
+		Ref:     opts.Ref,
+		Digest:  m.GetDescriptor().Digest,
+		ImageID: configDesc.Digest,
+		Layer:   layer,
+	}
+
+	// the image to save is assembled in a temporary OCI layout, and exported from it.
+	target := opts.Ref
+	if opts.SaveDir != "" {
+		layoutDir, err := os.MkdirTemp("", ".commit-layout-")
+		if err != nil {
+			return nil, fmt.Errorf("create image layout dir failed, err: %s", err)
+		}
+		defer os.RemoveAll(layoutDir)
+		target = "ocidir://" + layoutDir + ":latest"
+	}
+	targetRef, err := ref.New(target)
+	if err != nil {
+		return nil, fmt.Errorf("create ref failed, err: %s", err)
+	}
+
+	for _, d := range layers {
+		if err := rc.BlobCopy(ctx, baseRef, targetRef, d); err != nil {
+			return nil, fmt.Errorf("copy layer (%s) of base image failed, err: %s", d.Digest, err)
+		}
+	}
+	if _, err := rc.BlobPut(ctx, targetRef, layerDesc, layerFile); err != nil {
+		return nil, fmt.Errorf("put layer failed, err: %s", err)
+	}
+	if _, err := rc.BlobPut(ctx, targetRef, configDesc, bytes.NewReader(configBody)); err != nil {
+		return nil, fmt.Errorf("put image config failed, err: %s", err)
+	}
+	if err := rc.ManifestPut(ctx, targetRef, m); err != nil {
+		return nil, fmt.Errorf("put manifest failed, err: %s", err)
+	}
+
+	if opts.SaveDir == "" {
+		return result, nil
+	}
+
+	result.TarFilePath = SafeImageTarFilePath(opts.Ref, opts.Platform, opts.SaveDir)
+	if err := os.MkdirAll(filepath.Dir(result.TarFilePath), 0700); err != nil {
+		return nil, fmt.Errorf("create image file dir failed, err: %s", err)
+	}
+
+	exportRef, err := ref.New(opts.Ref)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %w", opts.Ref, err)
+	}
+
+	f, err := os.Create(result.TarFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("create image tar file failed, err: %s", err)
+	}
+	defer f.Close()
+
+	if err := rc.ImageExport(ctx, targetRef, f, regclient.ImageWithExportRef(exportRef)); err != nil {
+		return nil, fmt.Errorf("image export failed, err: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("close image tar file failed, err: %s", err)
+	}
+
+	imageIDFilePath := SafeImageIDFilePath(opts.Ref, opts.Platform, opts.SaveDir)
+	if err := os.WriteFile(imageIDFilePath, []byte(result.ImageID.String()+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("write image digest file failed, err: %s", err)
+	}
+
+	return result, nil
+}
+
+// imageRepository returns the repository of the image reference, without its tag and digest,
+// eg: docker.io/library/nginx for docker.io/library/nginx:1.25
+func imageRepository(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// commitLayerMediaType returns the media type of a layer of the OCI media type layerMediaType
+// in a manifest of the media type manifestMediaType.
+func commitLayerMediaType(manifestMediaType, layerMediaType string) (string, error) {
+	switch manifestMediaType {
+	case mediatype.OCI1Manifest:
+		return layerMediaType, nil
+	case mediatype.Docker2Manifest:
+		switch layerMediaType {
+		case mediatype.OCI1Layer:
+			return mediatype.Docker2Layer, nil
+		case mediatype.OCI1LayerGzip:
+			return mediatype.Docker2LayerGzip, nil
+		case mediatype.OCI1LayerZstd:
+			return mediatype.Docker2LayerZstd, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported layer media type %s in manifest of media type %s", layerMediaType, manifestMediaType)
+}
+
+// applyCommitChanges applies the Dockerfile instructions changes to config.
+func applyCommitChanges(config *v1.ImageConfig, changes []string) error {
+	for _, change := range changes {
+		instruction, args, _ := strings.Cut(strings.TrimSpace(change), " ")
+		args = strings.TrimSpace(args)
+		if args == "" {
+			return fmt.Errorf("change %q has no arguments", change)
+		}
+
+		switch strings.ToUpper(instruction) {
+		case "CMD":
+			config.Cmd = commitCommand(args)
+		case "ENTRYPOINT":
+			config.Entrypoint = commitCommand(args)
+		case "ENV":
+			pairs, err := commitKeyValues(args)
+			if err != nil {
+				return fmt.Errorf("parse change %q failed, err: %s", change, err)
+			}
+			for _, pair := range pairs {
+				config.Env = setEnv(config.Env, pair[0], pair[1])
+			}
+		case "LABEL":
+			pairs, err := commitKeyValues(args)
+			if err != nil {
+				return fmt.Errorf("parse change %q failed, err: %s", change, err)
+			}
+			if config.Labels == nil {
+				config.Labels = map[string]string{}
+			}
+			for _, pair := range pairs {
+				config.Labels[pair[0]] = pair[1]
+			}
+		case "EXPOSE":
+			if config.ExposedPorts == nil {
+				config.ExposedPorts = map[string]struct{}{}
+			}
+			for _, port := range strings.Fields(args) {
+				if !strings.Contains(port, "/") {
+					port += "/tcp"
+				}
+				config.ExposedPorts[port] = struct{}{}
+			}
+		case "VOLUME":
+			if config.Volumes == nil {
+				config.Volumes = map[string]struct{}{}
+			}
+			volumes := strings.Fields(args)
+			if strings.HasPrefix(args, "[") {
+				if err := json.Unmarshal([]byte(args), &volumes); err != nil {
+					return fmt.Errorf("parse change %q failed, err: %s", change, err)
+				}
+			}
+			for _, volume := range volumes {
+				config.Volumes[volume] = struct{}{}
+			}
+		case "USER":
+			config.User = args
+		case "WORKDIR":
+			config.WorkingDir = args
+		case "STOPSIGNAL":
+			config.StopSignal = args
+		default:
+			return fmt.Errorf("unsupported change instruction %q", instruction)
+		}
+	}
+	return nil
+}
+
+// commitCommand returns the command of the exec form, a JSON array, or of the shell form of a CMD or ENTRYPOINT.
+func commitCommand(args string) []string {
+	var command []string
+	if err := json.Unmarshal([]byte(args), &command); err == nil {
+		return command
+	}
+	return []string{"/bin/sh", "-c", args}
+}
+
+// commitKeyValues parses the key=value pairs of an ENV or LABEL instruction, whose values may be double quoted,
+// or the legacy `key value` form.
+func commitKeyValues(args string) ([][2]string, error) {
+	words, err := splitQuotedWords(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.Contains(words[0], "=") {
+		key, value, _ := strings.Cut(args, " ")
+		return [][2]string{{key, strings.TrimSpace(value)}}, nil
+	}
+
+	var pairs [][2]string
+	for _, word := range words {
+		key, value, ok := strings.Cut(word, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%q is not a key=value pair", word)
+		}
+		pairs = append(pairs, [2]string{key, value})
+	}
+	return pairs, nil
+}
+
+// splitQuotedWords splits s on spaces, except inside double quotes, which are removed.
+// A backslash escapes the next character.
+func splitQuotedWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, inQuotes, escaped := false, false, false
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			inWord, escaped = true, true
+		case r == '"':
+			inWord, inQuotes = true, !inQuotes
+		case r == ' ' || r == '\t':
+			if inQuotes {
+				word.WriteRune(r)
+			} else if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			inWord = true
+			word.WriteRune(r)
+		}
+	}
+	if inQuotes || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+func setEnv(env []string, key, value string) []string {
+	for i, kv := range env {
+		if k, _, _ := strings.Cut(kv, "="); k == key {
+			env[i] = key + "=" + value
+			return env
+		}
+	}
+	return append(env, key+"="+value)
+}
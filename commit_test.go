@@ -0,0 +1,301 @@
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/manifest"
+	"github.com/regclient/regclient/types/mediatype"
+	"github.com/regclient/regclient/types/oci"
+	v1 "github.com/regclient/regclient/types/oci/v1"
+	"github.com/regclient/regclient/types/platform"
+	"github.com/regclient/regclient/types/ref"
+)
+
+// newTestBaseImage writes an image with a single layer holding etc/base to an OCI layout and returns its reference.
+func newTestBaseImage(t *testing.T) string {
+	t.Helper()
+
+	ctx := context.Background()
+	rc := regclient.New()
+	image := "ocidir://" + filepath.Join(t.TempDir(), "base") + ":v1"
+	r, err := ref.New(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	if err := tw.WriteHeader(&tar.Header{Name: "etc/base", Mode: 0644, Size: 4}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte("base"))
+	tw.Close()
+	layerDesc := descriptor.Descriptor{MediaType: mediatype.OCI1Layer, Digest: digest.FromBytes(layer.Bytes()), Size: int64(layer.Len())}
+
+	config, err := json.Marshal(v1.Image{
+		Platform: platform.Platform{OS: "linux", Architecture: runtime.GOARCH},
+		Config:   v1.ImageConfig{Env: []string{"PATH=/usr/bin", "MODE=base"}, Cmd: []string{"/bin/sh"}},
+		RootFS:   v1.RootFS{Type: "layers", DiffIDs: []digest.Digest{layerDesc.Digest}},
+		History:  []v1.History{{CreatedBy: "base"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configDesc := descriptor.Descriptor{MediaType: mediatype.OCI1ImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))}
+
+	if _, err := rc.BlobPut(ctx, r, layerDesc, bytes.NewReader(layer.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rc.BlobPut(ctx, r, configDesc, bytes.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	m, err := manifest.New(manifest.WithOrig(v1.Manifest{
+		Versioned: oci.Versioned{SchemaVersion: 2},
+		MediaType: mediatype.OCI1Manifest,
+		Config:    configDesc,
+		Layers:    []descriptor.Descriptor{layerDesc},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.ManifestPut(ctx, r, m); err != nil {
+		t.Fatal(err)
+	}
+
+	return image
+}
+
+func newTestUpperLayer(t *testing.T) func(w io.Writer) (*ExportedLayer, error) {
+	t.Helper()
+
+	lower, upper := t.TempDir(), t.TempDir()
+	if err := os.MkdirAll(filepath.Join(upper, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(upper, "etc/committed"), []byte("committed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return func(w io.Writer) (*ExportedLayer, error) {
+		return exportUpperLayer(context.Background(), lower, upper, w, LayerCompressionGzip, ExportLayerOptions{})
+	}
+}
+
+// readLayerNames returns the names of the entries of a gzip compressed layer.
+func readLayerNames(t *testing.T, r io.Reader) []string {
+	t.Helper()
+
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+}
+
+func Test_commitImage_Push(t *testing.T) {
+	ctx := context.Background()
+	target := "ocidir://" + filepath.Join(t.TempDir(), "target") + ":committed"
+
+	result, err := commitImage(ctx, newTestUpperLayer(t), 1, CommitOptions{
+		Ref:       target,
+		BaseImage: newTestBaseImage(t),
+		Author:    "ops",
+		Message:   "debug session",
+		Changes:   []string{"ENV MODE=debug", "CMD [\"/bin/app\", \"-v\"]", "LABEL team=\"core infra\""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc := regclient.New()
+	r, _ := ref.New(target)
+	m, err := rc.ManifestGet(ctx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.GetDescriptor().Digest != result.Digest {
+		t.Errorf("unexpected manifest digest %s, expected %s", m.GetDescriptor().Digest, result.Digest)
+	}
+
+	layers, _ := m.(manifest.Imager).GetLayers()
+	if len(layers) != 2 || layers[1].Digest != result.Layer.Digest || layers[1].MediaType != mediatype.OCI1LayerGzip {
+		t.Fatalf("unexpected layers %+v", layers)
+	}
+
+	configDesc, _ := m.(manifest.Imager).GetConfig()
+	if configDesc.Digest != result.ImageID {
+		t.Errorf("unexpected config digest %s, expected %s", configDesc.Digest, result.ImageID)
+	}
+	config, err := rc.BlobGetOCIConfig(ctx, r, configDesc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := config.GetConfig()
+
+	if len(image.RootFS.DiffIDs) != 2 || image.RootFS.DiffIDs[1] != result.Layer.DiffID {
+		t.Errorf("unexpected diff ids %v", image.RootFS.DiffIDs)
+	}
+	last := image.History[len(image.History)-1]
+	if len(image.History) != 2 || last.Author != "ops" || last.Comment != "debug session" || image.Author != "ops" {
+		t.Errorf("unexpected history %+v", image.History)
+	}
+	if !reflect.DeepEqual(image.Config.Env, []string{"PATH=/usr/bin", "MODE=debug"}) ||
+		!reflect.DeepEqual(image.Config.Cmd, []string{"/bin/app", "-v"}) ||
+		image.Config.Labels["team"] != "core infra" {
+		t.Errorf("unexpected config %+v", image.Config)
+	}
+
+	// the new layer holds the upper dir
+	blob, err := rc.BlobGet(ctx, r, layers[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blob.Close()
+	names := readLayerNames(t, blob)
+	if !reflect.DeepEqual(names, []string{"etc/", "etc/committed"}) {
+		t.Errorf("unexpected layer entries %v", names)
+	}
+}
+
+func Test_commitImage_SaveDir(t *testing.T) {
+	saveDir := t.TempDir()
+	image := "registry.example.com/team/app:debug"
+
+	result, err := commitImage(context.Background(), newTestUpperLayer(t), 1, CommitOptions{
+		Ref:       image,
+		BaseImage: newTestBaseImage(t),
+		Platform:  "linux/" + runtime.GOARCH,
+		SaveDir:   saveDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.TarFilePath != SafeImageTarFilePath(image, "linux/"+runtime.GOARCH, saveDir) {
+		t.Errorf("unexpected tar file path %s", result.TarFilePath)
+	}
+	id, err := os.ReadFile(SafeImageIDFilePath(image, "linux/"+runtime.GOARCH, saveDir))
+	if err != nil || string(id) != result.ImageID.String()+"\n" {
+		t.Errorf("unexpected image id %q, err: %v", id, err)
+	}
+
+	f, err := os.Open(result.TarFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var dockerManifest []struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == "manifest.json" {
+			if err := json.NewDecoder(tr).Decode(&dockerManifest); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if len(dockerManifest) != 1 || !reflect.DeepEqual(dockerManifest[0].RepoTags, []string{image}) || len(dockerManifest[0].Layers) != 2 {
+		t.Errorf("unexpected docker archive manifest %+v", dockerManifest)
+	}
+}
+
+func Test_commitImage_lowerLayers(t *testing.T) {
+	saveDir := t.TempDir()
+	_, err := commitImage(context.Background(), newTestUpperLayer(t), 2, CommitOptions{
+		Ref:       "registry.example.com/team/app:debug",
+		BaseImage: newTestBaseImage(t),
+		Platform:  "linux/" + runtime.GOARCH,
+		SaveDir:   saveDir,
+	})
+	if err == nil {
+		t.Fatalf("expected an error for a base image which has not the layers of the container")
+	}
+	if entries, _ := os.ReadDir(saveDir); len(entries) != 0 {
+		t.Errorf("unexpected saved files %v", entries)
+	}
+}
+
+func Test_imageRepository(t *testing.T) {
+	for image, want := range map[string]string{
+		"nginx":                               "nginx",
+		"nginx:1.25":                          "nginx",
+		"docker.io/library/nginx:1.25":        "docker.io/library/nginx",
+		"localhost:5000/app":                  "localhost:5000/app",
+		"localhost:5000/app:v1@sha256:abcdef": "localhost:5000/app",
+		"nginx@sha256:abcdef":                 "nginx",
+	} {
+		if got := imageRepository(image); got != want {
+			t.Errorf("imageRepository(%s) = %s, want %s", image, got, want)
+		}
+	}
+}
+
+func Test_applyCommitChanges(t *testing.T) {
+	config := v1.ImageConfig{Env: []string{"A=1"}}
+	err := applyCommitChanges(&config, []string{
+		"ENV A=2 B=\"x y\" C=a\\ b",
+		"ENV LEGACY some value",
+		"ENTRYPOINT /app --serve",
+		"EXPOSE 80 53/udp",
+		"VOLUME [\"/data\"]",
+		"USER 1000:1000",
+		"WORKDIR /srv",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := v1.ImageConfig{
+		Env:          []string{"A=2", "B=x y", "C=a b", "LEGACY=some value"},
+		Entrypoint:   []string{"/bin/sh", "-c", "/app --serve"},
+		ExposedPorts: map[string]struct{}{"80/tcp": {}, "53/udp": {}},
+		Volumes:      map[string]struct{}{"/data": {}},
+		User:         "1000:1000",
+		WorkingDir:   "/srv",
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("unexpected config %+v, expected %+v", config, expected)
+	}
+
+	for _, change := range []string{"RUN make", "ENV", "LABEL a=\"b"} {
+		if err := applyCommitChanges(&v1.ImageConfig{}, []string{change}); err == nil {
+			t.Errorf("change %q accepted", change)
+		}
+	}
+}
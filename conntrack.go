@@ -0,0 +1,237 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// NATView is the conntrack and NAT state of the host related to the traffic of a container.
+type NATView struct {
+	// Addresses are the addresses of the container, without loopback and link-local addresses.
+	Addresses []string `json:"addresses"`
+
+	// HostInterfaces are the host-side interfaces of the container, eg: the veth peers.
+	HostInterfaces []string `json:"hostInterfaces"`
+
+	// Conntrack are the entries of the host conntrack table with an address of the container
+	// in their original or reply direction.
+	Conntrack []ConntrackEntry `json:"conntrack"`
+
+	// NATRules are the iptables/ip6tables nat rules and the nftables nat rules referencing
+	// an address or a host-side interface of the container.
+	NATRules []NATRule `json:"natRules"`
+}
+
+type ConntrackEntry struct {
+	// Protocol is the name of the layer 4 protocol, eg: tcp, udp, icmp
+	Protocol string `json:"protocol"`
+
+	// Original is the tuple of the first packet, Reply the tuple expected for the replies.
+	// They differ when the connection is NATed, eg: a dnat to a Service backend changes the
+	// source of the reply tuple to the address of the backend.
+	Original ConntrackTuple `json:"original"`
+	Reply    ConntrackTuple `json:"reply"`
+
+	Mark uint32 `json:"mark,omitempty"`
+
+	// Timeout is the number of seconds before the entry expires.
+	Timeout uint32 `json:"timeout"`
+}
+
+type ConntrackTuple struct {
+	Src     string `json:"src"`
+	Dst     string `json:"dst"`
+	SrcPort uint16 `json:"srcPort,omitempty"`
+	DstPort uint16 `json:"dstPort,omitempty"`
+	Packets uint64 `json:"packets,omitempty"`
+	Bytes   uint64 `json:"bytes,omitempty"`
+}
+
+// NATRule is a rule as printed by iptables-save or nft.
+type NATRule struct {
+	// Source is one of iptables, ip6tables and nftables.
+	Source string `json:"source"`
+
+	// Table is the table of the rule, eg: nat, or "ip kube-proxy" for nftables.
+	Table string `json:"table"`
+	Chain string `json:"chain"`
+	Rule  string `json:"rule"`
+}
+
+// ContainerNATView returns the conntrack entries and the NAT rules of the host network namespace
+// which concern the addresses or the host-side interfaces of the container.
+func ContainerNATView(ctx context.Context, c Container) (*NATView, error) {
+	state, err := c.NetworkState(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get network state failed, err: %w", err)
+	}
+
+	var addrs []netip.Addr
+	for _, owner := range ipOwners(c.RuntimeID(), 0, state) {
+		addr, err := netip.ParseAddr(owner.IP)
+		if err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	mappings, err := c.InterfaceNodeMappings()
+	if err != nil {
+		return nil, fmt.Errorf("call InterfaceNodeMappings failed, err: %w", err)
+	}
+
+	// the host network namespace is the one of the host-side interfaces.
+	var hostNetNSPath string
+	var hostInterfaces []string
+	for _, m := range mappings {
+		if m.HostInterface == "" {
+			continue
+		}
+		if hostNetNSPath == "" {
+			hostNetNSPath = m.HostNetNSPath
+		}
+		if m.HostNetNSPath == hostNetNSPath {
+			hostInterfaces = append(hostInterfaces, m.HostInterface)
+		}
+	}
+	if hostNetNSPath == "" {
+		return nil, fmt.Errorf("container has no host-side interface")
+	}
+
+	return natView(ctx, hostNetNSPath, addrs, hostInterfaces)
+}
+
+// natRuleMatcher tells if the text of a rule references one of the addresses or interfaces.
+type natRuleMatcher struct {
+	addrs  []netip.Addr
+	ifaces []string
+}
+
+func (m natRuleMatcher) match(rule string) bool {
+	for _, token := range strings.FieldsFunc(rule, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == '"' || r == ',' || r == '{' || r == '}'
+	}) {
+		for _, iface := range m.ifaces {
+			if token == iface {
+				return true
+			}
+		}
+
+		// eg: 10.244.1.5, 10.244.1.5/32, 10.244.1.5:8080, [fd00::5]:8080
+		host := token
+		if prefix, err := netip.ParsePrefix(token); err == nil {
+			if prefix.Bits() != prefix.Addr().BitLen() {
+				continue
+			}
+			host = prefix.Addr().String()
+		} else if addrPort, err := netip.ParseAddrPort(token); err == nil {
+			host = addrPort.Addr().String()
+		}
+
+		addr, err := netip.ParseAddr(host)
+		if err != nil {
+			continue
+		}
+		for _, a := range m.addrs {
+			if a == addr.Unmap() {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// parseIptablesSave returns the rules of the nat table in the output of iptables-save
+// which are matched by m.
+func parseIptablesSave(source string, out []byte, m natRuleMatcher) []NATRule {
+	var rules []NATRule
+
+	var table string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "*"):
+			table = strings.TrimPrefix(line, "*")
+		case strings.HasPrefix(line, "-A ") && table == "nat":
+			fields := strings.Fields(line)
+			if len(fields) < 2 || !m.match(line) {
+				continue
+			}
+			rules = append(rules, NATRule{Source: source, Table: table, Chain: fields[1], Rule: line})
+		}
+	}
+
+	return rules
+}
+
+// nftNATStatements are the statements of nftables rules doing nat.
+var nftNATStatements = []string{"dnat", "snat", "masquerade", "redirect"}
+
+// parseNftRuleset returns the rules in the output of `nft list ruleset` which are matched by m
+// and either do nat or are in a chain of type nat.
+func parseNftRuleset(out []byte, m natRuleMatcher) []NATRule {
+	var rules []NATRule
+
+	var table, chain string
+	var natChain bool
+	var depth int
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		fields := strings.Fields(line)
+
+		switch {
+		case line == "":
+		case depth == 0 && len(fields) >= 3 && fields[0] == "table" && strings.HasSuffix(line, "{"):
+			table = strings.Join(fields[1:len(fields)-1], " ")
+			depth++
+		case depth == 1 && len(fields) >= 2 && fields[0] == "chain" && strings.HasSuffix(line, "{"):
+			chain = fields[1]
+			natChain = false
+			depth++
+		case strings.HasSuffix(line, "{"):
+			// sets, maps, flowtables and the like
+			depth++
+		case line == "}":
+			depth--
+			// the lines after a chain or a table are not in it anymore.
+			if depth < 2 {
+				chain, natChain = "", false
+			}
+			if depth < 1 {
+				table = ""
+			}
+		case depth == 2 && chain != "":
+			if fields[0] == "type" {
+				natChain = len(fields) >= 2 && fields[1] == "nat"
+				continue
+			}
+			if fields[0] == "policy" || !m.match(line) {
+				continue
+			}
+
+			isNAT := natChain
+			for _, f := range fields {
+				for _, statement := range nftNATStatements {
+					if f == statement {
+						isNAT = true
+					}
+				}
+			}
+			if isNAT {
+				rules = append(rules, NATRule{Source: "nftables", Table: table, Chain: chain, Rule: line})
+			}
+		}
+	}
+
+	return rules
+}
@@ -0,0 +1,148 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os/exec"
+
+	"github.com/vishvananda/netlink"
+)
+
+// natView gathers the conntrack entries and the nat rules of the network namespace at hostNetNSPath
+// which reference the addresses addrs or the interfaces ifaces.
+func natView(ctx context.Context, hostNetNSPath string, addrs []netip.Addr, ifaces []string) (*NATView, error) {
+	view := &NATView{
+		Addresses:      []string{},
+		HostInterfaces: ifaces,
+		Conntrack:      []ConntrackEntry{},
+		NATRules:       []NATRule{},
+	}
+	if view.HostInterfaces == nil {
+		view.HostInterfaces = []string{}
+	}
+	for _, addr := range addrs {
+		view.Addresses = append(view.Addresses, addr.String())
+	}
+
+	err := withNetlinkHandle(ctx, hostNetNSPath, func(h *netlink.Handle) error {
+		for _, family := range []netlink.InetFamily{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+			flows, err := h.ConntrackTableList(netlink.ConntrackTable, family)
+			if err != nil {
+				return fmt.Errorf("list conntrack table failed, err: %s", err)
+			}
+			for _, flow := range flows {
+				if conntrackFlowMatches(flow, addrs) {
+					view.Conntrack = append(view.Conntrack, conntrackEntry(flow))
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m := natRuleMatcher{addrs: addrs, ifaces: ifaces}
+
+	// the tools are run inside the host network namespace, and are skipped if not installed.
+	err = doInNamespaces(ctx, map[NamespaceType]string{NamespaceNet: hostNetNSPath}, func() error {
+		for _, source := range []string{"iptables", "ip6tables"} {
+			out, err := runNATTool(ctx, source+"-save", "-t", "nat")
+			if err != nil {
+				return err
+			}
+			view.NATRules = append(view.NATRules, parseIptablesSave(source, out, m)...)
+		}
+
+		out, err := runNATTool(ctx, "nft", "list", "ruleset")
+		if err != nil {
+			return err
+		}
+		view.NATRules = append(view.NATRules, parseNftRuleset(out, m)...)
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed inside ns, err: %s", err)
+	}
+
+	return view, nil
+}
+
+// runNATTool runs the command and returns its output, or nothing if the command is not installed.
+func runNATTool(ctx context.Context, name string, args ...string) ([]byte, error) {
+	path, err := exec.LookPath(name)
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("run %s failed, err: %s, stderr: %s", name, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return out, nil
+}
+
+func conntrackFlowMatches(flow *netlink.ConntrackFlow, addrs []netip.Addr) bool {
+	for _, ip := range []net.IP{flow.Forward.SrcIP, flow.Forward.DstIP, flow.Reverse.SrcIP, flow.Reverse.DstIP} {
+		addr, ok := netip.AddrFromSlice(ip)
+		if !ok {
+			continue
+		}
+		for _, a := range addrs {
+			if a == addr.Unmap() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var conntrackProtocols = map[uint8]string{
+	1:   "icmp",
+	6:   "tcp",
+	17:  "udp",
+	58:  "icmpv6",
+	132: "sctp",
+}
+
+func conntrackEntry(flow *netlink.ConntrackFlow) ConntrackEntry {
+	protocol, ok := conntrackProtocols[flow.Forward.Protocol]
+	if !ok {
+		protocol = fmt.Sprintf("%d", flow.Forward.Protocol)
+	}
+
+	return ConntrackEntry{
+		Protocol: protocol,
+		Original: ConntrackTuple{
+			Src:     flow.Forward.SrcIP.String(),
+			Dst:     flow.Forward.DstIP.String(),
+			SrcPort: flow.Forward.SrcPort,
+			DstPort: flow.Forward.DstPort,
+			Packets: flow.Forward.Packets,
+			Bytes:   flow.Forward.Bytes,
+		},
+		Reply: ConntrackTuple{
+			Src:     flow.Reverse.SrcIP.String(),
+			Dst:     flow.Reverse.DstIP.String(),
+			SrcPort: flow.Reverse.SrcPort,
+			DstPort: flow.Reverse.DstPort,
+			Packets: flow.Reverse.Packets,
+			Bytes:   flow.Reverse.Bytes,
+		},
+		Mark:    flow.Mark,
+		Timeout: flow.TimeOut,
+	}
+}
@@ -0,0 +1,75 @@
+package container
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// createTestConntrackEntry inserts a udp conntrack entry into the conntrack table of the current thread's netns,
+// like `conntrack -I` does.
+func createTestConntrackEntry(origSrc, origDst, replySrc, replyDst string, sport, dport uint16) error {
+	// IPCTNL_MSG_CT_NEW of the NFNL_SUBSYS_CTNETLINK subsystem
+	req := nl.NewNetlinkRequest(1<<8, unix.NLM_F_CREATE|unix.NLM_F_EXCL|unix.NLM_F_ACK)
+	req.AddData(&nl.Nfgenmsg{NfgenFamily: unix.AF_INET, Version: nl.NFNETLINK_V0})
+
+	tuple := func(attrType int, src, dst string, sport, dport uint16) *nl.RtAttr {
+		t := nl.NewRtAttr(unix.NLA_F_NESTED|attrType, nil)
+		ip := t.AddRtAttr(unix.NLA_F_NESTED|nl.CTA_TUPLE_IP, nil)
+		ip.AddRtAttr(nl.CTA_IP_V4_SRC, net.ParseIP(src).To4())
+		ip.AddRtAttr(nl.CTA_IP_V4_DST, net.ParseIP(dst).To4())
+		proto := t.AddRtAttr(unix.NLA_F_NESTED|nl.CTA_TUPLE_PROTO, nil)
+		proto.AddRtAttr(nl.CTA_PROTO_NUM, []byte{unix.IPPROTO_UDP})
+		proto.AddRtAttr(nl.CTA_PROTO_SRC_PORT, binary.BigEndian.AppendUint16(nil, sport))
+		proto.AddRtAttr(nl.CTA_PROTO_DST_PORT, binary.BigEndian.AppendUint16(nil, dport))
+		return t
+	}
+
+	req.AddData(tuple(nl.CTA_TUPLE_ORIG, origSrc, origDst, sport, dport))
+	req.AddData(tuple(nl.CTA_TUPLE_REPLY, replySrc, replyDst, dport, sport))
+	req.AddData(nl.NewRtAttr(nl.CTA_TIMEOUT, binary.BigEndian.AppendUint32(nil, 120)))
+
+	_, err := req.Execute(unix.NETLINK_NETFILTER, 0)
+	return err
+}
+
+func Test_natView(t *testing.T) {
+	requireRoot(t)
+
+	ctx := context.Background()
+	hostPath := newTestNetNS(t, "gcu-test-nat-host")
+
+	err := doInNamespaces(ctx, map[NamespaceType]string{NamespaceNet: hostPath}, func() error {
+		// a client talking to a Service IP, dnat'ed to the pod 10.244.1.5
+		if err := createTestConntrackEntry("10.244.2.7", "10.96.0.10", "10.244.1.5", "10.244.2.7", 40000, 53); err != nil {
+			return err
+		}
+		// unrelated traffic
+		return createTestConntrackEntry("10.244.2.7", "10.244.3.9", "10.244.3.9", "10.244.2.7", 40001, 8080)
+	})
+	if err != nil {
+		t.Skipf("create conntrack entries failed, err: %s", err)
+	}
+
+	view, err := natView(ctx, hostPath, []netip.Addr{netip.MustParseAddr("10.244.1.5")}, []string{"veth1234"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(view.Conntrack) != 1 {
+		t.Fatalf("unexpected conntrack entries: %+v", view.Conntrack)
+	}
+	entry := view.Conntrack[0]
+	if entry.Protocol != "udp" || entry.Original.Dst != "10.96.0.10" || entry.Original.DstPort != 53 ||
+		entry.Reply.Src != "10.244.1.5" || entry.Reply.SrcPort != 53 || entry.Timeout == 0 {
+		t.Errorf("unexpected conntrack entry: %+v", entry)
+	}
+	if len(view.Addresses) != 1 || view.Addresses[0] != "10.244.1.5" {
+		t.Errorf("unexpected addresses: %v", view.Addresses)
+	}
+}
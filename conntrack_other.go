@@ -0,0 +1,12 @@
+//go:build !linux
+
+package container
+
+import (
+	"context"
+	"net/netip"
+)
+
+func natView(ctx context.Context, hostNetNSPath string, addrs []netip.Addr, ifaces []string) (*NATView, error) {
+	return nil, ErrNotImplemented
+}
@@ -0,0 +1,90 @@
+package container
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+var testNATRuleMatcher = natRuleMatcher{
+	addrs:  []netip.Addr{netip.MustParseAddr("10.244.1.5"), netip.MustParseAddr("fd00:10:244:1::5")},
+	ifaces: []string{"cali1234"},
+}
+
+func Test_parseIptablesSave(t *testing.T) {
+	out := []byte(`# Generated by iptables-save v1.8.7
+*filter
+:FORWARD ACCEPT [0:0]
+-A FORWARD -s 10.244.1.5/32 -j ACCEPT
+COMMIT
+*nat
+:PREROUTING ACCEPT [0:0]
+:KUBE-SEP-ABC - [0:0]
+-A KUBE-SEP-ABC -s 10.244.1.5/32 -m comment --comment "default/dns" -j KUBE-MARK-MASQ
+-A KUBE-SEP-ABC -p udp -m comment --comment "default/dns" -m udp -j DNAT --to-destination 10.244.1.5:53
+-A KUBE-SEP-DEF -p tcp -j DNAT --to-destination 10.244.1.50:80
+-A KUBE-POSTROUTING -o cali1234 -j MASQUERADE
+-A KUBE-SVC-XYZ -s 10.244.0.0/16 -j RETURN
+COMMIT
+`)
+
+	got := parseIptablesSave("iptables", out, testNATRuleMatcher)
+	var chains []string
+	for _, rule := range got {
+		if rule.Source != "iptables" || rule.Table != "nat" {
+			t.Errorf("unexpected rule: %+v", rule)
+		}
+		chains = append(chains, rule.Chain)
+	}
+	if !reflect.DeepEqual(chains, []string{"KUBE-SEP-ABC", "KUBE-SEP-ABC", "KUBE-POSTROUTING"}) {
+		t.Errorf("unexpected rules: %+v", got)
+	}
+}
+
+func Test_parseNftRuleset(t *testing.T) {
+	out := []byte(`table ip kube-proxy {
+	map service-ips {
+		type ipv4_addr . inet_proto . inet_service : verdict
+		elements = { 10.96.0.10 . udp . 53 : goto service-dns,
+			     10.96.0.1 . tcp . 443 : goto service-api }
+	}
+
+	chain nat-prerouting {
+		type nat hook prerouting priority dstnat; policy accept;
+		iifname "cali1234" jump services
+	}
+
+	map service-endpoints {
+		typeof ip daddr : ip daddr
+		elements = { 10.96.0.10 : 10.244.1.5 }
+	}
+
+	chain endpoint-dns {
+		ip saddr 10.244.1.5 jump mark-for-masquerade
+		meta l4proto udp dnat to 10.244.1.5:53
+		meta l4proto udp dnat to 10.244.1.50:53
+	}
+
+	chain filter-forward {
+		type filter hook forward priority filter; policy accept;
+		ip saddr 10.244.1.5 accept
+	}
+}
+table ip6 nat {
+	chain postrouting {
+		type nat hook postrouting priority srcnat; policy accept;
+		ip6 saddr fd00:10:244:1::5 masquerade
+	}
+}
+`)
+
+	got := parseNftRuleset(out, testNATRuleMatcher)
+	expected := []NATRule{
+		{Source: "nftables", Table: "ip kube-proxy", Chain: "nat-prerouting", Rule: `iifname "cali1234" jump services`},
+		{Source: "nftables", Table: "ip kube-proxy", Chain: "endpoint-dns", Rule: "meta l4proto udp dnat to 10.244.1.5:53"},
+		{Source: "nftables", Table: "ip6 nat", Chain: "postrouting", Rule: "ip6 saddr fd00:10:244:1::5 masquerade"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %+v, expected %+v", got, expected)
+	}
+}
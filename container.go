@@ -1,11 +1,14 @@
 package container
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net"
 	"strings"
 
 	"github.com/vishvananda/netlink"
+	"golang.org/x/net/bpf"
 )
 
 type Runtime string
@@ -29,6 +32,11 @@ type Container interface {
 	// {"eth0":"cali97e0633f831"}
 	GetInterfacesNodeMapping() (map[string]string, error)
 
+	// InterfaceNodeMappings returns, for each interface inside the container, the interface on the
+	// host it is connected to (the veth peer, the macvlan/ipvlan parent or the SR-IOV physical function),
+	// together with the link kinds and the network namespace of the host-side interface.
+	InterfaceNodeMappings() ([]InterfaceNodeMapping, error)
+
 	GetOverlayDirs() (lowerDir, upperDir, mergeDir string, err error)
 	IsExist() (bool, error)
 	IsOverlay() (bool, error)
@@ -36,6 +44,128 @@ type Container interface {
 	Pause() error
 	Unpause() error
 	WithHostRoot(hostRoot string)
+
+	// RuntimeID returns the id of the container prefixed with its runtime,
+	// in the format accepted by NewContainer, eg: docker://xxxxxx
+	RuntimeID() string
+
+	// Labels returns the labels of the container,
+	// eg: the io.kubernetes.pod.namespace and io.kubernetes.pod.name labels set by the kubelet.
+	Labels() (map[string]string, error)
+
+	// NetNSPath returns the path (under the host root) of the network namespace of the container.
+	// Containers of the same pod share the network namespace of the pod sandbox.
+	NetNSPath() (string, error)
+
+	// NetNSID returns the inode of the network namespace of the container on the nsfs filesystem,
+	// which identifies the network namespace on the node.
+	NetNSID() (uint64, error)
+
+	// NetworkState returns the interfaces with their addresses and counters, the routes of all tables,
+	// the policy rules and the neighbors inside the network namespace of the container.
+	// The namespace is entered only once.
+	NetworkState(ctx context.Context) (*NetworkState, error)
+
+	// AttachInterface creates a veth pair (or a macvlan/ipvlan on a host parent), moves its container end
+	// into the network namespace of the container, names it, assigns the addresses and routes and brings it up.
+	AttachInterface(ctx context.Context, spec AttachInterfaceSpec) (*AttachedInterface, error)
+
+	// DetachInterface deletes an interface attached by AttachInterface, together with its addresses,
+	// routes and the host end of a veth.
+	DetachInterface(ctx context.Context, name string) error
+
+	// ApplyNetworkOps applies the ops in order inside the network namespace of the container and returns
+	// the undo records, in the order in which they must be applied to restore the original state.
+	// If an op fails, the undo records of the ops applied before it are returned with the error.
+	ApplyNetworkOps(ctx context.Context, ops ...NetworkOp) ([]NetworkOp, error)
+
+	// ReadSysctl reads a net.* sysctl inside the network namespace of the container, eg: net.ipv4.ip_forward
+	ReadSysctl(ctx context.Context, key string) (string, error)
+
+	// ShapeTraffic adds latency, loss and a bandwidth limit to an interface of the container, with netem/tbf
+	// qdiscs inside the container netns for egress and on the host-side veth peer for ingress.
+	// It replaces a previous shaping, in both directions, and fails if the interface has a root qdisc installed
+	// by someone else.
+	ShapeTraffic(ctx context.Context, iface string, shaping Shaping) error
+
+	// ClearShaping removes the qdiscs installed by ShapeTraffic from both sides of the interface.
+	ClearShaping(ctx context.Context, iface string) error
+
+	// PortForward listens for TCP connections on listenAddr on the host and forwards each of them to
+	// 127.0.0.1:containerPort inside the network namespace of the container, so that ports bound to
+	// localhost in the container are reachable. It blocks until ctx is done.
+	PortForward(ctx context.Context, listenAddr string, containerPort int) error
+
+	// Sockets lists the tcp, udp and unix sockets in the network namespace of the container, in the style of ss,
+	// with the processes holding them open where they can be resolved.
+	Sockets(ctx context.Context) ([]Socket, error)
+
+	// Probe runs a tcp, udp, http or dns connectivity check from inside the network namespace of the container,
+	// resolving names with the container's resolv.conf. A failed check is reported in the result.
+	Probe(ctx context.Context, spec ProbeSpec) (*ProbeResult, error)
+
+	// Capture writes the packets of an interface of the container accepted by the classic BPF filter to w,
+	// as a pcap or pcapng stream, until a limit of opts is reached or ctx is done. The packets are captured
+	// with an AF_PACKET socket inside the network namespace of the container, or on the host-side veth peer.
+	// A nil filter accepts all packets, see ParseBPF to use a filter compiled by tcpdump.
+	Capture(ctx context.Context, iface string, filter []bpf.RawInstruction, w io.Writer, opts CaptureOptions) (*CaptureStats, error)
+
+	// Changes returns the paths of the filesystem of the container which were added, modified or deleted
+	// relative to its image, like `docker diff`, from the upper dir of its overlay filesystem.
+	Changes(ctx context.Context, opts ChangesOptions) ([]Change, error)
+
+	// ExportUpperLayer writes the upper dir of the overlay filesystem of the container to w as an OCI layer tarball,
+	// with the given compression, and returns its digests. The overlay whiteouts and opaque dirs are converted
+	// to OCI whiteouts.
+	ExportUpperLayer(ctx context.Context, w io.Writer, compression LayerCompression, opts ExportLayerOptions) (*ExportedLayer, error)
+
+	// Commit appends the upper dir of the overlay filesystem of the container as a new layer to its image,
+	// records it in the config and history, and pushes the new image to opts.Ref or writes it as a docker-archive
+	// tar in the layout of DownloadImageTarFile. Unlike `docker commit`, it works for any runtime.
+	Commit(ctx context.Context, opts CommitOptions) (*CommitResult, error)
+
+	// SnapshotUpper copies the upper dir of the overlay filesystem of the container to dest/upper, pausing the container
+	// if it is running, and writes the manifest of the copy, with the digests of the files, to dest/manifest.json.
+	// The whiteouts, xattrs, ownership, hardlinks and holes of sparse files are preserved.
+	SnapshotUpper(ctx context.Context, dest string) (*UpperSnapshot, error)
+
+	// RestoreUpper verifies a snapshot written by SnapshotUpper and copies it into the upper dir of the container,
+	// which must not be running, eg: to seed a new container created from the same image.
+	RestoreUpper(ctx context.Context, src string) error
+
+	// CopyFrom writes the file or dir at containerPath to w as a tar stream, like `docker cp`, with the archive API
+	// of Docker, or from the root filesystem of the container under the host root for other runtimes,
+	// read from its overlay dirs if it is not running.
+	// The path is resolved inside the root filesystem of the container, a symbolic link can not escape it.
+	CopyFrom(ctx context.Context, containerPath string, w io.Writer) error
+
+	// CopyTo extracts the tar stream r into the dir containerPath of the container, like `docker cp`,
+	// preserving the ownership of the entries. No path of the archive can escape the root filesystem of the container.
+	// Without the archive API of Docker, the container must be running.
+	CopyTo(ctx context.Context, r io.Reader, containerPath string) error
+
+	// Drift classifies the changes of the filesystem of the container relative to its image, from the upper dir
+	// of its overlay filesystem: new executables, modified binaries, changes of sensitive paths and new setuid files.
+	// The drifts matched by the allowlist of opts are reported apart.
+	Drift(ctx context.Context, opts DriftOptions) (*DriftReport, error)
+
+	// PID returns the pid of the init process of the container, as seen from the host.
+	PID() (int, error)
+
+	// Do runs fn on a locked OS thread which has joined the given namespaces of the container,
+	// and restores the original namespaces of the thread afterwards.
+	// Goroutines started by fn do not run in the joined namespaces.
+	// Do waits for fn to return, fn has to honor ctx itself to be cancelled.
+	//
+	// The net, uts, ipc, pid and cgroup namespaces are supported. The mount and user namespaces
+	// can not be joined by a multi-threaded process, Do returns ErrReexecRequired for them.
+	Do(ctx context.Context, nsTypes []NamespaceType, fn func() error) error
+
+	// DoReexec runs the helper registered by RegisterReexecHelper with args in a re-executed
+	// process which has joined the given namespaces of the container, and returns what the
+	// helper writes to stdout. All namespace types are supported, including mount and user.
+	// The program must call ReexecInit first thing in main and import the nsenter package.
+	DoReexec(ctx context.Context, nsTypes []NamespaceType, helper string, args ...string) ([]byte, error)
 }
 
 // runtimeContainerID has the following format:
@@ -66,6 +196,21 @@ func NewContainer(runtimeContainerID string) (Container, error) {
 	}
 }
 
+// ListContainers returns the containers of the runtime on the node.
+// The containers are configured with the given host root, see WithHostRoot.
+func ListContainers(runtime Runtime, hostRoot string) ([]Container, error) {
+	switch runtime {
+	case RuntimeDocker:
+		return ListDockerContainers(hostRoot)
+
+	case RuntimeContainerd:
+		return ListContainerdContainers(hostRoot)
+
+	default:
+		return nil, fmt.Errorf("unknown container runtime: (%s)", runtime)
+	}
+}
+
 func RuntimeRootDir(runtime Runtime) (string, error) {
 	switch runtime {
 	case RuntimeDocker:
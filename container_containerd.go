@@ -2,16 +2,27 @@ package container
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/containerd/containerd"
+	apievents "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/api/services/tasks/v1"
+	tasktypes "github.com/containerd/containerd/api/types/task"
 	"github.com/containerd/containerd/defaults"
 	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/errdefs"
+	"github.com/containerd/typeurl/v2"
+	"github.com/moby/sys/symlink"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/net/bpf"
 )
 
 type ContainerdContainer struct {
@@ -46,19 +57,155 @@ func ContainerdRootDir() (string, error) {
 	return defaults.DefaultRootDir, nil
 }
 
+// ListContainerdContainers returns the containers of the k8s.io namespace of containerd on the node.
+func ListContainerdContainers(hostRoot string) ([]Container, error) {
+	cli, err := createContainerdClient()
+	if err != nil {
+		return nil, fmt.Errorf("create containerd client failed, err: %s", err)
+	}
+	defer cli.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), "k8s.io")
+
+	list, err := cli.ContainerService().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list containerd containers failed, err: %s", err)
+	}
+
+	var containers = []Container{}
+	for _, item := range list {
+		c := NewContainerdContainer(item.ID)
+		c.WithHostRoot(hostRoot)
+		containers = append(containers, c)
+	}
+
+	return containers, nil
+}
+
 func (dc *ContainerdContainer) GetInterfaces() ([]net.Interface, []netlink.Link, error) {
 	return nil, nil, ErrNotImplemented
 }
 
-func (dc *ContainerdContainer) GetInterfacesNodeMapping() (map[string]string, error) {
-	return nil, ErrNotImplemented
+// WatchContainerdEvents subscribes to the lifecycle events of the containers of the k8s.io namespace of containerd on the node.
+func WatchContainerdEvents(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+	ch := make(chan ContainerEvent)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(ch)
+
+		cli, err := createContainerdClient()
+		if err != nil {
+			errs <- fmt.Errorf("create containerd client failed, err: %s", err)
+			return
+		}
+		defer cli.Close()
+
+		envelopes, envelopeErrs := cli.Subscribe(ctx,
+			`namespace=="k8s.io",topic=="/tasks/start"`,
+			`namespace=="k8s.io",topic=="/tasks/exit"`,
+			`namespace=="k8s.io",topic=="/containers/delete"`,
+		)
+
+		for {
+			var event ContainerEvent
+
+			select {
+			case <-ctx.Done():
+				return
+
+			case err := <-envelopeErrs:
+				if ctx.Err() == nil {
+					errs <- fmt.Errorf("receive containerd events failed, err: %s", err)
+				}
+				return
+
+			case envelope := <-envelopes:
+				v, err := typeurl.UnmarshalAny(envelope.Event)
+				if err != nil {
+					continue
+				}
+
+				var id string
+				switch e := v.(type) {
+				case *apievents.TaskStart:
+					event.Type = ContainerEventStart
+					id = e.ContainerID
+				case *apievents.TaskExit:
+					// exits of exec processes are reported with their own id.
+					if e.ID != e.ContainerID {
+						continue
+					}
+					event.Type = ContainerEventStop
+					id = e.ContainerID
+				case *apievents.ContainerDelete:
+					event.Type = ContainerEventDelete
+					id = e.ID
+				default:
+					continue
+				}
+				event.RuntimeID = NewContainerdContainer(id).RuntimeID()
+			}
+
+			select {
+			case ch <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, errs
+}
+
+func (cc *ContainerdContainer) InterfaceNodeMappings() ([]InterfaceNodeMapping, error) {
+	netnsPath, err := cc.NetNSPath()
+	if err != nil {
+		return nil, fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	return resolveInterfaceNodeMappings(netnsPath, []string{hostNetNSPath(cc.hostRoot)})
+}
+
+func (cc *ContainerdContainer) GetInterfacesNodeMapping() (map[string]string, error) {
+	mappings, err := cc.InterfaceNodeMappings()
+	if err != nil {
+		return nil, fmt.Errorf("call InterfaceNodeMappings failed, err: %s", err)
+	}
+
+	return interfaceNodeMap(mappings), nil
 }
 
 func (cc *ContainerdContainer) GetOverlayDirs() (lowerDir, upperDir, mergedDir string, err error) {
+	lowerDir, upperDir, err = cc.snapshotOverlayDirs()
+	if err != nil {
+		return "", "", "", err
+	}
+
+	mergedDir, err = cc.getRootFS()
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to get merged dir: %s", err)
+	}
+
+	if lowerDir == "" {
+		err = fmt.Errorf("lower dir can not be empty")
+	}
+	if upperDir == "" {
+		err = fmt.Errorf("upper dir can not be empty")
+	}
+	if mergedDir == "" {
+		err = fmt.Errorf("merged dir can not be empty")
+	}
+
+	return
+}
 
+// snapshotOverlayDirs returns the lower and upper dirs of the overlayfs snapshot of the container,
+// which, unlike its rootfs, exist before the task is created and after it is deleted.
+func (cc *ContainerdContainer) snapshotOverlayDirs() (lowerDir, upperDir string, err error) {
 	cli, err := createContainerdClient()
 	if err != nil {
-		return "", "", "", fmt.Errorf("create containerd client failed, err: %s", err)
+		return "", "", fmt.Errorf("create containerd client failed, err: %s", err)
 	}
 	defer cli.Close()
 
@@ -67,17 +214,17 @@ func (cc *ContainerdContainer) GetOverlayDirs() (lowerDir, upperDir, mergedDir s
 	containerService := cli.ContainerService()
 	c, err := containerService.Get(ctx, cc.ID)
 	if err != nil {
-		return "", "", "", fmt.Errorf("get containerd container failed, err: %s", err)
+		return "", "", fmt.Errorf("get containerd container failed, err: %s", err)
 	}
 
 	if c.Snapshotter != "overlayfs" {
-		return "", "", "", fmt.Errorf("containerd container snapshotter is not overlayfs")
+		return "", "", fmt.Errorf("containerd container snapshotter is not overlayfs")
 	}
 
 	snapshotterService := cli.SnapshotService(c.Snapshotter)
 	mounts, err := snapshotterService.Mounts(ctx, c.SnapshotKey)
 	if err != nil {
-		return "", "", "", fmt.Errorf("got snapshotter mounts failed, err: %s", err)
+		return "", "", fmt.Errorf("got snapshotter mounts failed, err: %s", err)
 	}
 
 	for _, mount := range mounts {
@@ -90,23 +237,11 @@ func (cc *ContainerdContainer) GetOverlayDirs() (lowerDir, upperDir, mergedDir s
 			}
 		}
 	}
-
-	mergedDir, err = cc.getRootFS()
-	if err != nil {
-		return "", "", "", fmt.Errorf("failed to get merged dir: %s", err)
-	}
-
-	if lowerDir == "" {
-		err = fmt.Errorf("lower dir can not be empty")
-	}
 	if upperDir == "" {
-		err = fmt.Errorf("upper dir can not be empty")
-	}
-	if mergedDir == "" {
-		err = fmt.Errorf("merged dir can not be empty")
+		return "", "", fmt.Errorf("upper dir can not be empty")
 	}
 
-	return
+	return lowerDir, upperDir, nil
 }
 
 func (cc *ContainerdContainer) IsExist() (bool, error) {
@@ -229,10 +364,549 @@ func (cc *ContainerdContainer) Unpause() error {
 	return nil
 }
 
+func (cc *ContainerdContainer) pauseState() (running, paused bool, err error) {
+	cli, err := createContainerdClient()
+	if err != nil {
+		return false, false, fmt.Errorf("create containerd client failed, err: %s", err)
+	}
+	defer cli.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), "k8s.io")
+
+	container, err := cli.LoadContainer(ctx, cc.ID)
+	if err != nil {
+		return false, false, fmt.Errorf("load container failed, err: %s", err)
+	}
+
+	task, err := runningTask(ctx, container)
+	if errors.Is(err, ErrNotRunning) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	status, err := task.Status(ctx)
+	if err != nil {
+		return false, false, fmt.Errorf("get task status failed, err: %s", err)
+	}
+
+	return true, status.Status == containerd.Paused || status.Status == containerd.Pausing, nil
+}
+
 func (dc *ContainerdContainer) WithHostRoot(hostRoot string) {
 	dc.hostRoot = hostRoot
 }
 
+func (cc *ContainerdContainer) RuntimeID() string {
+	return fmt.Sprintf("%s://%s", RuntimeContainerd, cc.ID)
+}
+
+func (cc *ContainerdContainer) Labels() (map[string]string, error) {
+	cli, err := createContainerdClient()
+	if err != nil {
+		return nil, fmt.Errorf("create containerd client failed, err: %s", err)
+	}
+	defer cli.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), "k8s.io")
+
+	c, err := cli.LoadContainer(ctx, cc.ID)
+	if err != nil {
+		return nil, fmt.Errorf("load container failed, err: %s", err)
+	}
+
+	labels, err := c.Labels(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get container labels failed, err: %s", err)
+	}
+
+	return labels, nil
+}
+
+func (cc *ContainerdContainer) NetNSPath() (string, error) {
+	cli, err := createContainerdClient()
+	if err != nil {
+		return "", fmt.Errorf("create containerd client failed, err: %s", err)
+	}
+	defer cli.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), "k8s.io")
+
+	c, err := cli.LoadContainer(ctx, cc.ID)
+	if err != nil {
+		return "", fmt.Errorf("load container failed, err: %w", err)
+	}
+
+	// the spec of an exited container still refers to the network namespace of its pod,
+	// which may be gone or shared by other containers.
+	task, err := runningTask(ctx, c)
+	if err != nil {
+		return "", err
+	}
+
+	spec, err := c.Spec(ctx)
+	if err != nil {
+		return "", fmt.Errorf("get container spec failed, err: %s", err)
+	}
+
+	return containerdNetNSPath(cc.hostRoot, spec, task.Pid()), nil
+}
+
+// containerdNetNSPath returns the path under hostRoot of the network namespace of the container
+// with the spec, whose task has the pid.
+func containerdNetNSPath(hostRoot string, spec *specs.Spec, pid uint32) string {
+	if spec.Linux != nil {
+		for _, namespace := range spec.Linux.Namespaces {
+			if namespace.Type != specs.NetworkNamespace {
+				continue
+			}
+
+			// the network namespace created by the CRI plugin for the pod sandbox,
+			// like: "/var/run/netns/cni-9d0b1a3c-5b6f-0d2e-7a5c-4e1f2b3c4d5e"
+			if namespace.Path != "" {
+				return hostRunPath(hostRoot, namespace.Path)
+			}
+
+			// the container creates its own network namespace.
+			return namespacePaths(hostRoot, int(pid), []NamespaceType{NamespaceNet})[NamespaceNet]
+		}
+	}
+
+	// without a network namespace in the spec, the container runs in host network mode.
+	return hostNetNSPath(hostRoot)
+}
+
+// listContainerdNetNS returns the running containers of the k8s.io namespace of containerd on the node,
+// and their network namespaces, from a single listing of the containers and of the tasks.
+func listContainerdNetNS(hostRoot string) ([]containerNetNS, error) {
+	cli, err := createContainerdClient()
+	if err != nil {
+		return nil, fmt.Errorf("create containerd client failed, err: %s", err)
+	}
+	defer cli.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), "k8s.io")
+
+	list, err := cli.ContainerService().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list containerd containers failed, err: %s", err)
+	}
+
+	resp, err := cli.TaskService().List(ctx, &tasks.ListTasksRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("list containerd tasks failed, err: %s", err)
+	}
+
+	// the id of the init process of a task is the id of its container.
+	pids := map[string]uint32{}
+	for _, task := range resp.Tasks {
+		switch task.Status {
+		case tasktypes.Status_RUNNING, tasktypes.Status_PAUSED, tasktypes.Status_PAUSING:
+			pids[task.ID] = task.Pid
+		}
+	}
+
+	var items []containerNetNS
+	for _, item := range list {
+		pid, ok := pids[item.ID]
+		if !ok {
+			continue
+		}
+
+		var spec specs.Spec
+		if item.Spec == nil {
+			return nil, fmt.Errorf("container (%s) has no spec", item.ID)
+		}
+		if err := json.Unmarshal(item.Spec.GetValue(), &spec); err != nil {
+			return nil, fmt.Errorf("unmarshal spec of container (%s) failed, err: %s", item.ID, err)
+		}
+
+		c := NewContainerdContainer(item.ID)
+		c.WithHostRoot(hostRoot)
+		items = append(items, containerNetNS{container: c, netnsPath: containerdNetNSPath(hostRoot, &spec, pid)})
+	}
+
+	return items, nil
+}
+
+func (cc *ContainerdContainer) NetNSID() (uint64, error) {
+	netnsPath, err := cc.NetNSPath()
+	if err != nil {
+		return 0, err
+	}
+
+	return namespaceInode(netnsPath)
+}
+
+func (cc *ContainerdContainer) NetworkState(ctx context.Context) (*NetworkState, error) {
+	netnsPath, err := cc.NetNSPath()
+	if err != nil {
+		return nil, fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	return networkStateInNetNS(ctx, netnsPath)
+}
+
+func (cc *ContainerdContainer) AttachInterface(ctx context.Context, spec AttachInterfaceSpec) (*AttachedInterface, error) {
+	netnsPath, err := cc.NetNSPath()
+	if err != nil {
+		return nil, fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	return attachInterface(ctx, netnsPath, hostNetNSPath(cc.hostRoot), spec)
+}
+
+func (cc *ContainerdContainer) DetachInterface(ctx context.Context, name string) error {
+	netnsPath, err := cc.NetNSPath()
+	if err != nil {
+		return fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	return detachInterface(ctx, netnsPath, name)
+}
+
+func (cc *ContainerdContainer) ApplyNetworkOps(ctx context.Context, ops ...NetworkOp) ([]NetworkOp, error) {
+	netnsPath, err := cc.NetNSPath()
+	if err != nil {
+		return nil, fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	return applyNetworkOps(ctx, netnsPath, ops)
+}
+
+func (cc *ContainerdContainer) ReadSysctl(ctx context.Context, key string) (string, error) {
+	netnsPath, err := cc.NetNSPath()
+	if err != nil {
+		return "", fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	return readSysctlInNetNS(ctx, netnsPath, key)
+}
+
+func (cc *ContainerdContainer) ShapeTraffic(ctx context.Context, iface string, shaping Shaping) error {
+	netnsPath, err := cc.NetNSPath()
+	if err != nil {
+		return fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	mappings, err := cc.InterfaceNodeMappings()
+	if err != nil {
+		return fmt.Errorf("call InterfaceNodeMappings failed, err: %w", err)
+	}
+
+	return shapeTraffic(ctx, netnsPath, mappings, iface, shaping)
+}
+
+func (cc *ContainerdContainer) ClearShaping(ctx context.Context, iface string) error {
+	netnsPath, err := cc.NetNSPath()
+	if err != nil {
+		return fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	mappings, err := cc.InterfaceNodeMappings()
+	if err != nil {
+		return fmt.Errorf("call InterfaceNodeMappings failed, err: %w", err)
+	}
+
+	return clearShaping(ctx, netnsPath, mappings, iface)
+}
+
+func (cc *ContainerdContainer) PortForward(ctx context.Context, listenAddr string, containerPort int) error {
+	netnsPath, err := cc.NetNSPath()
+	if err != nil {
+		return fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	l, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return fmt.Errorf("listen on (%s) failed, err: %s", listenAddr, err)
+	}
+
+	return portForward(ctx, l, netnsPath, containerPort)
+}
+
+func (cc *ContainerdContainer) Sockets(ctx context.Context) ([]Socket, error) {
+	netnsPath, err := cc.NetNSPath()
+	if err != nil {
+		return nil, fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	return socketsInNetNS(ctx, netnsPath, cc.hostRoot)
+}
+
+func (cc *ContainerdContainer) Probe(ctx context.Context, spec ProbeSpec) (*ProbeResult, error) {
+	netnsPath, err := cc.NetNSPath()
+	if err != nil {
+		return nil, fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	resolvConf, err := cc.readResolvConf()
+	if err != nil {
+		return nil, fmt.Errorf("read resolv.conf failed, err: %s", err)
+	}
+
+	return probe(ctx, netnsPath, resolvConf, spec)
+}
+
+// readResolvConf reads the resolv.conf of the container, the one the CRI plugin bind-mounts into it
+// or else the one of its rootfs. A missing resolv.conf is read as empty.
+func (cc *ContainerdContainer) readResolvConf() ([]byte, error) {
+	cli, err := createContainerdClient()
+	if err != nil {
+		return nil, fmt.Errorf("create containerd client failed, err: %s", err)
+	}
+	defer cli.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), "k8s.io")
+
+	c, err := cli.LoadContainer(ctx, cc.ID)
+	if err != nil {
+		return nil, fmt.Errorf("load container failed, err: %s", err)
+	}
+
+	spec, err := c.Spec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get container spec failed, err: %s", err)
+	}
+
+	var p string
+	for _, m := range spec.Mounts {
+		// like: "/var/lib/containerd/io.containerd.grpc.v1.cri/sandboxes/<sandbox id>/resolv.conf"
+		if m.Destination == "/etc/resolv.conf" {
+			p = hostRunPath(cc.hostRoot, m.Source)
+		}
+	}
+
+	if p == "" {
+		rootfs := hostRunPath(cc.hostRoot, fmt.Sprintf("/run/containerd/io.containerd.runtime.v2.task/k8s.io/%s/rootfs", cc.ID))
+		if p, err = symlink.FollowSymlinkInScope(filepath.Join(rootfs, "etc/resolv.conf"), rootfs); err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := os.ReadFile(p)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (cc *ContainerdContainer) Capture(ctx context.Context, iface string, filter []bpf.RawInstruction, w io.Writer, opts CaptureOptions) (*CaptureStats, error) {
+	netnsPath, err := cc.NetNSPath()
+	if err != nil {
+		return nil, fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	if opts.HostSide {
+		mappings, err := cc.InterfaceNodeMappings()
+		if err != nil {
+			return nil, fmt.Errorf("call InterfaceNodeMappings failed, err: %w", err)
+		}
+		peer, err := hostVethPeer(mappings, iface)
+		if err != nil {
+			return nil, err
+		}
+		netnsPath, iface = peer.HostNetNSPath, peer.HostInterface
+	}
+
+	return capture(ctx, netnsPath, iface, filter, w, opts)
+}
+
+func (cc *ContainerdContainer) Changes(ctx context.Context, opts ChangesOptions) ([]Change, error) {
+	lowerDir, upperDir, err := cc.snapshotOverlayDirs()
+	if err != nil {
+		return nil, fmt.Errorf("get overlay dirs failed, err: %w", err)
+	}
+
+	return overlayChanges(ctx, hostOverlayDirs(cc.hostRoot, lowerDir), hostOverlayDirs(cc.hostRoot, upperDir), opts)
+}
+
+func (cc *ContainerdContainer) ExportUpperLayer(ctx context.Context, w io.Writer, compression LayerCompression, opts ExportLayerOptions) (*ExportedLayer, error) {
+	lowerDir, upperDir, err := cc.snapshotOverlayDirs()
+	if err != nil {
+		return nil, fmt.Errorf("get overlay dirs failed, err: %w", err)
+	}
+
+	return exportUpperLayer(ctx, hostOverlayDirs(cc.hostRoot, lowerDir), hostOverlayDirs(cc.hostRoot, upperDir), w, compression, opts)
+}
+
+func (cc *ContainerdContainer) Commit(ctx context.Context, opts CommitOptions) (*CommitResult, error) {
+	if opts.BaseImage == "" {
+		image, err := cc.imageRef()
+		if err != nil {
+			return nil, err
+		}
+		opts.BaseImage = image
+	}
+
+	lowerDir, upperDir, err := cc.snapshotOverlayDirs()
+	if err != nil {
+		return nil, fmt.Errorf("get overlay dirs failed, err: %w", err)
+	}
+
+	exportLayer := func(w io.Writer) (layer *ExportedLayer, err error) {
+		export := func() error {
+			layer, err = exportUpperLayer(ctx, hostOverlayDirs(cc.hostRoot, lowerDir), hostOverlayDirs(cc.hostRoot, upperDir), w, LayerCompressionGzip, ExportLayerOptions{})
+			return err
+		}
+		if opts.Pause {
+			err = whilePaused(cc, export)
+		} else {
+			err = export()
+		}
+		return layer, err
+	}
+
+	return commitImage(ctx, exportLayer, len(strings.Split(lowerDir, ":")), opts)
+}
+
+// imageRef returns the reference by digest of the image the container was created with,
+// eg: docker.io/library/nginx@sha256:...
+func (cc *ContainerdContainer) imageRef() (string, error) {
+	cli, err := createContainerdClient()
+	if err != nil {
+		return "", fmt.Errorf("create containerd client failed, err: %s", err)
+	}
+	defer cli.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), "k8s.io")
+
+	c, err := cli.LoadContainer(ctx, cc.ID)
+	if err != nil {
+		return "", fmt.Errorf("load container failed, err: %s", err)
+	}
+
+	info, err := c.Info(ctx)
+	if err != nil {
+		return "", fmt.Errorf("get container info failed, err: %s", err)
+	}
+	if info.Image == "" {
+		return "", fmt.Errorf("got empty image of container")
+	}
+
+	// the tag may have moved since the container was created, the image is pinned by its digest.
+	image, err := cli.ImageService().Get(ctx, info.Image)
+	if err != nil {
+		return "", fmt.Errorf("get image (%s) of container failed, the base image must be given, err: %s", info.Image, err)
+	}
+
+	return imageRepository(info.Image) + "@" + image.Target.Digest.String(), nil
+}
+
+func (cc *ContainerdContainer) SnapshotUpper(ctx context.Context, dest string) (*UpperSnapshot, error) {
+	_, upperDir, err := cc.snapshotOverlayDirs()
+	if err != nil {
+		return nil, fmt.Errorf("get overlay dirs failed, err: %w", err)
+	}
+
+	return snapshotUpper(ctx, cc, hostRunPath(cc.hostRoot, upperDir), dest)
+}
+
+func (cc *ContainerdContainer) RestoreUpper(ctx context.Context, src string) error {
+	if _, err := cc.PID(); err == nil {
+		return fmt.Errorf("can not restore the upper dir of a running container")
+	} else if !errors.Is(err, ErrNotRunning) {
+		return fmt.Errorf("get container pid failed, err: %w", err)
+	}
+
+	_, upperDir, err := cc.snapshotOverlayDirs()
+	if err != nil {
+		return fmt.Errorf("get overlay dirs failed, err: %w", err)
+	}
+
+	return restoreUpper(ctx, src, hostRunPath(cc.hostRoot, upperDir))
+}
+
+func (cc *ContainerdContainer) CopyFrom(ctx context.Context, containerPath string, w io.Writer) error {
+	return copyFromContainer(ctx, cc, cc.hostRoot, containerPath, w)
+}
+
+func (cc *ContainerdContainer) CopyTo(ctx context.Context, r io.Reader, containerPath string) error {
+	return copyToContainer(ctx, cc, cc.hostRoot, r, containerPath)
+}
+
+func (cc *ContainerdContainer) Drift(ctx context.Context, opts DriftOptions) (*DriftReport, error) {
+	lowerDir, upperDir, err := cc.snapshotOverlayDirs()
+	if err != nil {
+		return nil, fmt.Errorf("get overlay dirs failed, err: %w", err)
+	}
+
+	return analyzeDrift(ctx, hostOverlayDirs(cc.hostRoot, lowerDir), hostOverlayDirs(cc.hostRoot, upperDir), opts)
+}
+
+func (cc *ContainerdContainer) PID() (int, error) {
+	cli, err := createContainerdClient()
+	if err != nil {
+		return 0, fmt.Errorf("create containerd client failed, err: %s", err)
+	}
+	defer cli.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), "k8s.io")
+
+	container, err := cli.LoadContainer(ctx, cc.ID)
+	if err != nil {
+		return 0, fmt.Errorf("load container failed, err: %s", err)
+	}
+
+	// an exited task which is not deleted yet still has the pid, which may have been reused.
+	task, err := runningTask(ctx, container)
+	if err != nil {
+		return 0, err
+	}
+
+	pid := task.Pid()
+	if pid == 0 {
+		return 0, ErrNotRunning
+	}
+
+	return int(pid), nil
+}
+
+// runningTask returns the task of the container, or ErrNotRunning unless it is running or paused.
+func runningTask(ctx context.Context, c containerd.Container) (containerd.Task, error) {
+	task, err := c.Task(ctx, nil)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil, ErrNotRunning
+		}
+		return nil, fmt.Errorf("failed to get container task, err: %s", err)
+	}
+
+	status, err := task.Status(ctx)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil, ErrNotRunning
+		}
+		return nil, fmt.Errorf("get task status failed, err: %s", err)
+	}
+
+	switch status.Status {
+	case containerd.Running, containerd.Paused, containerd.Pausing:
+		return task, nil
+	}
+	return nil, ErrNotRunning
+}
+
+func (cc *ContainerdContainer) Do(ctx context.Context, nsTypes []NamespaceType, fn func() error) error {
+	pid, err := cc.PID()
+	if err != nil {
+		return fmt.Errorf("get container pid failed, err: %w", err)
+	}
+
+	return doInNamespaces(ctx, namespacePaths(cc.hostRoot, pid, nsTypes), fn)
+}
+
+func (cc *ContainerdContainer) DoReexec(ctx context.Context, nsTypes []NamespaceType, helper string, args ...string) ([]byte, error) {
+	pid, err := cc.PID()
+	if err != nil {
+		return nil, fmt.Errorf("get container pid failed, err: %w", err)
+	}
+
+	return doReexec(ctx, namespacePaths(cc.hostRoot, pid, nsTypes), helper, args...)
+}
+
 func (cc *ContainerdContainer) getRootFS() (string, error) {
 	cli, err := createContainerdClient()
 	if err != nil {
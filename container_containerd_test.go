@@ -0,0 +1,58 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/errdefs"
+)
+
+// fakeContainerdContainer implements the methods of containerd.Container used by runningTask.
+type fakeContainerdContainer struct {
+	containerd.Container
+	task containerd.Task
+}
+
+func (f *fakeContainerdContainer) Task(ctx context.Context, attach cio.Attach) (containerd.Task, error) {
+	if f.task == nil {
+		return nil, errdefs.ErrNotFound
+	}
+	return f.task, nil
+}
+
+type fakeContainerdTask struct {
+	containerd.Task
+	status containerd.ProcessStatus
+}
+
+func (f *fakeContainerdTask) Status(ctx context.Context) (containerd.Status, error) {
+	return containerd.Status{Status: f.status}, nil
+}
+
+func Test_runningTask(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		task    containerd.Task
+		running bool
+	}{
+		{name: "running", task: &fakeContainerdTask{status: containerd.Running}, running: true},
+		{name: "paused", task: &fakeContainerdTask{status: containerd.Paused}, running: true},
+		{name: "created", task: &fakeContainerdTask{status: containerd.Created}},
+		{name: "stopped", task: &fakeContainerdTask{status: containerd.Stopped}},
+		{name: "no task"},
+	} {
+		task, err := runningTask(context.Background(), &fakeContainerdContainer{task: tt.task})
+		if tt.running {
+			if err != nil || task != tt.task {
+				t.Errorf("%s: unexpected task %v, err: %v", tt.name, task, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrNotRunning) {
+			t.Errorf("%s: expected ErrNotRunning, got: %v", tt.name, err)
+		}
+	}
+}
@@ -2,12 +2,21 @@ package container
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
 	dockerclient "github.com/docker/docker/client"
 	"github.com/docker/docker/errdefs"
+	"github.com/moby/sys/symlink"
+	"golang.org/x/net/bpf"
 )
 
 type DockerContainer struct {
@@ -49,6 +58,110 @@ func DockerRootDir() (string, error) {
 	return info.DockerRootDir, nil
 }
 
+// ListDockerContainers returns the running docker containers on the node.
+func ListDockerContainers(hostRoot string) ([]Container, error) {
+	cli, err := createDockerClient()
+	if err != nil {
+		return nil, fmt.Errorf("create docker client failed, err: %s", err)
+	}
+	defer cli.Close()
+
+	ctx := context.Background()
+	list, err := cli.ContainerList(ctx, container.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list docker containers failed, err: %s", err)
+	}
+
+	var containers = []Container{}
+	for _, item := range list {
+		c := NewDockerContainer(item.ID)
+		c.WithHostRoot(hostRoot)
+		containers = append(containers, c)
+	}
+
+	return containers, nil
+}
+
+// WatchDockerEvents subscribes to the lifecycle events of the docker containers on the node.
+func WatchDockerEvents(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+	ch := make(chan ContainerEvent)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(ch)
+
+		cli, err := createDockerClient()
+		if err != nil {
+			errs <- fmt.Errorf("create docker client failed, err: %s", err)
+			return
+		}
+		defer cli.Close()
+
+		msgs, msgErrs := cli.Events(ctx, events.ListOptions{
+			Filters: filters.NewArgs(
+				filters.Arg("type", string(events.ContainerEventType)),
+				filters.Arg("event", string(events.ActionStart)),
+				filters.Arg("event", string(events.ActionDie)),
+				filters.Arg("event", string(events.ActionDestroy)),
+			),
+		})
+
+		for {
+			var event ContainerEvent
+
+			select {
+			case <-ctx.Done():
+				return
+
+			case err := <-msgErrs:
+				if ctx.Err() == nil {
+					errs <- fmt.Errorf("receive docker events failed, err: %s", err)
+				}
+				return
+
+			case msg := <-msgs:
+				switch msg.Action {
+				case events.ActionStart:
+					event.Type = ContainerEventStart
+				case events.ActionDie:
+					event.Type = ContainerEventStop
+				case events.ActionDestroy:
+					event.Type = ContainerEventDelete
+				default:
+					continue
+				}
+				event.RuntimeID = NewDockerContainer(msg.Actor.ID).RuntimeID()
+			}
+
+			select {
+			case ch <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, errs
+}
+
+func (dc *DockerContainer) InterfaceNodeMappings() ([]InterfaceNodeMapping, error) {
+	netnsPath, err := dc.NetNSPath()
+	if err != nil {
+		return nil, fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	return resolveInterfaceNodeMappings(netnsPath, []string{hostNetNSPath(dc.hostRoot)})
+}
+
+func (dc *DockerContainer) GetInterfacesNodeMapping() (map[string]string, error) {
+	mappings, err := dc.InterfaceNodeMappings()
+	if err != nil {
+		return nil, fmt.Errorf("call InterfaceNodeMappings failed, err: %s", err)
+	}
+
+	return interfaceNodeMap(mappings), nil
+}
+
 func (dc *DockerContainer) GetOverlayDirs() (lowerDir, upperDir, mergedDir string, err error) {
 	cli, err := createDockerClient()
 	if err != nil {
@@ -192,6 +305,457 @@ func (dc *DockerContainer) Unpause() error {
 	return nil
 }
 
+func (dc *DockerContainer) pauseState() (running, paused bool, err error) {
+	cli, err := createDockerClient()
+	if err != nil {
+		return false, false, fmt.Errorf("create docker client failed, err: %s", err)
+	}
+	defer cli.Close()
+
+	c, err := cli.ContainerInspect(context.Background(), dc.ID)
+	if err != nil {
+		return false, false, fmt.Errorf("inspect docker container failed, err: %s", err)
+	}
+	if c.State == nil {
+		return false, false, nil
+	}
+
+	// the state of a paused container is running too.
+	return c.State.Running, c.State.Paused, nil
+}
+
 func (dc *DockerContainer) WithHostRoot(hostRoot string) {
 	dc.hostRoot = hostRoot
 }
+
+func (dc *DockerContainer) RuntimeID() string {
+	return fmt.Sprintf("%s://%s", RuntimeDocker, dc.ID)
+}
+
+func (dc *DockerContainer) Labels() (map[string]string, error) {
+	cli, err := createDockerClient()
+	if err != nil {
+		return nil, fmt.Errorf("create docker client failed, err: %s", err)
+	}
+	defer cli.Close()
+
+	c, err := cli.ContainerInspect(context.Background(), dc.ID)
+	if err != nil {
+		return nil, fmt.Errorf("inspect docker container failed, err: %s", err)
+	}
+
+	labels := map[string]string{}
+	if c.Config != nil {
+		for k, v := range c.Config.Labels {
+			labels[k] = v
+		}
+	}
+
+	return labels, nil
+}
+
+func (dc *DockerContainer) NetNSPath() (string, error) {
+	cli, err := createDockerClient()
+	if err != nil {
+		return "", fmt.Errorf("create docker client failed, err: %s", err)
+	}
+	defer cli.Close()
+
+	ctx := context.Background()
+
+	c, err := cli.ContainerInspect(ctx, dc.ID)
+	if err != nil {
+		return "", fmt.Errorf("inspect docker container failed, err: %w", err)
+	}
+
+	var networkContainerID string
+
+	networkMode := c.HostConfig.NetworkMode
+
+	switch networkMode {
+	case "none", "host":
+		// for the pause container itself
+		// networkMode == "host", means the container is run in host network mode.
+		networkContainerID = dc.ID
+
+	default:
+		// container id of the associated network container
+		// like: "container:2ce8e0caf28d450170d6cfd43087a4a1d0c17f744202271b6ab7e3949e8b9975"
+		networkContainerID, _ = strings.CutPrefix(string(networkMode), "container:")
+	}
+
+	newtorkContainer, err := cli.ContainerInspect(ctx, networkContainerID)
+	if err != nil {
+		return "", fmt.Errorf("inspect docker network container (%s) failed, err: %w", networkContainerID, err)
+	}
+
+	// "SandboxKey": "/var/run/docker/netns/5048a1a60e3b",
+	sandboxKey := newtorkContainer.NetworkSettings.SandboxKey
+	if sandboxKey == "" {
+		return "", ErrNotRunning
+	}
+
+	return hostRunPath(dc.hostRoot, sandboxKey), nil
+}
+
+func (dc *DockerContainer) NetNSID() (uint64, error) {
+	netnsPath, err := dc.NetNSPath()
+	if err != nil {
+		return 0, err
+	}
+
+	return namespaceInode(netnsPath)
+}
+
+func (dc *DockerContainer) NetworkState(ctx context.Context) (*NetworkState, error) {
+	netnsPath, err := dc.NetNSPath()
+	if err != nil {
+		return nil, fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	return networkStateInNetNS(ctx, netnsPath)
+}
+
+func (dc *DockerContainer) AttachInterface(ctx context.Context, spec AttachInterfaceSpec) (*AttachedInterface, error) {
+	netnsPath, err := dc.NetNSPath()
+	if err != nil {
+		return nil, fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	return attachInterface(ctx, netnsPath, hostNetNSPath(dc.hostRoot), spec)
+}
+
+func (dc *DockerContainer) DetachInterface(ctx context.Context, name string) error {
+	netnsPath, err := dc.NetNSPath()
+	if err != nil {
+		return fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	return detachInterface(ctx, netnsPath, name)
+}
+
+func (dc *DockerContainer) ApplyNetworkOps(ctx context.Context, ops ...NetworkOp) ([]NetworkOp, error) {
+	netnsPath, err := dc.NetNSPath()
+	if err != nil {
+		return nil, fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	return applyNetworkOps(ctx, netnsPath, ops)
+}
+
+func (dc *DockerContainer) ReadSysctl(ctx context.Context, key string) (string, error) {
+	netnsPath, err := dc.NetNSPath()
+	if err != nil {
+		return "", fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	return readSysctlInNetNS(ctx, netnsPath, key)
+}
+
+func (dc *DockerContainer) ShapeTraffic(ctx context.Context, iface string, shaping Shaping) error {
+	netnsPath, err := dc.NetNSPath()
+	if err != nil {
+		return fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	mappings, err := dc.InterfaceNodeMappings()
+	if err != nil {
+		return fmt.Errorf("call InterfaceNodeMappings failed, err: %w", err)
+	}
+
+	return shapeTraffic(ctx, netnsPath, mappings, iface, shaping)
+}
+
+func (dc *DockerContainer) ClearShaping(ctx context.Context, iface string) error {
+	netnsPath, err := dc.NetNSPath()
+	if err != nil {
+		return fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	mappings, err := dc.InterfaceNodeMappings()
+	if err != nil {
+		return fmt.Errorf("call InterfaceNodeMappings failed, err: %w", err)
+	}
+
+	return clearShaping(ctx, netnsPath, mappings, iface)
+}
+
+func (dc *DockerContainer) PortForward(ctx context.Context, listenAddr string, containerPort int) error {
+	netnsPath, err := dc.NetNSPath()
+	if err != nil {
+		return fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	l, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return fmt.Errorf("listen on (%s) failed, err: %s", listenAddr, err)
+	}
+
+	return portForward(ctx, l, netnsPath, containerPort)
+}
+
+func (dc *DockerContainer) Sockets(ctx context.Context) ([]Socket, error) {
+	netnsPath, err := dc.NetNSPath()
+	if err != nil {
+		return nil, fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	return socketsInNetNS(ctx, netnsPath, dc.hostRoot)
+}
+
+func (dc *DockerContainer) Probe(ctx context.Context, spec ProbeSpec) (*ProbeResult, error) {
+	netnsPath, err := dc.NetNSPath()
+	if err != nil {
+		return nil, fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	resolvConf, err := dc.readResolvConf()
+	if err != nil {
+		return nil, fmt.Errorf("read resolv.conf failed, err: %s", err)
+	}
+
+	return probe(ctx, netnsPath, resolvConf, spec)
+}
+
+// readResolvConf reads the resolv.conf of the container, the one docker bind-mounts into it
+// or else the one of its rootfs. A missing resolv.conf is read as empty.
+func (dc *DockerContainer) readResolvConf() ([]byte, error) {
+	cli, err := createDockerClient()
+	if err != nil {
+		return nil, fmt.Errorf("create docker client failed, err: %s", err)
+	}
+	defer cli.Close()
+
+	c, err := cli.ContainerInspect(context.Background(), dc.ID)
+	if err != nil {
+		return nil, fmt.Errorf("inspect docker container failed, err: %s", err)
+	}
+
+	// "ResolvConfPath": "/var/lib/docker/containers/<id>/resolv.conf"
+	var p string
+	if c.ResolvConfPath != "" {
+		p = hostRunPath(dc.hostRoot, c.ResolvConfPath)
+	} else {
+		_, _, mergedDir, err := dc.GetOverlayDirs()
+		if err != nil {
+			return nil, fmt.Errorf("get overlay dirs failed, err: %s", err)
+		}
+		if p, err = symlink.FollowSymlinkInScope(filepath.Join(mergedDir, "etc/resolv.conf"), mergedDir); err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := os.ReadFile(p)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (dc *DockerContainer) Capture(ctx context.Context, iface string, filter []bpf.RawInstruction, w io.Writer, opts CaptureOptions) (*CaptureStats, error) {
+	netnsPath, err := dc.NetNSPath()
+	if err != nil {
+		return nil, fmt.Errorf("get netns path failed, err: %w", err)
+	}
+
+	if opts.HostSide {
+		mappings, err := dc.InterfaceNodeMappings()
+		if err != nil {
+			return nil, fmt.Errorf("call InterfaceNodeMappings failed, err: %w", err)
+		}
+		peer, err := hostVethPeer(mappings, iface)
+		if err != nil {
+			return nil, err
+		}
+		netnsPath, iface = peer.HostNetNSPath, peer.HostInterface
+	}
+
+	return capture(ctx, netnsPath, iface, filter, w, opts)
+}
+
+func (dc *DockerContainer) Changes(ctx context.Context, opts ChangesOptions) ([]Change, error) {
+	lowerDir, upperDir, _, err := dc.GetOverlayDirs()
+	if err != nil {
+		return nil, fmt.Errorf("get overlay dirs failed, err: %w", err)
+	}
+
+	return overlayChanges(ctx, hostOverlayDirs(dc.hostRoot, lowerDir), hostOverlayDirs(dc.hostRoot, upperDir), opts)
+}
+
+func (dc *DockerContainer) ExportUpperLayer(ctx context.Context, w io.Writer, compression LayerCompression, opts ExportLayerOptions) (*ExportedLayer, error) {
+	lowerDir, upperDir, _, err := dc.GetOverlayDirs()
+	if err != nil {
+		return nil, fmt.Errorf("get overlay dirs failed, err: %w", err)
+	}
+
+	return exportUpperLayer(ctx, hostOverlayDirs(dc.hostRoot, lowerDir), hostOverlayDirs(dc.hostRoot, upperDir), w, compression, opts)
+}
+
+func (dc *DockerContainer) Commit(ctx context.Context, opts CommitOptions) (*CommitResult, error) {
+	if opts.BaseImage == "" {
+		image, err := dc.imageRef(ctx)
+		if err != nil {
+			return nil, err
+		}
+		opts.BaseImage = image
+	}
+
+	lowerDir, upperDir, _, err := dc.GetOverlayDirs()
+	if err != nil {
+		return nil, fmt.Errorf("get overlay dirs failed, err: %w", err)
+	}
+
+	exportLayer := func(w io.Writer) (layer *ExportedLayer, err error) {
+		export := func() error {
+			layer, err = exportUpperLayer(ctx, hostOverlayDirs(dc.hostRoot, lowerDir), hostOverlayDirs(dc.hostRoot, upperDir), w, LayerCompressionGzip, ExportLayerOptions{})
+			return err
+		}
+		if opts.Pause {
+			err = whilePaused(dc, export)
+		} else {
+			err = export()
+		}
+		return layer, err
+	}
+
+	return commitImage(ctx, exportLayer, len(strings.Split(lowerDir, ":")), opts)
+}
+
+// imageRef returns the reference by digest of the image the container was created with, eg: nginx@sha256:...
+func (dc *DockerContainer) imageRef(ctx context.Context) (string, error) {
+	cli, err := createDockerClient()
+	if err != nil {
+		return "", fmt.Errorf("create docker client failed, err: %s", err)
+	}
+	defer cli.Close()
+
+	c, err := cli.ContainerInspect(ctx, dc.ID)
+	if err != nil {
+		return "", fmt.Errorf("inspect docker container failed, err: %s", err)
+	}
+	if c.Config == nil || c.Config.Image == "" {
+		return "", fmt.Errorf("got empty image of container")
+	}
+
+	// the tag may have moved since the container was created, the image is pinned by its digest.
+	image, _, err := cli.ImageInspectWithRaw(ctx, c.Image)
+	if err != nil {
+		return "", fmt.Errorf("inspect docker image failed, err: %s", err)
+	}
+	repository := imageRepository(c.Config.Image)
+	for _, repoDigest := range image.RepoDigests {
+		if imageRepository(repoDigest) == repository {
+			return repoDigest, nil
+		}
+	}
+	if len(image.RepoDigests) != 0 {
+		return image.RepoDigests[0], nil
+	}
+
+	return "", fmt.Errorf("image (%s) of container has no repo digest, the base image must be given", c.Config.Image)
+}
+
+func (dc *DockerContainer) SnapshotUpper(ctx context.Context, dest string) (*UpperSnapshot, error) {
+	_, upperDir, _, err := dc.GetOverlayDirs()
+	if err != nil {
+		return nil, fmt.Errorf("get overlay dirs failed, err: %w", err)
+	}
+
+	return snapshotUpper(ctx, dc, hostRunPath(dc.hostRoot, upperDir), dest)
+}
+
+func (dc *DockerContainer) RestoreUpper(ctx context.Context, src string) error {
+	if _, err := dc.PID(); err == nil {
+		return fmt.Errorf("can not restore the upper dir of a running container")
+	} else if !errors.Is(err, ErrNotRunning) {
+		return fmt.Errorf("get container pid failed, err: %w", err)
+	}
+
+	_, upperDir, _, err := dc.GetOverlayDirs()
+	if err != nil {
+		return fmt.Errorf("get overlay dirs failed, err: %w", err)
+	}
+
+	return restoreUpper(ctx, src, hostRunPath(dc.hostRoot, upperDir))
+}
+
+func (dc *DockerContainer) CopyFrom(ctx context.Context, containerPath string, w io.Writer) error {
+	cli, err := createDockerClient()
+	if err != nil {
+		return fmt.Errorf("create docker client failed, err: %s", err)
+	}
+	defer cli.Close()
+
+	rc, _, err := cli.CopyFromContainer(ctx, dc.ID, containerPath)
+	if err != nil {
+		return fmt.Errorf("copy from docker container failed, err: %s", err)
+	}
+	defer rc.Close()
+
+	if _, err := io.Copy(w, rc); err != nil {
+		return fmt.Errorf("copy archive failed, err: %s", err)
+	}
+	return nil
+}
+
+func (dc *DockerContainer) CopyTo(ctx context.Context, r io.Reader, containerPath string) error {
+	cli, err := createDockerClient()
+	if err != nil {
+		return fmt.Errorf("create docker client failed, err: %s", err)
+	}
+	defer cli.Close()
+
+	if err := cli.CopyToContainer(ctx, dc.ID, containerPath, r, container.CopyToContainerOptions{CopyUIDGID: true}); err != nil {
+		return fmt.Errorf("copy to docker container failed, err: %s", err)
+	}
+	return nil
+}
+
+func (dc *DockerContainer) Drift(ctx context.Context, opts DriftOptions) (*DriftReport, error) {
+	lowerDir, upperDir, _, err := dc.GetOverlayDirs()
+	if err != nil {
+		return nil, fmt.Errorf("get overlay dirs failed, err: %w", err)
+	}
+
+	return analyzeDrift(ctx, hostOverlayDirs(dc.hostRoot, lowerDir), hostOverlayDirs(dc.hostRoot, upperDir), opts)
+}
+
+func (dc *DockerContainer) PID() (int, error) {
+	cli, err := createDockerClient()
+	if err != nil {
+		return 0, fmt.Errorf("create docker client failed, err: %s", err)
+	}
+	defer cli.Close()
+
+	ctx := context.Background()
+
+	c, err := cli.ContainerInspect(ctx, dc.ID)
+	if err != nil {
+		return 0, fmt.Errorf("inspect docker container failed, err: %s", err)
+	}
+
+	if c.State == nil || !c.State.Running || c.State.Pid == 0 {
+		return 0, ErrNotRunning
+	}
+
+	return c.State.Pid, nil
+}
+
+func (dc *DockerContainer) Do(ctx context.Context, nsTypes []NamespaceType, fn func() error) error {
+	pid, err := dc.PID()
+	if err != nil {
+		return fmt.Errorf("get container pid failed, err: %w", err)
+	}
+
+	return doInNamespaces(ctx, namespacePaths(dc.hostRoot, pid, nsTypes), fn)
+}
+
+func (dc *DockerContainer) DoReexec(ctx context.Context, nsTypes []NamespaceType, helper string, args ...string) ([]byte, error) {
+	pid, err := dc.PID()
+	if err != nil {
+		return nil, fmt.Errorf("get container pid failed, err: %w", err)
+	}
+
+	return doReexec(ctx, namespacePaths(dc.hostRoot, pid, nsTypes), helper, args...)
+}
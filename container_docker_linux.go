@@ -1,11 +1,8 @@
 package container
 
 import (
-	"context"
 	"fmt"
 	"net"
-	"path/filepath"
-	"strings"
 
 	"github.com/containerd/containerd/pkg/netns"
 	"github.com/containernetworking/plugins/pkg/ns"
@@ -13,51 +10,14 @@ import (
 )
 
 func (dc *DockerContainer) GetInterfaces() ([]net.Interface, []netlink.Link, error) {
-	cli, err := createDockerClient()
+	netnsPath, err := dc.NetNSPath()
 	if err != nil {
-		return nil, nil, fmt.Errorf("create docker client failed, err: %s", err)
-	}
-	defer cli.Close()
-
-	ctx := context.Background()
-
-	c, err := cli.ContainerInspect(ctx, dc.ID)
-	if err != nil {
-		return nil, nil, fmt.Errorf("inspect docker container failed, err: %s", err)
-	}
-
-	var networkContainerID string
-
-	networkMode := c.HostConfig.NetworkMode
-
-	switch networkMode {
-	case "none", "host":
-		// for the pause container itself
-		// networkMode == "host", means the container is run in host network mode.
-		networkContainerID = dc.ID
-
-	default:
-		// container id of the associated network container
-		// like: "container:2ce8e0caf28d450170d6cfd43087a4a1d0c17f744202271b6ab7e3949e8b9975"
-		networkContainerID, _ = strings.CutPrefix(string(networkMode), "container:")
-	}
-
-	newtorkContainer, err := cli.ContainerInspect(ctx, networkContainerID)
-	if err != nil {
-		return nil, nil, fmt.Errorf("inspect docker network container (%s) failed, err: %s", networkContainerID, err)
+		return nil, nil, fmt.Errorf("get netns path failed, err: %s", err)
 	}
 
 	var interfaces = []net.Interface{}
 	var links = []netlink.Link{}
 
-	// "SandboxKey": "/var/run/docker/netns/5048a1a60e3b",
-	// symbolic link on node: /var/run -> /run
-	sandboxKey := newtorkContainer.NetworkSettings.SandboxKey
-	if strings.HasPrefix(sandboxKey, "/var/run") {
-		sandboxKey, _ = strings.CutPrefix(sandboxKey, "/var")
-	}
-
-	netnsPath := filepath.Join(dc.hostRoot, sandboxKey)
 	netNS := netns.LoadNetNS(netnsPath)
 	if err := netNS.Do(func(hostNs ns.NetNS) error {
 		intfs, err := net.Interfaces()
@@ -81,24 +41,3 @@ func (dc *DockerContainer) GetInterfaces() ([]net.Interface, []netlink.Link, err
 
 	return interfaces, links, nil
 }
-
-func (dc *DockerContainer) GetInterfacesNodeMapping() (map[string]string, error) {
-	_, links, err := dc.GetInterfaces()
-	if err != nil {
-		return nil, fmt.Errorf("call GetInterfaces failed, err: %s", err)
-	}
-
-	var ret = map[string]string{}
-	for _, link := range links {
-		parentIndex := link.Attrs().ParentIndex
-		if parentIndex != 0 {
-			parentLink, err := netlink.LinkByIndex(link.Attrs().ParentIndex)
-			if err != nil {
-				return nil, fmt.Errorf("call LinkByIndex failed, err: %s", err)
-			}
-			ret[link.Attrs().Name] = parentLink.Attrs().Name
-		}
-	}
-
-	return ret, nil
-}
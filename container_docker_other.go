@@ -11,7 +11,3 @@ import (
 func (dc *DockerContainer) GetInterfaces() ([]net.Interface, []netlink.Link, error) {
 	return nil, nil, ErrNotImplemented
 }
-
-func (dc *DockerContainer) GetInterfacesNodeMapping() (map[string]string, error) {
-	return nil, ErrNotImplemented
-}
@@ -0,0 +1,290 @@
+package container
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// rootFSContainer is the part of a Container which CopyFrom and CopyTo rely on for the runtimes
+// without an archive API.
+type rootFSContainer interface {
+	PID() (int, error)
+	snapshotOverlayDirs() (lowerDir, upperDir string, err error)
+}
+
+// copyFromContainer writes the file or the tree at containerPath in the root filesystem of c to w as a tar stream,
+// from the root of its init process, which has the mounts of the container, or, if c is not running,
+// from the dirs of its overlay filesystem.
+func copyFromContainer(ctx context.Context, c rootFSContainer, hostRoot, containerPath string, w io.Writer) error {
+	pid, err := c.PID()
+	if err == nil {
+		return copyFromRoot(ctx, processRootPath(hostRoot, pid), containerPath, w)
+	}
+	if !errors.Is(err, ErrNotRunning) {
+		return fmt.Errorf("get container pid failed, err: %w", err)
+	}
+
+	lowerDir, upperDir, err := c.snapshotOverlayDirs()
+	if err != nil {
+		return fmt.Errorf("get overlay dirs failed, err: %w", err)
+	}
+	view := NewOverlayView(hostOverlayDirs(hostRoot, lowerDir), hostOverlayDirs(hostRoot, upperDir))
+
+	return archiveRootTree(ctx, overlayViewRoot{view: view}, containerPath, w)
+}
+
+// copyToContainer extracts the tar stream r into the dir containerPath of c, through the root of its init process.
+// The overlay filesystem of a container which is not running is not mounted, c must be running.
+func copyToContainer(ctx context.Context, c rootFSContainer, hostRoot string, r io.Reader, containerPath string) error {
+	pid, err := c.PID()
+	if errors.Is(err, ErrNotRunning) {
+		return fmt.Errorf("can not copy into a container which is not running, err: %w", err)
+	}
+	if err != nil {
+		return fmt.Errorf("get container pid failed, err: %w", err)
+	}
+
+	return copyToRoot(ctx, processRootPath(hostRoot, pid), r, containerPath)
+}
+
+// copyFromRoot writes the file or the tree at containerPath in the root filesystem of a container at root
+// to w as a tar stream, like `docker cp`: the entries are named from the base name of containerPath, and the
+// symbolic links are archived as links. The path is resolved inside root, a symbolic link can not escape it.
+func copyFromRoot(ctx context.Context, root, containerPath string, w io.Writer) error {
+	r, err := openContainerRoot(root)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return archiveRootTree(ctx, r, containerPath, w)
+}
+
+// archiveRootTree writes the file or the tree at containerPath in root to w as a tar stream, see copyFromRoot.
+func archiveRootTree(ctx context.Context, root archiveRoot, containerPath string, w io.Writer) error {
+	name := path.Clean("/" + containerPath)
+	c := &rootArchiver{
+		ctx:   ctx,
+		root:  root,
+		tw:    tar.NewWriter(w),
+		links: hardlinks{},
+	}
+	if err := c.write(name, path.Base(name)); err != nil {
+		return fmt.Errorf("archive (%s) failed, err: %w", containerPath, err)
+	}
+
+	if err := c.tw.Close(); err != nil {
+		return fmt.Errorf("close tar writer failed, err: %s", err)
+	}
+	return nil
+}
+
+// archiveRoot is the root filesystem of a container, in which the absolute container paths are resolved.
+type archiveRoot interface {
+	Lstat(name string) (fs.FileInfo, error)
+	Readlink(name string) (string, error)
+	// ReadDir returns the sorted names of the entries of the dir name.
+	ReadDir(name string) ([]string, error)
+	Open(name string) (fs.File, error)
+}
+
+// overlayViewRoot is the root filesystem of a container read from the dirs of its overlay filesystem.
+type overlayViewRoot struct {
+	view *OverlayView
+}
+
+// viewName returns the name in the view of the container path name.
+func (r overlayViewRoot) viewName(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+func (r overlayViewRoot) Lstat(name string) (fs.FileInfo, error) {
+	return r.view.Lstat(r.viewName(name))
+}
+
+func (r overlayViewRoot) Readlink(name string) (string, error) {
+	return r.view.ReadLink(r.viewName(name))
+}
+
+func (r overlayViewRoot) ReadDir(name string) ([]string, error) {
+	entries, err := r.view.ReadDir(r.viewName(name))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names, nil
+}
+
+func (r overlayViewRoot) Open(name string) (fs.File, error) {
+	return r.view.Open(r.viewName(name))
+}
+
+type rootArchiver struct {
+	ctx  context.Context
+	root archiveRoot
+	tw   *tar.Writer
+
+	// links are the archived names of the files with several links already written.
+	links hardlinks
+}
+
+// write writes the entry of the container path name, named archiveName, and, for a dir, its entries.
+func (a *rootArchiver) write(name, archiveName string) error {
+	if err := a.ctx.Err(); err != nil {
+		return err
+	}
+
+	fi, err := a.root.Lstat(name)
+	if err != nil {
+		return err
+	}
+	// sockets can not be archived.
+	if fi.Mode()&fs.ModeSocket != 0 {
+		return nil
+	}
+
+	var link string
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		if link, err = a.root.Readlink(name); err != nil {
+			return err
+		}
+	}
+
+	// the root of the container is archived as its entries.
+	if archiveName == "/" {
+		archiveName = "."
+	}
+	hdr, err := tarHeader(fi, archiveName, link)
+	if err != nil {
+		return fmt.Errorf("create tar header of (%s) failed, err: %s", name, err)
+	}
+
+	if first, ok := a.links.first(fi, archiveName); ok {
+		hdr.Typeflag = tar.TypeLink
+		hdr.Linkname = first
+		hdr.Size = 0
+	}
+
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write tar header of (%s) failed, err: %s", name, err)
+	}
+
+	switch {
+	case hdr.Typeflag == tar.TypeReg && hdr.Size > 0:
+		f, err := a.root.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if _, err := io.CopyN(a.tw, f, hdr.Size); err != nil {
+			return fmt.Errorf("write content of (%s) failed, err: %s", name, err)
+		}
+	case fi.IsDir():
+		entries, err := a.root.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := a.write(path.Join(name, entry), path.Join(archiveName, entry)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// copyToRoot extracts the tar stream r into the dir containerPath of the root filesystem of a container at root,
+// like `docker cp`, replacing the existing entries, unless both are dirs, and preserving the ownership, modes and
+// times of the entries. All the paths, including the ones of the links and of the entries of the archive,
+// are resolved inside root, neither a symbolic link nor a ".." can escape it.
+func copyToRoot(ctx context.Context, root string, r io.Reader, containerPath string) error {
+	cr, err := openContainerRoot(root)
+	if err != nil {
+		return err
+	}
+	defer cr.Close()
+
+	dest := path.Clean("/" + containerPath)
+	fi, err := cr.Stat(dest)
+	if err != nil {
+		return fmt.Errorf("stat destination (%s) failed, err: %w", containerPath, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("destination (%s) is not a dir", containerPath)
+	}
+
+	var dirs dirTimes
+
+	tr := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read tar failed, err: %s", err)
+		}
+
+		// the names are made relative to dest, a ".." can not go above it.
+		rel := path.Clean("/" + hdr.Name)
+		if rel == "/" {
+			continue
+		}
+		name := path.Join(dest, rel)
+
+		if hdr.Typeflag == tar.TypeLink {
+			// the target of a hardlink is an entry of the archive.
+			if err := cr.Link(path.Join(dest, path.Clean("/"+hdr.Linkname)), name); err != nil {
+				return fmt.Errorf("create hardlink (%s) failed, err: %w", hdr.Name, err)
+			}
+			continue
+		}
+
+		if err := cr.Create(name, hdr, tr); err != nil {
+			return fmt.Errorf("extract (%s) failed, err: %w", hdr.Name, err)
+		}
+
+		setTimes := func() error {
+			if err := cr.Chtimes(name, hdr.AccessTime, hdr.ModTime); err != nil {
+				return fmt.Errorf("set times of (%s) failed, err: %w", hdr.Name, err)
+			}
+			return nil
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirs.add(setTimes)
+			continue
+		}
+		if err := setTimes(); err != nil {
+			return err
+		}
+	}
+
+	return dirs.set()
+}
+
+// processRootPath returns the path under hostRoot of the root directory of the process pid,
+// which is the root filesystem of its container, with the mounts of the container.
+func processRootPath(hostRoot string, pid int) string {
+	return filepath.Join(hostRoot, "proc", strconv.Itoa(pid), "root")
+}
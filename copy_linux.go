@@ -0,0 +1,306 @@
+package container
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/moby/sys/symlink"
+	"golang.org/x/sys/unix"
+)
+
+// containerRoot accesses the root filesystem of a container with the paths resolved as if it were the root
+// of the process: absolute symbolic links and ".." are resolved inside it.
+//
+// The files are opened with openat2 and RESOLVE_IN_ROOT, which makes the resolution atomic. On kernels without
+// openat2, the paths are resolved by FollowSymlinkInScope, and only the last component is opened without
+// following a symbolic link.
+type containerRoot struct {
+	path string
+	fd   int
+}
+
+func openContainerRoot(root string) (*containerRoot, error) {
+	fd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open container root (%s) failed, err: %s", root, err)
+	}
+	return &containerRoot{path: root, fd: fd}, nil
+}
+
+func (r *containerRoot) Close() error {
+	return unix.Close(r.fd)
+}
+
+// open opens the container path name with flags.
+func (r *containerRoot) open(name string, flags int) (int, error) {
+	rel := "." + path.Clean("/"+name)
+
+	how := &unix.OpenHow{
+		Flags:   uint64(flags | unix.O_CLOEXEC),
+		Resolve: unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS,
+	}
+	for {
+		fd, err := unix.Openat2(r.fd, rel, how)
+		// the resolution is retried when a rename happened concurrently.
+		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if !errors.Is(err, unix.ENOSYS) {
+			return fd, wrapPathError("open", name, err)
+		}
+		break
+	}
+
+	var p string
+	var err error
+	if flags&unix.O_NOFOLLOW != 0 {
+		dir, base := path.Split(path.Clean("/" + name))
+		if p, err = symlink.FollowSymlinkInScope(filepath.Join(r.path, dir), r.path); err == nil {
+			p = filepath.Join(p, base)
+		}
+	} else {
+		p, err = symlink.FollowSymlinkInScope(filepath.Join(r.path, name), r.path)
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	fd, err := unix.Open(p, flags|unix.O_CLOEXEC, 0)
+	return fd, wrapPathError("open", name, err)
+}
+
+// openParent opens the parent dir of the container path name, and returns it with the base name of name.
+func (r *containerRoot) openParent(name string) (int, string, error) {
+	dir, base := path.Split(path.Clean("/" + name))
+	if base == "" {
+		return -1, "", fmt.Errorf("the root has no parent")
+	}
+	fd, err := r.open(dir, unix.O_PATH|unix.O_DIRECTORY)
+	return fd, base, err
+}
+
+func (r *containerRoot) stat(name string, flags int) (fs.FileInfo, error) {
+	fd, err := r.open(name, unix.O_PATH|flags)
+	if err != nil {
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), path.Base(name))
+	defer f.Close()
+
+	return f.Stat()
+}
+
+// Lstat returns the file info of name, without following a symbolic link.
+func (r *containerRoot) Lstat(name string) (fs.FileInfo, error) {
+	return r.stat(name, unix.O_NOFOLLOW)
+}
+
+// Stat returns the file info of name, following a symbolic link.
+func (r *containerRoot) Stat(name string) (fs.FileInfo, error) {
+	return r.stat(name, 0)
+}
+
+func (r *containerRoot) Readlink(name string) (string, error) {
+	fd, err := r.open(name, unix.O_PATH|unix.O_NOFOLLOW)
+	if err != nil {
+		return "", err
+	}
+	defer unix.Close(fd)
+
+	buf := make([]byte, unix.PathMax)
+	n, err := unix.Readlinkat(fd, "", buf)
+	if err != nil {
+		return "", wrapPathError("readlink", name, err)
+	}
+	return string(buf[:n]), nil
+}
+
+// ReadDir returns the sorted names of the entries of the dir name.
+func (r *containerRoot) ReadDir(name string) ([]string, error) {
+	fd, err := r.open(name, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW)
+	if err != nil {
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), name)
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Open opens the regular file name for reading.
+func (r *containerRoot) Open(name string) (fs.File, error) {
+	fd, err := r.open(name, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+// Create creates the entry name of the tar header hdr, with the content read from content for a regular file.
+// An existing entry is replaced, unless both are dirs.
+func (r *containerRoot) Create(name string, hdr *tar.Header, content io.Reader) error {
+	// the archive may not have the entries of the parent dirs.
+	if err := r.mkdirAll(path.Dir(path.Clean("/" + name))); err != nil {
+		return err
+	}
+
+	dirfd, base, err := r.openParent(name)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dirfd)
+
+	var st unix.Stat_t
+	if err := unix.Fstatat(dirfd, base, &st, unix.AT_SYMLINK_NOFOLLOW); err == nil {
+		if !(st.Mode&unix.S_IFMT == unix.S_IFDIR && hdr.Typeflag == tar.TypeDir) {
+			// the parent is pinned by its fd, RemoveAll does not follow symbolic links.
+			if err := os.RemoveAll(filepath.Join("/proc/self/fd", strconv.Itoa(dirfd), base)); err != nil {
+				return err
+			}
+		}
+	}
+
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := unix.Mkdirat(dirfd, base, 0700); err != nil && !errors.Is(err, unix.EEXIST) {
+			return wrapPathError("mkdir", name, err)
+		}
+	case tar.TypeReg:
+		fd, err := unix.Openat(dirfd, base, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0600)
+		if err != nil {
+			return wrapPathError("create", name, err)
+		}
+		f := os.NewFile(uintptr(fd), name)
+		defer f.Close()
+		if _, err := io.Copy(f, content); err != nil {
+			return fmt.Errorf("write content failed, err: %s", err)
+		}
+	case tar.TypeSymlink:
+		if err := unix.Symlinkat(hdr.Linkname, dirfd, base); err != nil {
+			return wrapPathError("symlink", name, err)
+		}
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		typ := map[byte]uint32{tar.TypeChar: unix.S_IFCHR, tar.TypeBlock: unix.S_IFBLK, tar.TypeFifo: unix.S_IFIFO}[hdr.Typeflag]
+		dev := unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+		if err := unix.Mknodat(dirfd, base, typ|0600, int(dev)); err != nil {
+			return wrapPathError("mknod", name, err)
+		}
+	default:
+		return fmt.Errorf("unsupported tar entry type %q", hdr.Typeflag)
+	}
+
+	if err := unix.Fchownat(dirfd, base, hdr.Uid, hdr.Gid, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return wrapPathError("chown", name, err)
+	}
+	if hdr.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+	// after the chown, which clears the setuid and setgid bits.
+	return fchmodatNoFollow(dirfd, base, mode)
+}
+
+// mkdirAll creates the dir name and its missing parents with mode 0755.
+func (r *containerRoot) mkdirAll(name string) error {
+	if fi, err := r.Stat(name); err == nil {
+		if !fi.IsDir() {
+			return wrapPathError("mkdir", name, unix.ENOTDIR)
+		}
+		return nil
+	}
+
+	if err := r.mkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+
+	dirfd, base, err := r.openParent(name)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dirfd)
+
+	if err := unix.Mkdirat(dirfd, base, 0755); err != nil && !errors.Is(err, unix.EEXIST) {
+		return wrapPathError("mkdir", name, err)
+	}
+	return nil
+}
+
+// fchmodatNoFollow changes the mode of the entry base of the dir dirfd, if it is not a symbolic link.
+// The entry is opened with O_PATH and changed through its /proc/self/fd link, like glibc does.
+func fchmodatNoFollow(dirfd int, base string, mode uint32) error {
+	fd, err := unix.Openat(dirfd, base, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return wrapPathError("chmod", base, err)
+	}
+	defer unix.Close(fd)
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return wrapPathError("chmod", base, err)
+	}
+	if st.Mode&unix.S_IFMT == unix.S_IFLNK {
+		return wrapPathError("chmod", base, unix.ELOOP)
+	}
+	return wrapPathError("chmod", base, unix.Chmod(filepath.Join("/proc/self/fd", strconv.Itoa(fd)), mode))
+}
+
+// Link creates the hardlink newname to the existing entry oldname, without following a symbolic link.
+func (r *containerRoot) Link(oldname, newname string) error {
+	olddirfd, oldbase, err := r.openParent(oldname)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(olddirfd)
+
+	dirfd, base, err := r.openParent(newname)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dirfd)
+
+	var st unix.Stat_t
+	if err := unix.Fstatat(dirfd, base, &st, unix.AT_SYMLINK_NOFOLLOW); err == nil {
+		if err := os.RemoveAll(filepath.Join("/proc/self/fd", strconv.Itoa(dirfd), base)); err != nil {
+			return err
+		}
+	}
+
+	return wrapPathError("link", newname, unix.Linkat(olddirfd, oldbase, dirfd, base, 0))
+}
+
+// Chtimes sets the access and modification times of name, without following a symbolic link.
+// A zero access time is set to the modification time.
+func (r *containerRoot) Chtimes(name string, atime, mtime time.Time) error {
+	dirfd, base, err := r.openParent(name)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dirfd)
+
+	if atime.IsZero() {
+		atime = mtime
+	}
+	times := []unix.Timespec{unix.NsecToTimespec(atime.UnixNano()), unix.NsecToTimespec(mtime.UnixNano())}
+	return wrapPathError("chtimes", name, unix.UtimesNanoAt(dirfd, base, times, unix.AT_SYMLINK_NOFOLLOW))
+}
+
+func wrapPathError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
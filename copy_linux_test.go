@@ -0,0 +1,309 @@
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// newTestContainerRoot creates a root filesystem with symbolic links which would escape it if they were
+// resolved on the host.
+func newTestContainerRoot(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"etc/passwd":     "root:x:0:0::/root:/bin/sh\n",
+		"etc/app/a.conf": "a",
+		"etc/app/b.conf": "b",
+	} {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, target := range map[string]string{
+		"etc/app/passwd": "/etc/passwd",
+		"abs":            "/etc",
+		"escape":         "../../../../../../../../",
+		"data":           "/srv/data",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, "srv/data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(root, "etc/app/a.conf"), filepath.Join(root, "etc/app/hard.conf")); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func readTestTar(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+
+	entries := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			data, _ := io.ReadAll(tr)
+			entries[hdr.Name] = string(data)
+		case tar.TypeSymlink:
+			entries[hdr.Name] = "-> " + hdr.Linkname
+		case tar.TypeLink:
+			entries[hdr.Name] = "=> " + hdr.Linkname
+		default:
+			entries[hdr.Name] = ""
+		}
+	}
+}
+
+func Test_copyFromRoot(t *testing.T) {
+	root := newTestContainerRoot(t)
+	ctx := context.Background()
+
+	for containerPath, expected := range map[string]map[string]string{
+		"/etc/app": {
+			"app/":          "",
+			"app/a.conf":    "a",
+			"app/b.conf":    "b",
+			"app/hard.conf": "=> app/a.conf",
+			"app/passwd":    "-> /etc/passwd",
+		},
+		// the parents are resolved inside the root
+		"/abs/passwd":                   {"passwd": "root:x:0:0::/root:/bin/sh\n"},
+		"/escape/etc/passwd":            {"passwd": "root:x:0:0::/root:/bin/sh\n"},
+		"/../../etc/app/../passwd":      {"passwd": "root:x:0:0::/root:/bin/sh\n"},
+		"/escape/escape/etc/app/b.conf": {"b.conf": "b"},
+		// the last component is archived as a link
+		"/abs": {"abs": "-> /etc"},
+	} {
+		var buf bytes.Buffer
+		if err := copyFromRoot(ctx, root, containerPath, &buf); err != nil {
+			t.Errorf("%s: %s", containerPath, err)
+			continue
+		}
+		if entries := readTestTar(t, &buf); !reflect.DeepEqual(entries, expected) {
+			t.Errorf("%s: unexpected entries %v, expected %v", containerPath, entries, expected)
+		}
+	}
+
+	if err := copyFromRoot(ctx, root, "/missing", io.Discard); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func Test_copyToRoot(t *testing.T) {
+	requireRoot(t)
+
+	root := newTestContainerRoot(t)
+	outside := t.TempDir()
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range []struct {
+		hdr     tar.Header
+		content string
+	}{
+		{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "conf/", Mode: 0750, Uid: 1000, Gid: 1000}},
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "conf/app.yaml", Mode: 0640, Uid: 1000, Gid: 1000}, content: "port: 80\n"},
+		{hdr: tar.Header{Typeflag: tar.TypeLink, Name: "conf/app-link.yaml", Linkname: "conf/app.yaml"}},
+		// escapes with ".." and through symbolic links
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "../../../" + filepath.Base(outside) + "/dotdot", Mode: 0644}, content: "dotdot"},
+		{hdr: tar.Header{Typeflag: tar.TypeSymlink, Name: "out", Linkname: outside}},
+		{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "out/", Mode: 0755}},
+		{hdr: tar.Header{Typeflag: tar.TypeSymlink, Name: "conf/passwd", Linkname: "/etc/passwd"}},
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "conf/passwd", Mode: 0600}, content: "replaced link"},
+	} {
+		entry.hdr.Size = int64(len(entry.content))
+		if err := tw.WriteHeader(&entry.hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(entry.content))
+	}
+	tw.Close()
+
+	// the destination is resolved inside the root
+	if err := copyToRoot(ctx, root, &buf, "/escape/data"); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(root, "srv/data")
+	data, err := os.ReadFile(filepath.Join(dest, "conf/app.yaml"))
+	if err != nil || string(data) != "port: 80\n" {
+		t.Errorf("unexpected content %q, err: %v", data, err)
+	}
+	fi, err := os.Stat(filepath.Join(dest, "conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := fi.Sys().(*syscall.Stat_t); fi.Mode().Perm() != 0750 || st.Uid != 1000 || st.Gid != 1000 {
+		t.Errorf("unexpected mode %s or owner %d:%d", fi.Mode(), st.Uid, st.Gid)
+	}
+	if fi, _ := os.Stat(filepath.Join(dest, "conf/app-link.yaml")); fi == nil || fi.Sys().(*syscall.Stat_t).Nlink != 2 {
+		t.Error("hardlink not created")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, filepath.Base(outside), "dotdot")); string(data) != "dotdot" {
+		t.Errorf("unexpected content %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "etc/passwd")); string(data) != "root:x:0:0::/root:/bin/sh\n" {
+		t.Errorf("file replaced through a link: %q", data)
+	}
+
+	// nothing is written outside the root
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("unexpected entries %v outside the root", entries)
+	}
+
+	if err := copyToRoot(ctx, root, bytes.NewReader(nil), "/etc/passwd"); err == nil {
+		t.Error("extracted into a file")
+	}
+}
+
+type fakeRootFSContainer struct {
+	pid                int
+	lowerDir, upperDir string
+}
+
+func (c *fakeRootFSContainer) PID() (int, error) {
+	if c.pid == 0 {
+		return 0, ErrNotRunning
+	}
+	return c.pid, nil
+}
+
+func (c *fakeRootFSContainer) snapshotOverlayDirs() (string, string, error) {
+	return c.lowerDir, c.upperDir, nil
+}
+
+// newTestHostProcRoot returns a host root in which the root of the process pid is root.
+func newTestHostProcRoot(t *testing.T, pid int, root string) string {
+	t.Helper()
+
+	hostRoot := t.TempDir()
+	procDir := filepath.Join(hostRoot, "proc", strconv.Itoa(pid))
+	if err := os.MkdirAll(procDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(root, filepath.Join(procDir, "root")); err != nil {
+		t.Fatal(err)
+	}
+	return hostRoot
+}
+
+func Test_copyFromContainer(t *testing.T) {
+	requireRoot(t)
+
+	ctx := context.Background()
+	root := newTestContainerRoot(t)
+
+	// a running container is read from the root of its init process
+	running := &fakeRootFSContainer{pid: 42}
+	var buf bytes.Buffer
+	if err := copyFromContainer(ctx, running, newTestHostProcRoot(t, 42, root), "/etc/app", &buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"app/":          "",
+		"app/a.conf":    "a",
+		"app/b.conf":    "b",
+		"app/hard.conf": "=> app/a.conf",
+		"app/passwd":    "-> /etc/passwd",
+	}
+	if entries := readTestTar(t, &buf); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("unexpected entries %v, expected %v", entries, expected)
+	}
+
+	// a stopped container is read from its overlay dirs, the root being the lower dir
+	upper := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(upper, "etc/app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Mknod(filepath.Join(upper, "etc/app/b.conf"), unix.S_IFCHR, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(upper, "etc/app/c.conf"), []byte("c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := &fakeRootFSContainer{lowerDir: root, upperDir: upper}
+	for containerPath, expected := range map[string]map[string]string{
+		"/etc/app": {
+			"app/":          "",
+			"app/a.conf":    "a",
+			"app/c.conf":    "c",
+			"app/hard.conf": "=> app/a.conf",
+			"app/passwd":    "-> /etc/passwd",
+		},
+		// the links are resolved inside the view
+		"/escape/etc/passwd": {"passwd": "root:x:0:0::/root:/bin/sh\n"},
+		"/abs/app/c.conf":    {"c.conf": "c"},
+	} {
+		buf.Reset()
+		if err := copyFromContainer(ctx, stopped, "", containerPath, &buf); err != nil {
+			t.Errorf("%s: %s", containerPath, err)
+			continue
+		}
+		if entries := readTestTar(t, &buf); !reflect.DeepEqual(entries, expected) {
+			t.Errorf("%s: unexpected entries %v, expected %v", containerPath, entries, expected)
+		}
+	}
+
+	if err := copyFromContainer(ctx, stopped, "", "/etc/app/b.conf", io.Discard); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func Test_copyToContainer(t *testing.T) {
+	requireRoot(t)
+
+	ctx := context.Background()
+	root := newTestContainerRoot(t)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "new.conf", Mode: 0644, Size: 3}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte("new"))
+	tw.Close()
+	archive := buf.Bytes()
+
+	running := &fakeRootFSContainer{pid: 42}
+	if err := copyToContainer(ctx, running, newTestHostProcRoot(t, 42, root), bytes.NewReader(archive), "/etc/app"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "etc/app/new.conf")); err != nil || string(data) != "new" {
+		t.Errorf("unexpected content %q, err: %v", data, err)
+	}
+
+	stopped := &fakeRootFSContainer{lowerDir: root, upperDir: t.TempDir()}
+	if err := copyToContainer(ctx, stopped, "", bytes.NewReader(archive), "/etc/app"); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+//go:build !linux
+
+package container
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"time"
+)
+
+type containerRoot struct{}
+
+func openContainerRoot(root string) (*containerRoot, error) {
+	return nil, ErrNotImplemented
+}
+
+func (r *containerRoot) Close() error {
+	return ErrNotImplemented
+}
+
+func (r *containerRoot) Lstat(name string) (fs.FileInfo, error) {
+	return nil, ErrNotImplemented
+}
+
+func (r *containerRoot) Stat(name string) (fs.FileInfo, error) {
+	return nil, ErrNotImplemented
+}
+
+func (r *containerRoot) Readlink(name string) (string, error) {
+	return "", ErrNotImplemented
+}
+
+func (r *containerRoot) ReadDir(name string) ([]string, error) {
+	return nil, ErrNotImplemented
+}
+
+func (r *containerRoot) Open(name string) (fs.File, error) {
+	return nil, ErrNotImplemented
+}
+
+func (r *containerRoot) Create(name string, hdr *tar.Header, content io.Reader) error {
+	return ErrNotImplemented
+}
+
+func (r *containerRoot) Link(oldname, newname string) error {
+	return ErrNotImplemented
+}
+
+func (r *containerRoot) Chtimes(name string, atime, mtime time.Time) error {
+	return ErrNotImplemented
+}
@@ -0,0 +1,287 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// resolvConf is the part of a resolv.conf used by dnsResolver, with the defaults of glibc.
+type resolvConf struct {
+	// nameservers are ip:port
+	nameservers []string
+	search      []string
+	ndots       int
+	timeout     time.Duration
+	attempts    int
+}
+
+// parseResolvConf parses the content of a resolv.conf. Unknown lines are ignored.
+func parseResolvConf(b []byte) resolvConf {
+	conf := resolvConf{
+		ndots:    1,
+		timeout:  5 * time.Second,
+		attempts: 2,
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "nameserver":
+			// a link-local nameserver may have a zone, eg: fe80::1%eth0
+			ip, _, _ := strings.Cut(fields[1], "%")
+			if net.ParseIP(ip) != nil {
+				conf.nameservers = append(conf.nameservers, net.JoinHostPort(fields[1], "53"))
+			}
+		case "search":
+			// the last search line wins
+			conf.search = conf.search[:0]
+			for _, domain := range fields[1:] {
+				conf.search = append(conf.search, strings.TrimSuffix(domain, "."))
+			}
+		case "domain":
+			conf.search = []string{strings.TrimSuffix(fields[1], ".")}
+		case "options":
+			for _, option := range fields[1:] {
+				name, value, _ := strings.Cut(option, ":")
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					continue
+				}
+				switch name {
+				case "ndots":
+					conf.ndots = min(n, 15)
+				case "timeout":
+					conf.timeout = time.Duration(max(n, 1)) * time.Second
+				case "attempts":
+					conf.attempts = min(max(n, 1), 5)
+				}
+			}
+		}
+	}
+
+	if len(conf.nameservers) == 0 {
+		conf.nameservers = []string{"127.0.0.1:53", "[::1]:53"}
+	}
+
+	return conf
+}
+
+// nameList returns the fully qualified names to try for name, in order, applying the search domains
+// before the name itself if it has less than ndots dots.
+func (conf resolvConf) nameList(name string) []string {
+	if strings.HasSuffix(name, ".") {
+		return []string{name}
+	}
+
+	var names []string
+	for _, domain := range conf.search {
+		if domain != "" {
+			names = append(names, name+"."+domain+".")
+		}
+	}
+
+	if strings.Count(name, ".") >= conf.ndots {
+		return append([]string{name + "."}, names...)
+	}
+	return append(names, name+".")
+}
+
+// dnsResolver looks up A and AAAA records like the stub resolver of glibc would with the given resolv.conf,
+// using dial to reach the nameservers.
+type dnsResolver struct {
+	conf resolvConf
+	dial func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// lookup returns the addresses of name, for qtypes A and AAAA, or both if qtypes is empty.
+// Errors are *probeError classified as nxdomain, no-records, dns or timeout.
+func (r *dnsResolver) lookup(ctx context.Context, name string, qtypes []string) ([]string, error) {
+	if len(qtypes) == 0 {
+		qtypes = []string{"A", "AAAA"}
+	}
+
+	var lastErr error
+	nxdomain := true
+
+	for _, fqdn := range r.conf.nameList(name) {
+		var addrs []string
+		for _, qtype := range qtypes {
+			t := dnsmessage.TypeA
+			if qtype == "AAAA" {
+				t = dnsmessage.TypeAAAA
+			}
+
+			// like glibc, a failure of a name of the search list moves on to the next name,
+			// unless the lookup itself is invalid or cancelled.
+			msg, err := r.exchange(ctx, fqdn, t)
+			if err != nil {
+				var invalid *invalidProbeError
+				if errors.As(err, &invalid) || ctx.Err() != nil {
+					return nil, err
+				}
+				nxdomain = false
+				lastErr = err
+				continue
+			}
+
+			switch msg.RCode {
+			case dnsmessage.RCodeSuccess:
+				nxdomain = false
+				for _, answer := range msg.Answers {
+					switch body := answer.Body.(type) {
+					case *dnsmessage.AResource:
+						addrs = append(addrs, net.IP(body.A[:]).String())
+					case *dnsmessage.AAAAResource:
+						addrs = append(addrs, net.IP(body.AAAA[:]).String())
+					}
+				}
+			case dnsmessage.RCodeNameError:
+			default:
+				nxdomain = false
+				lastErr = &probeError{class: ProbeErrorDNS, msg: fmt.Sprintf("lookup %s failed: %s", fqdn, msg.RCode)}
+			}
+		}
+
+		if len(addrs) > 0 {
+			return addrs, nil
+		}
+	}
+
+	switch {
+	case lastErr != nil:
+		return nil, lastErr
+	case nxdomain:
+		return nil, &probeError{class: ProbeErrorNXDomain, msg: fmt.Sprintf("lookup %s: no such host", name)}
+	default:
+		return nil, &probeError{class: ProbeErrorNoRecords, msg: fmt.Sprintf("lookup %s: no %s records", name, strings.Join(qtypes, "/"))}
+	}
+}
+
+// exchange sends the query to the nameservers in turn, for the configured number of attempts,
+// and returns the first response. A truncated response over udp is retried over tcp.
+func (r *dnsResolver) exchange(ctx context.Context, fqdn string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	qname, err := dnsmessage.NewName(fqdn)
+	if err != nil {
+		return nil, &invalidProbeError{fmt.Errorf("invalid name (%s), err: %s", fqdn, err)}
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < r.conf.attempts; attempt++ {
+		for _, server := range r.conf.nameservers {
+			if ctx.Err() != nil {
+				return nil, &probeError{class: ProbeErrorTimeout, msg: fmt.Sprintf("lookup %s: %s", fqdn, ctx.Err())}
+			}
+
+			msg, err := r.exchangeWith(ctx, "udp", server, qname, qtype)
+			if err == nil && msg.Truncated {
+				msg, err = r.exchangeWith(ctx, "tcp", server, qname, qtype)
+			}
+			if err == nil {
+				return msg, nil
+			}
+			lastErr = err
+		}
+	}
+
+	class := ProbeErrorDNS
+	var ne net.Error
+	if errors.As(lastErr, &ne) && ne.Timeout() {
+		class = ProbeErrorTimeout
+	}
+	return nil, &probeError{class: class, msg: fmt.Sprintf("lookup %s failed, err: %s", fqdn, lastErr)}
+}
+
+func (r *dnsResolver) exchangeWith(ctx context.Context, network, server string, qname dnsmessage.Name, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return nil, err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.conf.timeout)
+	defer cancel()
+
+	conn, err := r.dial(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if network == "tcp" {
+		packed = append(binary.BigEndian.AppendUint16(nil, uint16(len(packed))), packed...)
+	}
+	if _, err := conn.Write(packed); err != nil {
+		return nil, err
+	}
+
+	for {
+		var buf []byte
+		if network == "tcp" {
+			var length [2]byte
+			if _, err := io.ReadFull(conn, length[:]); err != nil {
+				return nil, err
+			}
+			buf = make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				return nil, err
+			}
+		} else {
+			buf = make([]byte, 1232)
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, err
+			}
+			buf = buf[:n]
+		}
+
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf); err != nil {
+			if network == "tcp" {
+				return nil, err
+			}
+			// ignore garbage on udp and wait for the real response
+			continue
+		}
+		if msg.ID != id || !msg.Response || len(msg.Questions) != 1 || msg.Questions[0].Name != qname || msg.Questions[0].Type != qtype {
+			if network == "tcp" {
+				return nil, fmt.Errorf("mismatched response")
+			}
+			continue
+		}
+
+		return &msg, nil
+	}
+}
@@ -0,0 +1,153 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func Test_parseResolvConf(t *testing.T) {
+	conf := parseResolvConf([]byte(`# generated by kubelet
+nameserver 10.96.0.10
+nameserver fe80::1%eth0
+nameserver not-an-ip
+search default.svc.cluster.local svc.cluster.local cluster.local.
+options ndots:5 timeout:2 attempts:3 edns0
+`))
+
+	expected := resolvConf{
+		nameservers: []string{"10.96.0.10:53", "[fe80::1%eth0]:53"},
+		search:      []string{"default.svc.cluster.local", "svc.cluster.local", "cluster.local"},
+		ndots:       5,
+		timeout:     2 * time.Second,
+		attempts:    3,
+	}
+	if !reflect.DeepEqual(conf, expected) {
+		t.Errorf("got %+v, expected %+v", conf, expected)
+	}
+
+	empty := parseResolvConf(nil)
+	if !reflect.DeepEqual(empty.nameservers, []string{"127.0.0.1:53", "[::1]:53"}) || empty.ndots != 1 || empty.attempts != 2 {
+		t.Errorf("unexpected defaults: %+v", empty)
+	}
+}
+
+func Test_resolvConf_nameList(t *testing.T) {
+	conf := resolvConf{search: []string{"ns.svc.cluster.local", "cluster.local"}, ndots: 5}
+
+	tests := []struct {
+		name     string
+		expected []string
+	}{
+		{
+			name:     "kubernetes.default",
+			expected: []string{"kubernetes.default.ns.svc.cluster.local.", "kubernetes.default.cluster.local.", "kubernetes.default."},
+		},
+		{
+			name:     "a.b.c.d.e.example.com",
+			expected: []string{"a.b.c.d.e.example.com.", "a.b.c.d.e.example.com.ns.svc.cluster.local.", "a.b.c.d.e.example.com.cluster.local."},
+		},
+		{
+			name:     "example.com.",
+			expected: []string{"example.com."},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := conf.nameList(tt.name); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("nameList(%s) = %v, expected %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+// serveTestDNS answers the queries received on conn: the A queries of svc.test. resolve to 127.0.0.1,
+// and the ones of the names under ok.test to 192.0.2.1, the names under servfail.test fail, the ones under
+// timeout.test are not answered, and the other names do not exist.
+func serveTestDNS(conn net.PacketConn) {
+	buf := make([]byte, 1232)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var query dnsmessage.Message
+		if err := query.Unpack(buf[:n]); err != nil || len(query.Questions) != 1 {
+			continue
+		}
+		q := query.Questions[0]
+		name := q.Name.String()
+
+		resp := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true, RCode: dnsmessage.RCodeNameError},
+			Questions: query.Questions,
+		}
+		switch {
+		case name == "svc.test.":
+			resp.RCode = dnsmessage.RCodeSuccess
+			if q.Type == dnsmessage.TypeA {
+				resp.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 30},
+					Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+				}}
+			}
+		case strings.HasSuffix(name, ".timeout.test."):
+			continue
+		case strings.HasSuffix(name, ".servfail.test."):
+			resp.RCode = dnsmessage.RCodeServerFailure
+		case strings.HasSuffix(name, ".ok.test."):
+			resp.RCode = dnsmessage.RCodeSuccess
+			if q.Type == dnsmessage.TypeA {
+				resp.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+				}}
+			}
+		}
+		packed, err := resp.Pack()
+		if err != nil {
+			continue
+		}
+		conn.WriteTo(packed, addr)
+	}
+}
+
+func Test_dnsResolver_lookup(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go serveTestDNS(conn)
+
+	var d net.Dialer
+	r := &dnsResolver{
+		conf: resolvConf{
+			nameservers: []string{conn.LocalAddr().String()},
+			search:      []string{"timeout.test", "servfail.test", "ok.test"},
+			ndots:       1,
+			timeout:     100 * time.Millisecond,
+			attempts:    1,
+		},
+		dial: d.DialContext,
+	}
+
+	// the failures of the first names of the search list are skipped.
+	addrs, err := r.lookup(context.Background(), "app", []string{"A"})
+	if err != nil || !reflect.DeepEqual(addrs, []string{"192.0.2.1"}) {
+		t.Errorf("unexpected addresses %v, err: %v", addrs, err)
+	}
+
+	// the last failure is returned when no name resolves.
+	r.conf.search = []string{"timeout.test", "servfail.test"}
+	_, err = r.lookup(context.Background(), "app", []string{"A"})
+	var perr *probeError
+	if !errors.As(err, &perr) || perr.class != ProbeErrorDNS {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// DriftKind is the kind of a change of the filesystem of a container which diverges from its image.
+type DriftKind string
+
+const (
+	// DriftNewExecutable is an added executable file, or a file of the image made executable.
+	// A file is executable if it has an execute bit, or starts with an ELF header or a shebang.
+	DriftNewExecutable DriftKind = "new-executable"
+
+	// DriftModifiedBinary is an executable file of the image whose content was changed.
+	DriftModifiedBinary DriftKind = "modified-binary"
+
+	// DriftSensitivePath is a change of a path matched by DriftOptions.SensitivePaths.
+	DriftSensitivePath DriftKind = "sensitive-path"
+
+	// DriftNewSetuid is a file which got the setuid or setgid bit.
+	DriftNewSetuid DriftKind = "new-setuid"
+)
+
+// DefaultDriftSensitivePaths are the sensitive paths used when DriftOptions.SensitivePaths is empty.
+var DefaultDriftSensitivePaths = []string{
+	"/etc/passwd",
+	"/etc/shadow",
+	"/etc/group",
+	"/etc/gshadow",
+	"/etc/sudoers",
+	"/etc/sudoers.d/**",
+	"/etc/ld.so.preload",
+	"/etc/ld.so.conf.d/**",
+	"/etc/cron*/**",
+	"/etc/crontab",
+	"/etc/ssh/**",
+	"/root/.ssh/**",
+	"/bin/**",
+	"/sbin/**",
+	"/usr/bin/**",
+	"/usr/sbin/**",
+	"/usr/local/bin/**",
+	"/usr/local/sbin/**",
+}
+
+// DriftRule allows the drift of the paths matching a glob.
+type DriftRule struct {
+	// Path is a glob of path.Match matched against the path in the container, eg: /var/log/*.log,
+	// a trailing "/**" matches the dir and all the paths under it, eg: /app/cache/**
+	Path string `json:"path"`
+
+	// Kinds are the kinds of drift allowed, all of them if empty.
+	Kinds []DriftKind `json:"kinds,omitempty"`
+}
+
+type DriftOptions struct {
+	// SensitivePaths are the globs, with the syntax of DriftRule.Path, of the paths of which any change is a drift,
+	// by default DefaultDriftSensitivePaths.
+	SensitivePaths []string
+
+	// Allowlist are the rules of the expected drifts, which are reported apart.
+	Allowlist []DriftRule
+}
+
+// DriftFinding is a change of a path classified as a drift of a kind. A path may have several findings.
+type DriftFinding struct {
+	Path   string    `json:"path"`
+	Kind   DriftKind `json:"kind"`
+	Change Change    `json:"change"`
+
+	// LowerDigest is the digest of the content of the file of the image for a modified binary,
+	// whose Change has the digest of the new content if it is a regular file.
+	LowerDigest string `json:"lowerDigest,omitempty"`
+
+	// Rule is the path of the allowlist rule which allows an allowed finding.
+	Rule string `json:"rule,omitempty"`
+}
+
+// DriftReport is the result of the analysis of the drift of a container.
+type DriftReport struct {
+	// Findings are the drifts not allowed by the allowlist, sorted by path.
+	Findings []DriftFinding `json:"findings"`
+
+	// Allowed are the drifts allowed by a rule of the allowlist, sorted by path.
+	Allowed []DriftFinding `json:"allowed"`
+
+	// Changes is the number of changes analyzed.
+	Changes int `json:"changes"`
+}
+
+// analyzeDrift classifies the changes of the upper dir relative to the lower dirs of an overlay filesystem.
+//
+// Only the content of the regular files is compared: a file of the image whose metadata alone changed
+// is not a modified binary, unlike an executable of the image replaced by a file of another type.
+// The parent dirs reported as modified because an entry changed are ignored.
+func analyzeDrift(ctx context.Context, lowerDir, upperDir string, opts DriftOptions) (*DriftReport, error) {
+	sensitivePaths := opts.SensitivePaths
+	if len(sensitivePaths) == 0 {
+		sensitivePaths = DefaultDriftSensitivePaths
+	}
+
+	changes, err := overlayChanges(ctx, lowerDir, upperDir, ChangesOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	lower := NewOverlayView(lowerDir, "")
+	merged := NewOverlayView(lowerDir, upperDir)
+
+	report := &DriftReport{Findings: []DriftFinding{}, Allowed: []DriftFinding{}, Changes: len(changes)}
+
+	for _, change := range changes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var findings []DriftFinding
+		add := func(kind DriftKind) *DriftFinding {
+			findings = append(findings, DriftFinding{Path: change.Path, Kind: kind, Change: change})
+			return &findings[len(findings)-1]
+		}
+
+		name := strings.TrimPrefix(change.Path, "/")
+
+		var lowerInfo fs.FileInfo
+		lowerExecutable := false
+		if change.Kind == ChangeModified {
+			if lowerInfo, err = lower.Lstat(name); err != nil {
+				return nil, err
+			}
+			if !lowerInfo.Mode().IsRegular() {
+				lowerInfo = nil
+			}
+		}
+		if lowerInfo != nil {
+			if lowerExecutable, err = isExecutable(lower, name, lowerInfo.Mode()); err != nil {
+				return nil, err
+			}
+		}
+
+		var lowerDigest string
+		if lowerExecutable {
+			if lowerDigest, err = fileDigest(lower, name); err != nil {
+				return nil, err
+			}
+		}
+
+		if change.Kind != ChangeDeleted && change.Mode.IsRegular() {
+			// only the executables of the image are hashed, the other files are not compared.
+			if lowerExecutable {
+				if change.Digest, err = fileDigest(merged, name); err != nil {
+					return nil, err
+				}
+			}
+
+			executable, err := isExecutable(merged, name, change.Mode)
+			if err != nil {
+				return nil, err
+			}
+
+			if executable && !lowerExecutable {
+				add(DriftNewExecutable)
+			}
+
+			if lowerExecutable && lowerDigest != change.Digest {
+				add(DriftModifiedBinary).LowerDigest = lowerDigest
+			}
+
+			setid := change.Mode & (fs.ModeSetuid | fs.ModeSetgid)
+			if setid != 0 && (lowerInfo == nil || lowerInfo.Mode()&setid != setid) {
+				add(DriftNewSetuid)
+			}
+		} else if lowerExecutable {
+			// an executable of the image replaced by a symbolic link or a file of another type.
+			add(DriftModifiedBinary).LowerDigest = lowerDigest
+		}
+
+		if !(change.Mode.IsDir() && change.Kind == ChangeModified) && matchDriftGlobs(sensitivePaths, change.Path) {
+			add(DriftSensitivePath)
+		}
+
+		for _, finding := range findings {
+			if rule := allowedDrift(opts.Allowlist, finding); rule != "" {
+				finding.Rule = rule
+				report.Allowed = append(report.Allowed, finding)
+				continue
+			}
+			report.Findings = append(report.Findings, finding)
+		}
+	}
+
+	return report, nil
+}
+
+// isExecutable tells if the regular file name of the view has an execute bit, or starts with an ELF header
+// or a shebang, which can be run by a loader or an interpreter without the execute bit.
+func isExecutable(view *OverlayView, name string, mode fs.FileMode) (bool, error) {
+	if mode&0111 != 0 {
+		return true, nil
+	}
+
+	f, err := view.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, fmt.Errorf("read (%s) failed, err: %s", name, err)
+	}
+	magic = magic[:n]
+
+	return bytes.HasPrefix(magic, []byte("\x7fELF")) || bytes.HasPrefix(magic, []byte("#!")), nil
+}
+
+// allowedDrift returns the path of the first rule of the allowlist which allows the finding.
+func allowedDrift(allowlist []DriftRule, finding DriftFinding) string {
+	for _, rule := range allowlist {
+		if !matchDriftGlob(rule.Path, finding.Path) {
+			continue
+		}
+		if len(rule.Kinds) == 0 {
+			return rule.Path
+		}
+		for _, kind := range rule.Kinds {
+			if kind == finding.Kind {
+				return rule.Path
+			}
+		}
+	}
+	return ""
+}
+
+// matchDriftGlobs tells if one of the globs matches p.
+func matchDriftGlobs(globs []string, p string) bool {
+	for _, glob := range globs {
+		if matchDriftGlob(glob, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDriftGlob tells if p matches the glob of path.Match, or, for a glob with a trailing "/**",
+// if p or one of its parents matches the glob without it.
+func matchDriftGlob(glob, p string) bool {
+	dir, ok := strings.CutSuffix(glob, "/**")
+	if !ok {
+		matched, _ := path.Match(glob, p)
+		return matched
+	}
+
+	for q := path.Clean(p); q != "/" && q != "."; q = path.Dir(q) {
+		if matched, _ := path.Match(dir, q); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package container
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_analyzeDrift(t *testing.T) {
+	lower, upper := t.TempDir(), t.TempDir()
+
+	writeFiles := func(root string, files map[string]string, modes map[string]fs.FileMode) {
+		for name, content := range files {
+			p := filepath.Join(root, name)
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if mode, ok := modes[name]; ok {
+				if err := os.Chmod(p, mode); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	writeFiles(lower, map[string]string{
+		"usr/bin/ls":   "\x7fELF ls",
+		"usr/bin/cat":  "\x7fELF cat",
+		"etc/passwd":   "root:x:0:0::/root:/bin/sh\n",
+		"app/run.sh":   "#!/bin/sh\nexec app\n",
+		"app/data":     "data",
+		"srv/start.sh": "#!/bin/sh\nexec srv\n",
+	}, map[string]fs.FileMode{
+		"usr/bin/ls":  0755,
+		"usr/bin/cat": 0755,
+	})
+	if err := os.MkdirAll(filepath.Join(lower, "var/log"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(upper, map[string]string{
+		"usr/bin/ls":         "\x7fELF trojan",
+		"usr/bin/cat":        "\x7fELF cat",
+		"etc/passwd":         "root:x:0:0::/root:/bin/sh\nevil:x:0:0::/:/bin/sh\n",
+		"app/run.sh":         "#!/bin/sh\nexec app --debug\n",
+		"app/data":           "#!/bin/sh\n",
+		"tmp/miner":          "\x7fELF miner",
+		"tmp/payload":        "\x7fELF payload",
+		"tmp/notes.txt":      "notes",
+		"usr/local/bin/root": "\x7fELF root",
+		"var/log/app.log":    "log",
+	}, map[string]fs.FileMode{
+		"usr/bin/cat":        0700,
+		"tmp/miner":          0755,
+		"usr/local/bin/root": 0755 | fs.ModeSetuid,
+	})
+
+	// an executable of the image replaced by a symbolic link to a payload
+	if err := os.MkdirAll(filepath.Join(upper, "srv"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/tmp/payload", filepath.Join(upper, "srv/start.sh")); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := analyzeDrift(context.Background(), lower, upper, DriftOptions{
+		Allowlist: []DriftRule{
+			{Path: "/app/**", Kinds: []DriftKind{DriftModifiedBinary}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := func(findings []DriftFinding) map[string][]DriftKind {
+		ret := map[string][]DriftKind{}
+		for _, finding := range findings {
+			ret[finding.Path] = append(ret[finding.Path], finding.Kind)
+		}
+		return ret
+	}
+
+	expected := map[string][]DriftKind{
+		"/app/data":           {DriftNewExecutable},
+		"/etc/passwd":         {DriftSensitivePath},
+		"/srv/start.sh":       {DriftModifiedBinary},
+		"/tmp/miner":          {DriftNewExecutable},
+		"/tmp/payload":        {DriftNewExecutable},
+		"/usr/bin/cat":        {DriftSensitivePath},
+		"/usr/bin/ls":         {DriftModifiedBinary, DriftSensitivePath},
+		"/usr/local/bin":      {DriftSensitivePath},
+		"/usr/local/bin/root": {DriftNewExecutable, DriftNewSetuid, DriftSensitivePath},
+	}
+	if got := kinds(report.Findings); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected findings %v, expected %v", got, expected)
+	}
+	if got := kinds(report.Allowed); !reflect.DeepEqual(got, map[string][]DriftKind{"/app/run.sh": {DriftModifiedBinary}}) {
+		t.Errorf("unexpected allowed findings %v", got)
+	}
+	for _, finding := range report.Findings {
+		if finding.Kind == DriftModifiedBinary && (finding.LowerDigest == "" || finding.LowerDigest == finding.Change.Digest) {
+			t.Errorf("unexpected digests of %s: %s, %s", finding.Path, finding.LowerDigest, finding.Change.Digest)
+		}
+		// only the executables of the image are hashed.
+		if finding.Path == "/usr/local/bin/root" && finding.Change.Digest != "" {
+			t.Errorf("unexpected digest of %s: %s", finding.Path, finding.Change.Digest)
+		}
+	}
+	if report.Allowed[0].Rule != "/app/**" {
+		t.Errorf("unexpected rule %s", report.Allowed[0].Rule)
+	}
+}
+
+func Test_matchDriftGlob(t *testing.T) {
+	for _, tt := range []struct {
+		glob, path string
+		matched    bool
+	}{
+		{"/etc/passwd", "/etc/passwd", true},
+		{"/etc/passwd", "/etc/passwd-", false},
+		{"/var/log/*.log", "/var/log/app.log", true},
+		{"/var/log/*.log", "/var/log/app/x.log", false},
+		{"/usr/bin/**", "/usr/bin", true},
+		{"/usr/bin/**", "/usr/bin/x/y", true},
+		{"/usr/bin/**", "/usr/binx", false},
+		{"/etc/cron*/**", "/etc/cron.d/job", true},
+		{"/etc/cron*/**", "/etc/crontab", true},
+		{"/etc/cron*/**", "/etc/hosts", false},
+	} {
+		if matched := matchDriftGlob(tt.glob, tt.path); matched != tt.matched {
+			t.Errorf("%s %s: matched %t, expected %t", tt.glob, tt.path, matched, tt.matched)
+		}
+	}
+}
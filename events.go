@@ -0,0 +1,47 @@
+package container
+
+import (
+	"context"
+	"fmt"
+)
+
+type ContainerEventType string
+
+const (
+	// ContainerEventStart is sent when the (init process of the) container is started.
+	ContainerEventStart ContainerEventType = "start"
+
+	// ContainerEventStop is sent when the (init process of the) container exits.
+	ContainerEventStop ContainerEventType = "stop"
+
+	// ContainerEventDelete is sent when the container is removed.
+	ContainerEventDelete ContainerEventType = "delete"
+)
+
+// ContainerEvent is a lifecycle event of a container.
+type ContainerEvent struct {
+	Type ContainerEventType
+
+	// RuntimeID is the id of the container prefixed with its runtime, eg: docker://xxxxxx
+	RuntimeID string
+}
+
+// WatchEvents subscribes to the container lifecycle events of the runtime on the node.
+// The event channel is closed when the subscription ends, after an error (if any) is sent on the
+// error channel. The subscription ends when ctx is done.
+func WatchEvents(ctx context.Context, runtime Runtime) (<-chan ContainerEvent, <-chan error) {
+	switch runtime {
+	case RuntimeDocker:
+		return WatchDockerEvents(ctx)
+
+	case RuntimeContainerd:
+		return WatchContainerdEvents(ctx)
+
+	default:
+		events := make(chan ContainerEvent)
+		errs := make(chan error, 1)
+		errs <- fmt.Errorf("unknown container runtime: (%s)", runtime)
+		close(events)
+		return events, errs
+	}
+}
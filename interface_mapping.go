@@ -0,0 +1,56 @@
+package container
+
+import "fmt"
+
+// InterfaceNodeMapping describes how an interface inside the network namespace of a container
+// is connected to an interface on the host.
+type InterfaceNodeMapping struct {
+	// Interface is the name of the interface inside the container, eg: eth0
+	Interface string `json:"interface"`
+	Index     int    `json:"index"`
+
+	// Kind is the link kind of the interface inside the container, eg: veth, macvlan, ipvlan, sriov-vf
+	Kind string `json:"kind"`
+
+	// HostInterface is the name of the host-side interface: the peer of a veth, the parent of a
+	// macvlan/ipvlan, or the physical function of an SR-IOV virtual function, eg: cali97e0633f831
+	// It is empty if the host-side interface is not in any of the searched network namespaces.
+	HostInterface string `json:"hostInterface,omitempty"`
+	HostIndex     int    `json:"hostIndex,omitempty"`
+	HostKind      string `json:"hostKind,omitempty"`
+
+	// HostNetNSPath and HostNetNSID identify the network namespace of the host-side interface.
+	HostNetNSPath string `json:"hostNetnsPath,omitempty"`
+	HostNetNSID   uint64 `json:"hostNetnsID,omitempty"`
+
+	// VFIndex is the index of the virtual function on the physical function, for SR-IOV only.
+	VFIndex int `json:"vfIndex,omitempty"`
+}
+
+// interfaceNodeMap converts the mappings to the format returned by GetInterfacesNodeMapping.
+func interfaceNodeMap(mappings []InterfaceNodeMapping) map[string]string {
+	var ret = map[string]string{}
+	for _, m := range mappings {
+		if m.HostInterface != "" {
+			ret[m.Interface] = m.HostInterface
+		}
+	}
+	return ret
+}
+
+// hostVethPeer returns the mapping of iface if its host-side interface is a veth peer.
+// The parent of a macvlan/ipvlan or an SR-IOV physical function is shared with other interfaces,
+// so it does not carry the traffic of iface alone.
+func hostVethPeer(mappings []InterfaceNodeMapping, iface string) (*InterfaceNodeMapping, error) {
+	for i, m := range mappings {
+		if m.Interface != iface {
+			continue
+		}
+		if m.Kind != "veth" || m.HostInterface == "" {
+			return nil, fmt.Errorf("interface (%s) has no host-side veth peer", iface)
+		}
+		return &mappings[i], nil
+	}
+
+	return nil, fmt.Errorf("interface (%s) not found", iface)
+}
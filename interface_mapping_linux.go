@@ -0,0 +1,169 @@
+package container
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+type hostNetNS struct {
+	path string
+	id   uint64
+
+	// nsid is the id assigned to this network namespace inside the container network namespace,
+	// as reported in the link-netnsid attribute of the container interfaces.
+	nsid int
+
+	handle *netlink.Handle
+	links  []netlink.Link
+}
+
+// resolveInterfaceNodeMappings resolves, for each interface inside the network namespace at netnsPath,
+// the interface it is connected to in one of the network namespaces at hostNetNSPaths.
+//
+// The peer of a veth and the parent of a macvlan/ipvlan are resolved by the parent ifindex
+// together with the link-netnsid of the interface, which tells in which network namespace
+// the ifindex is valid. SR-IOV virtual functions are matched by mac address against the
+// virtual functions of the physical functions in the host network namespaces.
+func resolveInterfaceNodeMappings(netnsPath string, hostNetNSPaths []string) ([]InterfaceNodeMapping, error) {
+	containerNS, err := netns.GetFromPath(netnsPath)
+	if err != nil {
+		return nil, fmt.Errorf("open netns (%s) failed, err: %w", netnsPath, err)
+	}
+	defer containerNS.Close()
+
+	h, err := netlink.NewHandleAt(containerNS)
+	if err != nil {
+		return nil, fmt.Errorf("create netlink handle in netns (%s) failed, err: %s", netnsPath, err)
+	}
+	defer h.Delete()
+
+	// list the links first, dumping them allocates the ids of the peer network namespaces.
+	links, err := h.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("list links failed, err: %s", err)
+	}
+
+	var hosts []*hostNetNS
+	for _, hostNetNSPath := range hostNetNSPaths {
+		ns, err := netns.GetFromPath(hostNetNSPath)
+		if err != nil {
+			return nil, fmt.Errorf("open netns (%s) failed, err: %s", hostNetNSPath, err)
+		}
+		defer ns.Close()
+
+		nsid, err := h.GetNetNsIdByFd(int(ns))
+		if err != nil {
+			return nil, fmt.Errorf("get id of netns (%s) failed, err: %s", hostNetNSPath, err)
+		}
+
+		id, err := namespaceInode(hostNetNSPath)
+		if err != nil {
+			return nil, err
+		}
+
+		hostHandle, err := netlink.NewHandleAt(ns)
+		if err != nil {
+			return nil, fmt.Errorf("create netlink handle in netns (%s) failed, err: %s", hostNetNSPath, err)
+		}
+		defer hostHandle.Delete()
+
+		hosts = append(hosts, &hostNetNS{
+			path:   hostNetNSPath,
+			id:     id,
+			nsid:   nsid,
+			handle: hostHandle,
+		})
+	}
+
+	var mappings = []InterfaceNodeMapping{}
+	for _, link := range links {
+		attrs := link.Attrs()
+		if attrs.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		m := InterfaceNodeMapping{
+			Interface: attrs.Name,
+			Index:     attrs.Index,
+			Kind:      link.Type(),
+		}
+
+		switch {
+		case attrs.ParentIndex != 0:
+			if attrs.NetNsID < 0 {
+				// the peer or parent is inside the container network namespace too.
+				continue
+			}
+
+			var host *hostNetNS
+			for _, candidate := range hosts {
+				if candidate.nsid >= 0 && candidate.nsid == attrs.NetNsID {
+					host = candidate
+					break
+				}
+			}
+			if host != nil {
+				hostLink, err := host.handle.LinkByIndex(attrs.ParentIndex)
+				if err != nil {
+					return nil, fmt.Errorf("get link (%d) in netns (%s) failed, err: %s", attrs.ParentIndex, host.path, err)
+				}
+				m.HostInterface = hostLink.Attrs().Name
+				m.HostIndex = hostLink.Attrs().Index
+				m.HostKind = hostLink.Type()
+				m.HostNetNSPath = host.path
+				m.HostNetNSID = host.id
+			}
+
+		case link.Type() == "device" && len(attrs.HardwareAddr) != 0:
+			host, pf, vf, err := findVirtualFunction(hosts, attrs.HardwareAddr)
+			if err != nil {
+				return nil, err
+			}
+			if pf == nil {
+				continue
+			}
+			m.Kind = "sriov-vf"
+			m.HostInterface = pf.Attrs().Name
+			m.HostIndex = pf.Attrs().Index
+			m.HostKind = pf.Type()
+			m.HostNetNSPath = host.path
+			m.HostNetNSID = host.id
+			m.VFIndex = vf.ID
+
+		default:
+			continue
+		}
+
+		mappings = append(mappings, m)
+	}
+
+	return mappings, nil
+}
+
+// findVirtualFunction finds the physical function in the host network namespaces which has
+// a virtual function with the given mac address.
+func findVirtualFunction(hosts []*hostNetNS, mac net.HardwareAddr) (*hostNetNS, netlink.Link, *netlink.VfInfo, error) {
+	for _, host := range hosts {
+		if host.links == nil {
+			links, err := host.handle.LinkList()
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("list links in netns (%s) failed, err: %s", host.path, err)
+			}
+			host.links = links
+		}
+
+		for _, link := range host.links {
+			for i, vf := range link.Attrs().Vfs {
+				if bytes.Equal(vf.Mac, mac) {
+					return host, link, &link.Attrs().Vfs[i], nil
+				}
+			}
+		}
+	}
+
+	return nil, nil, nil, nil
+}
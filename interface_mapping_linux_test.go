@@ -0,0 +1,101 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+func Test_resolveInterfaceNodeMappings(t *testing.T) {
+	requireRoot(t)
+
+	hostPath := newTestNetNS(t, "gcu-test-map-host")
+	containerPath := newTestNetNS(t, "gcu-test-map-ctr")
+
+	hostNS, err := netns.GetFromPath(hostPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hostNS.Close()
+	containerNS, err := netns.GetFromPath(containerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer containerNS.Close()
+
+	hostHandle, err := netlink.NewHandleAt(hostNS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hostHandle.Delete()
+	containerHandle, err := netlink.NewHandleAt(containerNS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer containerHandle.Delete()
+
+	// a veth pair across the namespaces, like the one created by CNI plugins.
+	if err := hostHandle.LinkAdd(&netlink.Veth{
+		LinkAttrs:     netlink.LinkAttrs{Name: "calitest0"},
+		PeerName:      "eth0",
+		PeerNamespace: netlink.NsFd(int(containerNS)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a macvlan inside the container whose parent is on the host.
+	if err := hostHandle.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "uplink0"}, PeerName: "uplink1"}); err != nil {
+		t.Fatal(err)
+	}
+	uplink, err := hostHandle.LinkByName("uplink0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hostHandle.LinkAdd(&netlink.Macvlan{
+		LinkAttrs: netlink.LinkAttrs{Name: "mv0", ParentIndex: uplink.Attrs().Index},
+		Mode:      netlink.MACVLAN_MODE_BRIDGE,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	mv, err := hostHandle.LinkByName("mv0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hostHandle.LinkSetNsFd(mv, int(containerNS)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a veth pair inside the container, which is not connected to the host.
+	if err := containerHandle.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "inner0"}, PeerName: "inner1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	mappings, err := resolveInterfaceNodeMappings(containerPath, []string{hostPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]InterfaceNodeMapping{}
+	for _, m := range mappings {
+		got[m.Interface] = m
+	}
+
+	hostID := nsInode(t, hostPath)
+
+	if m := got["eth0"]; m.Kind != "veth" || m.HostInterface != "calitest0" || m.HostKind != "veth" || m.HostNetNSID != hostID {
+		t.Errorf("unexpected mapping for eth0: %+v", m)
+	}
+	if m := got["mv0"]; m.Kind != "macvlan" || m.HostInterface != "uplink0" || m.HostNetNSPath != hostPath {
+		t.Errorf("unexpected mapping for mv0: %+v", m)
+	}
+	for _, name := range []string{"inner0", "inner1", "lo"} {
+		if m, ok := got[name]; ok {
+			t.Errorf("unexpected mapping for %s: %+v", name, m)
+		}
+	}
+
+	if m := interfaceNodeMap(mappings); m["eth0"] != "calitest0" || m["mv0"] != "uplink0" || len(m) != 2 {
+		t.Errorf("unexpected node map: %v", m)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package container
+
+func resolveInterfaceNodeMappings(netnsPath string, hostNetNSPaths []string) ([]InterfaceNodeMapping, error) {
+	return nil, ErrNotImplemented
+}
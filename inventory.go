@@ -0,0 +1,140 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultInventoryConcurrency is the default number of network namespaces entered in parallel.
+const DefaultInventoryConcurrency = 8
+
+// ContainerInterface identifies an interface inside a container.
+type ContainerInterface struct {
+	// RuntimeID is the id of the container prefixed with its runtime, eg: containerd://xxxx
+	RuntimeID string `json:"runtimeID"`
+
+	// Interface is the name of the interface inside the container, eg: eth0
+	Interface string `json:"interface"`
+
+	NetNSID uint64 `json:"netnsID"`
+}
+
+// NodeInterfaceInventory maps the interfaces on the host to the interfaces inside the containers
+// on the node, in both directions.
+type NodeInterfaceInventory struct {
+	// HostToContainers maps the name of a host interface to the container interfaces connected to it.
+	// All containers sharing the network namespace are listed, eg: all the containers of a pod.
+	// eg: {"cali97e0633f831": [{"runtimeID": "containerd://xxxx", "interface": "eth0"}, ...]}
+	HostToContainers map[string][]ContainerInterface `json:"hostToContainers"`
+
+	// ContainerToHost maps the runtime id of a container to the mapping of its interfaces
+	// to host interfaces, as returned by GetInterfacesNodeMapping.
+	// eg: {"containerd://xxxx": {"eth0": "cali97e0633f831"}}
+	ContainerToHost map[string]map[string]string `json:"containerToHost"`
+
+	// Mappings are the detailed mappings of each network namespace, keyed by its NetNSID.
+	Mappings map[uint64][]InterfaceNodeMapping `json:"mappings"`
+}
+
+// BuildNodeInterfaceInventory enumerates the containers of the runtime on the node and their network namespaces,
+// and resolves the host-side interfaces of each network namespace once, no matter how many containers share it.
+// At most concurrency network namespaces are entered in parallel, DefaultInventoryConcurrency if it is not positive.
+// Containers running in the host network namespace, and network namespaces which are gone while the inventory
+// is built, are skipped.
+func BuildNodeInterfaceInventory(ctx context.Context, runtime Runtime, hostRoot string, concurrency int) (*NodeInterfaceInventory, error) {
+	groups, err := NodeNetNSGroups(runtime, hostRoot)
+	if err != nil {
+		return nil, fmt.Errorf("group containers by netns failed, err: %s", err)
+	}
+
+	return buildInterfaceInventory(ctx, groups, hostRoot, concurrency)
+}
+
+func buildInterfaceInventory(ctx context.Context, groups []NetNSGroup, hostRoot string, concurrency int) (*NodeInterfaceInventory, error) {
+	if concurrency <= 0 {
+		concurrency = DefaultInventoryConcurrency
+	}
+
+	hostNetNS := hostNetNSPath(hostRoot)
+	hostNetNSID, err := namespaceInode(hostNetNS)
+	if err != nil {
+		return nil, fmt.Errorf("get host netns id failed, err: %s", err)
+	}
+
+	inventory := &NodeInterfaceInventory{
+		HostToContainers: map[string][]ContainerInterface{},
+		ContainerToHost:  map[string]map[string]string{},
+		Mappings:         map[uint64][]InterfaceNodeMapping{},
+	}
+
+	var mu sync.Mutex
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+
+	for _, group := range groups {
+		if group.NetNSID == hostNetNSID {
+			continue
+		}
+
+		group := group
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			mappings, err := resolveInterfaceNodeMappings(group.NetNSPath, []string{hostNetNS})
+			if err != nil {
+				// the containers stopped since they were grouped.
+				if isContainerGone(err) {
+					return nil
+				}
+				return fmt.Errorf("resolve interfaces of netns (%s) failed, err: %s", group.NetNSPath, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			inventory.add(group, mappings)
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	for _, refs := range inventory.HostToContainers {
+		sort.Slice(refs, func(i, j int) bool {
+			if refs[i].RuntimeID != refs[j].RuntimeID {
+				return refs[i].RuntimeID < refs[j].RuntimeID
+			}
+			return refs[i].Interface < refs[j].Interface
+		})
+	}
+
+	return inventory, nil
+}
+
+func (inv *NodeInterfaceInventory) add(group NetNSGroup, mappings []InterfaceNodeMapping) {
+	inv.Mappings[group.NetNSID] = mappings
+	nodeMap := interfaceNodeMap(mappings)
+
+	for _, c := range group.Containers {
+		runtimeID := c.RuntimeID()
+		inv.ContainerToHost[runtimeID] = nodeMap
+
+		for _, m := range mappings {
+			if m.HostInterface == "" {
+				continue
+			}
+			inv.HostToContainers[m.HostInterface] = append(inv.HostToContainers[m.HostInterface], ContainerInterface{
+				RuntimeID: runtimeID,
+				Interface: m.Interface,
+				NetNSID:   group.NetNSID,
+			})
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package container
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// newTestHostRoot creates a host root whose init process is in the network namespace at hostNetNSPath.
+func newTestHostRoot(t *testing.T, hostNetNSPath string) string {
+	t.Helper()
+
+	hostRoot := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(hostRoot, "proc/1/ns"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(hostNetNSPath, filepath.Join(hostRoot, "proc/1/ns/net")); err != nil {
+		t.Fatal(err)
+	}
+	return hostRoot
+}
+
+func Test_buildInterfaceInventory(t *testing.T) {
+	requireRoot(t)
+
+	hostPath := newTestNetNS(t, "gcu-test-inv-host")
+	podPath := newTestNetNS(t, "gcu-test-inv-pod")
+
+	hostRoot := newTestHostRoot(t, hostPath)
+
+	hostNS, err := netns.GetFromPath(hostPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hostNS.Close()
+	podNS, err := netns.GetFromPath(podPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer podNS.Close()
+
+	hostHandle, err := netlink.NewHandleAt(hostNS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hostHandle.Delete()
+
+	if err := hostHandle.LinkAdd(&netlink.Veth{
+		LinkAttrs:     netlink.LinkAttrs{Name: "calitest1"},
+		PeerName:      "eth0",
+		PeerNamespace: netlink.NsFd(int(podNS)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	podID, hostID := nsInode(t, podPath), nsInode(t, hostPath)
+	groups := []NetNSGroup{
+		{NetNSID: podID, NetNSPath: podPath, Containers: []Container{
+			&fakeNetNSContainer{id: "sandbox"},
+			&fakeNetNSContainer{id: "app"},
+		}},
+		// the containers in host network mode are skipped.
+		{NetNSID: hostID, NetNSPath: hostPath, Containers: []Container{&fakeNetNSContainer{id: "hostnet"}}},
+		// so are the ones whose network namespace is gone since they were grouped.
+		{NetNSID: 1, NetNSPath: filepath.Join(t.TempDir(), "gone"), Containers: []Container{&fakeNetNSContainer{id: "stopped"}}},
+	}
+
+	inventory, err := buildInterfaceInventory(context.Background(), groups, hostRoot, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ContainerInterface{
+		{RuntimeID: "containerd://app", Interface: "eth0", NetNSID: podID},
+		{RuntimeID: "containerd://sandbox", Interface: "eth0", NetNSID: podID},
+	}
+	if got := inventory.HostToContainers; len(got) != 1 || !reflect.DeepEqual(got["calitest1"], expected) {
+		t.Errorf("unexpected host to containers %v", got)
+	}
+	if got := inventory.ContainerToHost; len(got) != 2 || got["containerd://app"]["eth0"] != "calitest1" {
+		t.Errorf("unexpected container to host %v", got)
+	}
+	if _, ok := inventory.Mappings[hostID]; ok || len(inventory.Mappings) != 1 {
+		t.Errorf("unexpected mappings %v", inventory.Mappings)
+	}
+
+	// a cancelled context stops the inventory.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := buildInterfaceInventory(ctx, groups, hostRoot, 1); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+}
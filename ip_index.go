@@ -0,0 +1,387 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// IPOwner is a container owning an IP address inside its network namespace.
+type IPOwner struct {
+	// RuntimeID is the id of the container prefixed with its runtime, eg: containerd://xxxx
+	RuntimeID string `json:"runtimeID"`
+
+	// Interface is the name of the interface inside the container which has the address.
+	Interface string `json:"interface"`
+
+	IP        string `json:"ip"`
+	PrefixLen int    `json:"prefixLen"`
+	Secondary bool   `json:"secondary,omitempty"`
+	NetNSID   uint64 `json:"netnsID"`
+}
+
+const (
+	DefaultIPIndexUpdateRetries       = 3
+	DefaultIPIndexUpdateRetryInterval = 200 * time.Millisecond
+)
+
+// IPIndex maps the IP addresses configured inside the network namespaces of the containers on the node
+// to the containers. The addresses are read from the interfaces inside each network namespace, so it
+// also works for CNI-managed pods, for which the runtime does not know the addresses.
+//
+// Loopback, link-local addresses and containers in the host network namespace are not indexed.
+// All the containers sharing a network namespace own its addresses, eg: all the containers of a pod.
+type IPIndex struct {
+	// OnUpdateError, if set, is called by Watch with the event of a container which failed to refresh
+	// after UpdateRetries retries, and the last error.
+	OnUpdateError func(event ContainerEvent, err error)
+
+	// UpdateRetries is the number of times Watch retries to refresh a container, with an exponential backoff
+	// starting at UpdateRetryInterval.
+	UpdateRetries       int
+	UpdateRetryInterval time.Duration
+
+	runtime  Runtime
+	hostRoot string
+
+	// listGroups, watchEvents and newContainer access the runtime, replaced by the tests.
+	listGroups   func() ([]NetNSGroup, error)
+	watchEvents  func(ctx context.Context) (<-chan ContainerEvent, <-chan error)
+	newContainer func(runtimeID string) (Container, error)
+
+	mu sync.RWMutex
+
+	// owners holds the addresses owned by each container, keyed by runtime id
+	owners map[string][]IPOwner
+	byIP   map[netip.Addr][]IPOwner
+}
+
+func NewIPIndex(runtime Runtime, hostRoot string) *IPIndex {
+	return &IPIndex{
+		UpdateRetries:       DefaultIPIndexUpdateRetries,
+		UpdateRetryInterval: DefaultIPIndexUpdateRetryInterval,
+		runtime:             runtime,
+		hostRoot:            hostRoot,
+		listGroups: func() ([]NetNSGroup, error) {
+			return NodeNetNSGroups(runtime, hostRoot)
+		},
+		watchEvents: func(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+			return WatchEvents(ctx, runtime)
+		},
+		newContainer: NewContainer,
+		owners:       map[string][]IPOwner{},
+		byIP:         map[netip.Addr][]IPOwner{},
+	}
+}
+
+// ContainerByIP returns the containers owning the given IP address,
+// built with a fresh IPIndex of the runtime on the node.
+func ContainerByIP(ctx context.Context, runtime Runtime, hostRoot string, ip net.IP) ([]IPOwner, error) {
+	idx := NewIPIndex(runtime, hostRoot)
+	if err := idx.Rebuild(ctx); err != nil {
+		return nil, err
+	}
+
+	return idx.ContainerByIP(ip), nil
+}
+
+// ContainerByIP returns the containers owning the given IP address, or nil if it is unknown.
+func (idx *IPIndex) ContainerByIP(ip net.IP) []IPOwner {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return nil
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	owners := idx.byIP[addr.Unmap()]
+	if len(owners) == 0 {
+		return nil
+	}
+
+	return append([]IPOwner{}, owners...)
+}
+
+// Rebuild replaces the content of the index with the addresses of all the running containers on the node.
+// The network namespaces are entered in parallel, once for each network namespace. The containers which
+// are gone while the index is rebuilt are skipped.
+func (idx *IPIndex) Rebuild(ctx context.Context) error {
+	groups, err := idx.listGroups()
+	if err != nil {
+		return fmt.Errorf("group containers by netns failed, err: %s", err)
+	}
+
+	hostNetNSID, err := namespaceInode(hostNetNSPath(idx.hostRoot))
+	if err != nil {
+		return fmt.Errorf("get host netns id failed, err: %s", err)
+	}
+
+	var mu sync.Mutex
+	var owners = map[string][]IPOwner{}
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(DefaultInventoryConcurrency)
+
+	for _, group := range groups {
+		if group.NetNSID == hostNetNSID {
+			continue
+		}
+
+		group := group
+		g.Go(func() error {
+			state, err := networkStateInNetNS(gctx, group.NetNSPath)
+			if err != nil {
+				// the containers stopped since they were grouped.
+				if isContainerGone(err) {
+					return nil
+				}
+				return fmt.Errorf("get network state of netns (%s) failed, err: %s", group.NetNSPath, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, c := range group.Containers {
+				owners[c.RuntimeID()] = ipOwners(c.RuntimeID(), group.NetNSID, state)
+			}
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.owners = map[string][]IPOwner{}
+	idx.byIP = map[netip.Addr][]IPOwner{}
+	for runtimeID, items := range owners {
+		idx.setLocked(runtimeID, items)
+	}
+
+	return nil
+}
+
+// Update re-reads the addresses of the container, eg: after it is started or its network is changed.
+// The container is removed from the index if it is not running.
+func (idx *IPIndex) Update(ctx context.Context, c Container) error {
+	runtimeID := c.RuntimeID()
+
+	netnsPath, err := c.NetNSPath()
+	if err != nil {
+		if errors.Is(err, ErrNotRunning) {
+			idx.Remove(runtimeID)
+			return nil
+		}
+		return fmt.Errorf("get netns path of container (%s) failed, err: %w", runtimeID, err)
+	}
+
+	netnsID, err := namespaceInode(netnsPath)
+	if err != nil {
+		return fmt.Errorf("get netns id of container (%s) failed, err: %s", runtimeID, err)
+	}
+
+	hostNetNSID, err := namespaceInode(hostNetNSPath(idx.hostRoot))
+	if err != nil {
+		return fmt.Errorf("get host netns id failed, err: %s", err)
+	}
+
+	if netnsID == hostNetNSID {
+		idx.Remove(runtimeID)
+		return nil
+	}
+
+	state, err := networkStateInNetNS(ctx, netnsPath)
+	if err != nil {
+		return fmt.Errorf("get network state of container (%s) failed, err: %s", runtimeID, err)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(runtimeID)
+	idx.setLocked(runtimeID, ipOwners(runtimeID, netnsID, state))
+
+	return nil
+}
+
+// Remove removes the addresses of the container from the index.
+func (idx *IPIndex) Remove(runtimeID string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(runtimeID)
+}
+
+// Run rebuilds the index and keeps it up to date with the lifecycle events of the containers until ctx is done.
+// The events are subscribed to before the index is rebuilt, the ones received during the rebuild are applied
+// after it, so that no container started or stopped meanwhile is missed. See Watch for how the events are applied.
+func (idx *IPIndex) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events, errs := idx.watchEvents(ctx)
+
+	rebuilt := make(chan error, 1)
+	go func() {
+		rebuilt <- idx.Rebuild(ctx)
+	}()
+
+	// the subscription is drained during the rebuild, the runtime may block on a slow subscriber.
+	var pending []ContainerEvent
+	received := events
+	for done := false; !done; {
+		select {
+		case event, ok := <-received:
+			if !ok {
+				// the subscription ended, its error is returned by watch after the rebuild.
+				received = nil
+				continue
+			}
+			pending = append(pending, event)
+
+		case err := <-rebuilt:
+			if err != nil {
+				return err
+			}
+			done = true
+		}
+	}
+
+	for _, event := range pending {
+		idx.handleEvent(ctx, event)
+	}
+
+	return idx.watch(ctx, events, errs)
+}
+
+// Watch keeps the index up to date with the lifecycle events of the containers until ctx is done.
+// Only the container of each event is refreshed. A container failing to refresh is retried, and then
+// reported to OnUpdateError, its addresses are left as they were.
+// The index should be built by Rebuild before, the events sent before Watch subscribes to them are missed,
+// use Run to do both without missing any.
+func (idx *IPIndex) Watch(ctx context.Context) error {
+	events, errs := idx.watchEvents(ctx)
+
+	return idx.watch(ctx, events, errs)
+}
+
+func (idx *IPIndex) watch(ctx context.Context, events <-chan ContainerEvent, errs <-chan error) error {
+	for event := range events {
+		idx.handleEvent(ctx, event)
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return ctx.Err()
+	}
+}
+
+// handleEvent refreshes, or removes, the container of the event.
+func (idx *IPIndex) handleEvent(ctx context.Context, event ContainerEvent) {
+	switch event.Type {
+	case ContainerEventStart:
+		if err := idx.updateWithRetries(ctx, event.RuntimeID); err != nil && ctx.Err() == nil && idx.OnUpdateError != nil {
+			idx.OnUpdateError(event, err)
+		}
+
+	case ContainerEventStop, ContainerEventDelete:
+		idx.Remove(event.RuntimeID)
+	}
+}
+
+// updateWithRetries updates the container runtimeID, with up to UpdateRetries retries on failure.
+func (idx *IPIndex) updateWithRetries(ctx context.Context, runtimeID string) error {
+	c, err := idx.newContainer(runtimeID)
+	if err != nil {
+		return err
+	}
+	c.WithHostRoot(idx.hostRoot)
+
+	interval := idx.UpdateRetryInterval
+	for i := 0; ; i++ {
+		err = idx.Update(ctx, c)
+		if err == nil || i >= idx.UpdateRetries {
+			return err
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return err
+		}
+		interval *= 2
+	}
+}
+
+func (idx *IPIndex) setLocked(runtimeID string, owners []IPOwner) {
+	if len(owners) == 0 {
+		return
+	}
+
+	idx.owners[runtimeID] = owners
+	for _, owner := range owners {
+		addr := netip.MustParseAddr(owner.IP)
+		idx.byIP[addr] = append(idx.byIP[addr], owner)
+		sort.Slice(idx.byIP[addr], func(i, j int) bool {
+			return idx.byIP[addr][i].RuntimeID < idx.byIP[addr][j].RuntimeID
+		})
+	}
+}
+
+func (idx *IPIndex) removeLocked(runtimeID string) {
+	for _, owner := range idx.owners[runtimeID] {
+		addr := netip.MustParseAddr(owner.IP)
+
+		var left []IPOwner
+		for _, item := range idx.byIP[addr] {
+			if item.RuntimeID != runtimeID {
+				left = append(left, item)
+			}
+		}
+
+		if len(left) == 0 {
+			delete(idx.byIP, addr)
+		} else {
+			idx.byIP[addr] = left
+		}
+	}
+	delete(idx.owners, runtimeID)
+}
+
+// ipOwners returns the addresses in the network state which identify the container.
+func ipOwners(runtimeID string, netnsID uint64, state *NetworkState) []IPOwner {
+	var owners []IPOwner
+
+	for _, intf := range state.Interfaces {
+		for _, address := range intf.Addresses {
+			addr, err := netip.ParseAddr(address.IP)
+			if err != nil {
+				continue
+			}
+			if addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() {
+				continue
+			}
+
+			owners = append(owners, IPOwner{
+				RuntimeID: runtimeID,
+				Interface: intf.Name,
+				IP:        addr.Unmap().String(),
+				PrefixLen: address.PrefixLen,
+				Secondary: address.Secondary,
+				NetNSID:   netnsID,
+			})
+		}
+	}
+
+	return owners
+}
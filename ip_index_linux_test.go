@@ -0,0 +1,179 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// newTestNetNSWithAddr creates a network namespace with an interface eth0 with the address cidr.
+func newTestNetNSWithAddr(t *testing.T, name, cidr string) string {
+	t.Helper()
+
+	netnsPath := newTestNetNS(t, name)
+	ns, err := netns.GetFromPath(netnsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ns.Close()
+
+	handle, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handle.Delete()
+
+	link := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}, PeerName: "peer0"}
+	if err := handle.LinkAdd(link); err != nil {
+		t.Fatal(err)
+	}
+	addr, err := netlink.ParseAddr(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handle.AddrAdd(link, addr); err != nil {
+		t.Fatal(err)
+	}
+	return netnsPath
+}
+
+func Test_IPIndex_Rebuild(t *testing.T) {
+	requireRoot(t)
+
+	podPath := newTestNetNSWithAddr(t, "gcu-test-idx-pod", "10.99.0.5/24")
+
+	idx := NewIPIndex(RuntimeContainerd, newTestHostRoot(t, newTestNetNS(t, "gcu-test-idx-host")))
+	idx.listGroups = func() ([]NetNSGroup, error) {
+		return []NetNSGroup{
+			{NetNSID: nsInode(t, podPath), NetNSPath: podPath, Containers: []Container{
+				&fakeNetNSContainer{id: "sandbox"},
+				&fakeNetNSContainer{id: "app"},
+			}},
+			// a pod whose network namespace is gone since it was grouped is skipped.
+			{NetNSID: 1, NetNSPath: filepath.Join(t.TempDir(), "gone"), Containers: []Container{
+				&fakeNetNSContainer{id: "stopped"},
+			}},
+		}, nil
+	}
+	// stale entries are dropped
+	idx.setLocked("containerd://old", []IPOwner{{RuntimeID: "containerd://old", IP: "10.99.0.9"}})
+
+	if err := idx.Rebuild(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	owners := idx.ContainerByIP(net.ParseIP("10.99.0.5"))
+	if len(owners) != 2 || owners[0].RuntimeID != "containerd://app" || owners[0].Interface != "eth0" || owners[0].PrefixLen != 24 {
+		t.Errorf("unexpected owners %+v", owners)
+	}
+	if owners := idx.ContainerByIP(net.ParseIP("10.99.0.9")); owners != nil {
+		t.Errorf("unexpected owners of a stale address %+v", owners)
+	}
+}
+
+func Test_IPIndex_Watch(t *testing.T) {
+	requireRoot(t)
+
+	podPath := newTestNetNSWithAddr(t, "gcu-test-idx-watch", "10.99.1.5/24")
+	transient := errors.New("transient inspect failure")
+
+	containers := map[string]*fakeNetNSContainer{
+		// fails once, and is indexed after a retry.
+		"containerd://flaky": {id: "flaky", netnsPath: podPath, failure: transient, failures: 1},
+		// always fails, and is reported.
+		"containerd://broken":  {id: "broken", netnsPath: podPath, failure: transient, failures: 100},
+		"containerd://stopped": {id: "stopped", netnsPath: podPath},
+	}
+
+	events := make(chan ContainerEvent, 10)
+	for _, event := range []ContainerEvent{
+		{Type: ContainerEventStart, RuntimeID: "containerd://flaky"},
+		{Type: ContainerEventStart, RuntimeID: "containerd://broken"},
+		{Type: ContainerEventStart, RuntimeID: "containerd://stopped"},
+		{Type: ContainerEventStop, RuntimeID: "containerd://stopped"},
+	} {
+		events <- event
+	}
+	close(events)
+
+	idx := NewIPIndex(RuntimeContainerd, newTestHostRoot(t, newTestNetNS(t, "gcu-test-idx-host")))
+	idx.UpdateRetries = 2
+	idx.UpdateRetryInterval = time.Millisecond
+	idx.watchEvents = func(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+		return events, make(chan error)
+	}
+	idx.newContainer = func(runtimeID string) (Container, error) {
+		return containers[runtimeID], nil
+	}
+	// the broken container keeps its addresses
+	idx.setLocked("containerd://broken", []IPOwner{{RuntimeID: "containerd://broken", IP: "10.99.1.5"}})
+
+	var reported []string
+	idx.OnUpdateError = func(event ContainerEvent, err error) {
+		if !errors.Is(err, transient) {
+			t.Errorf("unexpected error %v", err)
+		}
+		reported = append(reported, event.RuntimeID)
+	}
+
+	if err := idx.Watch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, owner := range idx.ContainerByIP(net.ParseIP("10.99.1.5")) {
+		got = append(got, owner.RuntimeID)
+	}
+	if len(got) != 2 || got[0] != "containerd://broken" || got[1] != "containerd://flaky" {
+		t.Errorf("unexpected owners %v", got)
+	}
+	if len(reported) != 1 || reported[0] != "containerd://broken" {
+		t.Errorf("unexpected reported errors %v", reported)
+	}
+}
+
+func Test_IPIndex_Run(t *testing.T) {
+	requireRoot(t)
+
+	podPath := newTestNetNSWithAddr(t, "gcu-test-idx-run", "10.99.2.5/24")
+
+	idx := NewIPIndex(RuntimeContainerd, newTestHostRoot(t, newTestNetNS(t, "gcu-test-idx-host")))
+
+	// the events are sent while the index is rebuilt, on an unbuffered channel.
+	events := make(chan ContainerEvent)
+	subscribed := false
+	idx.watchEvents = func(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+		subscribed = true
+		return events, make(chan error)
+	}
+	idx.listGroups = func() ([]NetNSGroup, error) {
+		if !subscribed {
+			t.Errorf("index rebuilt before subscribing to the events")
+		}
+		events <- ContainerEvent{Type: ContainerEventStart, RuntimeID: "containerd://started"}
+		events <- ContainerEvent{Type: ContainerEventStop, RuntimeID: "containerd://stopped"}
+		close(events)
+
+		// the listing is older than the events.
+		return []NetNSGroup{{NetNSID: nsInode(t, podPath), NetNSPath: podPath, Containers: []Container{
+			&fakeNetNSContainer{id: "stopped"},
+		}}}, nil
+	}
+	idx.newContainer = func(runtimeID string) (Container, error) {
+		return &fakeNetNSContainer{id: "started", netnsPath: podPath}, nil
+	}
+
+	if err := idx.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	owners := idx.ContainerByIP(net.ParseIP("10.99.2.5"))
+	if len(owners) != 1 || owners[0].RuntimeID != "containerd://started" {
+		t.Errorf("unexpected owners %+v", owners)
+	}
+}
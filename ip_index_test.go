@@ -0,0 +1,48 @@
+package container
+
+import (
+	"net"
+	"testing"
+)
+
+func Test_IPIndex_setAndRemove(t *testing.T) {
+	state := &NetworkState{
+		Interfaces: []InterfaceState{
+			{Name: "lo", Addresses: []AddressState{{IP: "127.0.0.1", PrefixLen: 8}, {IP: "::1", PrefixLen: 128}}},
+			{Name: "eth0", Addresses: []AddressState{
+				{IP: "10.244.1.5", PrefixLen: 24},
+				{IP: "10.244.1.6", PrefixLen: 24, Secondary: true},
+				{IP: "fd00::5", PrefixLen: 64},
+				{IP: "fe80::1", PrefixLen: 64},
+			}},
+		},
+	}
+
+	idx := NewIPIndex(RuntimeContainerd, "/")
+	idx.setLocked("containerd://pause", ipOwners("containerd://pause", 1, state))
+	idx.setLocked("containerd://app", ipOwners("containerd://app", 1, state))
+
+	owners := idx.ContainerByIP(net.ParseIP("10.244.1.6"))
+	if len(owners) != 2 || owners[0].RuntimeID != "containerd://app" || !owners[0].Secondary || owners[0].Interface != "eth0" {
+		t.Errorf("unexpected owners of secondary address: %+v", owners)
+	}
+	if owners := idx.ContainerByIP(net.ParseIP("fd00::5")); len(owners) != 2 {
+		t.Errorf("unexpected owners of ipv6 address: %+v", owners)
+	}
+	for _, ip := range []string{"127.0.0.1", "::1", "fe80::1", "10.244.1.7"} {
+		if owners := idx.ContainerByIP(net.ParseIP(ip)); owners != nil {
+			t.Errorf("unexpected owners of %s: %+v", ip, owners)
+		}
+	}
+
+	idx.Remove("containerd://app")
+	owners = idx.ContainerByIP(net.ParseIP("10.244.1.5").To16())
+	if len(owners) != 1 || owners[0].RuntimeID != "containerd://pause" {
+		t.Errorf("unexpected owners after remove: %+v", owners)
+	}
+
+	idx.Remove("containerd://pause")
+	if len(idx.byIP) != 0 || len(idx.owners) != 0 {
+		t.Errorf("index is not empty after removing all containers: %+v", idx.byIP)
+	}
+}
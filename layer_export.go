@@ -0,0 +1,313 @@
+package container
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// LayerCompression is the compression of an exported layer tarball.
+type LayerCompression string
+
+const (
+	LayerCompressionNone LayerCompression = ""
+	LayerCompressionGzip LayerCompression = "gzip"
+	LayerCompressionZstd LayerCompression = "zstd"
+)
+
+const (
+	// ociWhiteoutPrefix is the prefix of the name of the entries of an OCI layer which delete
+	// the entry of the lower layers without the prefix.
+	ociWhiteoutPrefix = ".wh."
+
+	// ociOpaqueWhiteout is the name of the entry of an OCI layer which hides all the entries
+	// of the lower layers in its directory.
+	ociOpaqueWhiteout = ".wh..wh..opq"
+)
+
+type ExportLayerOptions struct {
+	// Timestamp, if not zero, replaces the modification time of all the entries, and the access
+	// and change times are dropped, so that exporting the same files gives the same digests.
+	Timestamp time.Time
+}
+
+// ExportedLayer describes a layer tarball written by ExportUpperLayer.
+type ExportedLayer struct {
+	// MediaType is the OCI media type of the layer, eg: application/vnd.oci.image.layer.v1.tar+gzip
+	MediaType string `json:"mediaType"`
+
+	// DiffID is the digest of the uncompressed tarball, Digest and Size the ones of the written,
+	// compressed, tarball.
+	DiffID digest.Digest `json:"diffID"`
+	Digest digest.Digest `json:"digest"`
+	Size   int64         `json:"size"`
+}
+
+// exportUpperLayer writes the upper dir of the overlay filesystem of lowerDir and upperDir to w
+// as an OCI layer tarball to apply on top of the lower dirs.
+//
+// The whiteouts and the opaque dirs of overlay are converted to the whiteout entries of OCI layers.
+// The content of metacopy files is read from the lower dirs. A redirected dir has no equivalent in OCI layers,
+// it is written as an opaque dir with its merged content. Hardlinks, ownership and xattrs are preserved,
+// except the xattrs of overlay itself.
+func exportUpperLayer(ctx context.Context, lowerDir, upperDir string, w io.Writer, compression LayerCompression, opts ExportLayerOptions) (*ExportedLayer, error) {
+	layer := &ExportedLayer{}
+	compressedDigester := digest.Canonical.Digester()
+	counter := &countingWriter{}
+	compressed := io.MultiWriter(w, compressedDigester.Hash(), counter)
+
+	var cw io.WriteCloser
+	switch compression {
+	case LayerCompressionNone:
+		layer.MediaType = ocispec.MediaTypeImageLayer
+		cw = nopWriteCloser{compressed}
+	case LayerCompressionGzip:
+		layer.MediaType = ocispec.MediaTypeImageLayerGzip
+		cw = gzip.NewWriter(compressed)
+	case LayerCompressionZstd:
+		layer.MediaType = ocispec.MediaTypeImageLayerZstd
+		zw, err := zstd.NewWriter(compressed, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("create zstd writer failed, err: %s", err)
+		}
+		cw = zw
+	default:
+		return nil, fmt.Errorf("unsupported layer compression: %s", compression)
+	}
+
+	diffDigester := digest.Canonical.Digester()
+	tw := tar.NewWriter(io.MultiWriter(cw, diffDigester.Hash()))
+
+	e := &layerExporter{
+		ctx:    ctx,
+		tw:     tw,
+		merged: NewOverlayView(lowerDir, upperDir),
+		opts:   opts,
+		links:  hardlinks{},
+	}
+
+	err := filepath.WalkDir(upperDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(upperDir, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if isOverlayWhiteout(fi) {
+			return e.writeWhiteout(path.Join(path.Dir(name), ociWhiteoutPrefix+path.Base(name)), fi)
+		}
+
+		if err := e.writeEntry(name, p, fi); err != nil {
+			return err
+		}
+
+		if !fi.IsDir() {
+			return nil
+		}
+
+		if _, redirected := e.merged.redirect(p); redirected {
+			if err := e.writeWhiteout(path.Join(name, ociOpaqueWhiteout), fi); err != nil {
+				return err
+			}
+			return e.writeMergedTree(name)
+		}
+
+		if e.merged.isOpaque(p) {
+			return e.writeWhiteout(path.Join(name, ociOpaqueWhiteout), fi)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("write layer of upper dir (%s) failed, err: %w", upperDir, err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("close tar writer failed, err: %s", err)
+	}
+	if err := cw.Close(); err != nil {
+		return nil, fmt.Errorf("close %s writer failed, err: %s", compression, err)
+	}
+
+	layer.DiffID = diffDigester.Digest()
+	layer.Digest = compressedDigester.Digest()
+	layer.Size = counter.n
+
+	return layer, nil
+}
+
+type layerExporter struct {
+	ctx    context.Context
+	tw     *tar.Writer
+	merged *OverlayView
+	opts   ExportLayerOptions
+
+	// links are the names of the files with several links already written.
+	links hardlinks
+}
+
+// writeMergedTree writes the entries under the dir name of the merged view, skipping the dir.
+// The entries are read from the merged view, which makes them independent of the lower layers.
+func (e *layerExporter) writeMergedTree(name string) error {
+	err := fs.WalkDir(e.merged, name, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := e.ctx.Err(); err != nil {
+			return err
+		}
+		if p == name {
+			return nil
+		}
+
+		fi, err := e.merged.Lstat(p)
+		if err != nil {
+			return err
+		}
+		real, _, err := e.merged.RealPath(p)
+		if err != nil {
+			return err
+		}
+
+		return e.writeEntry(p, real, fi)
+	})
+	if err != nil {
+		return err
+	}
+
+	return fs.SkipDir
+}
+
+// writeEntry writes the entry name of the merged view, whose uppermost file is real.
+func (e *layerExporter) writeEntry(name, real string, fi fs.FileInfo) error {
+	// sockets can not be archived, they are recreated by their servers.
+	if fi.Mode()&fs.ModeSocket != 0 {
+		return nil
+	}
+
+	var link string
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(real)
+		if err != nil {
+			return err
+		}
+		link = target
+	}
+
+	hdr, err := tarHeader(fi, name, link)
+	if err != nil {
+		return fmt.Errorf("create tar header of (%s) failed, err: %s", name, err)
+	}
+
+	xattrs, err := listXattrs(real)
+	if err != nil {
+		return fmt.Errorf("list xattrs of (%s) failed, err: %s", name, err)
+	}
+	for key, value := range xattrs {
+		if strings.HasPrefix(key, "trusted.overlay.") || strings.HasPrefix(key, "user.overlay.") {
+			continue
+		}
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = map[string]string{}
+		}
+		hdr.PAXRecords["SCHILY.xattr."+key] = value
+	}
+
+	if first, ok := e.links.first(fi, name); ok {
+		hdr.Typeflag = tar.TypeLink
+		hdr.Linkname = first
+		hdr.Size = 0
+	}
+
+	e.normalizeTimes(hdr)
+
+	if err := e.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write tar header of (%s) failed, err: %s", name, err)
+	}
+
+	if hdr.Typeflag != tar.TypeReg || hdr.Size == 0 {
+		return nil
+	}
+
+	// the content of a metacopy file is in the lower layers.
+	f, err := e.merged.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.CopyN(e.tw, f, hdr.Size); err != nil {
+		return fmt.Errorf("write content of (%s) failed, err: %s", name, err)
+	}
+
+	return nil
+}
+
+func (e *layerExporter) writeWhiteout(name string, fi fs.FileInfo) error {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		ModTime:  fi.ModTime(),
+		Format:   tar.FormatPAX,
+	}
+	e.normalizeTimes(hdr)
+
+	if err := e.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write tar header of (%s) failed, err: %s", name, err)
+	}
+	return nil
+}
+
+func (e *layerExporter) normalizeTimes(hdr *tar.Header) {
+	if e.opts.Timestamp.IsZero() {
+		return
+	}
+	hdr.ModTime = e.opts.Timestamp
+	hdr.AccessTime = time.Time{}
+	hdr.ChangeTime = time.Time{}
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
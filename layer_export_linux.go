@@ -0,0 +1,58 @@
+package container
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileID identifies a file on the host, for the detection of hardlinks.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// linkedFileID returns the id of the file if it has several links.
+func linkedFileID(fi fs.FileInfo) (fileID, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return fileID{}, false
+	}
+	return fileID{dev: st.Dev, ino: st.Ino}, true
+}
+
+// listXattrs returns the extended attributes of the file, without following a symbolic link.
+func listXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size)
+	n, err := unix.Llistxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := map[string]string{}
+	for _, name := range bytes.Split(buf[:n], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, ok := overlayXattr(path, string(name))
+		if ok {
+			xattrs[string(name)] = value
+		}
+	}
+
+	return xattrs, nil
+}
@@ -0,0 +1,143 @@
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"golang.org/x/sys/unix"
+)
+
+func Test_exportUpperLayer(t *testing.T) {
+	requireRoot(t)
+
+	ctx := context.Background()
+	opts, merged := newTestOverlayLayers(t)
+
+	if err := os.Link(filepath.Join(merged, "etc/new"), filepath.Join(merged, "etc/new-link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Setxattr(filepath.Join(merged, "etc/new"), "user.test", []byte("value"), 0); err != nil {
+		t.Fatal(err)
+	}
+	sock, err := unix.Socket(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(sock)
+	if err := unix.Bind(sock, &unix.SockaddrUnix{Name: filepath.Join(merged, "etc/app.sock")}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	layer, err := exportUpperLayer(ctx, opts.LowerDir, opts.UpperDir, &buf, LayerCompressionNone, ExportLayerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layer.DiffID != digest.FromBytes(buf.Bytes()) || layer.Digest != layer.DiffID || layer.Size != int64(buf.Len()) {
+		t.Errorf("unexpected layer: %+v", layer)
+	}
+
+	headers := map[string]*tar.Header{}
+	contents := map[string]string{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		headers[hdr.Name] = hdr
+		contents[hdr.Name] = string(data)
+	}
+
+	for _, name := range []string{
+		"etc/.wh.hosts",
+		"opt/.wh.app",
+		"usr/share/.wh.doc",
+		"var/cache/x/.wh..wh..opq",
+		"var/cache/x/3",
+		// the redirected dir is written with its merged content
+		"opt/renamed/",
+		"opt/renamed/.wh..wh..opq",
+		"opt/renamed/config",
+		"opt/renamed/data/file",
+		"doc/b.txt",
+	} {
+		if _, ok := headers[name]; !ok {
+			t.Errorf("entry %s not found", name)
+		}
+	}
+	for name := range headers {
+		if strings.Contains(name, "a.txt") || strings.HasPrefix(name, "etc/passwd") || name == "etc/app.sock" {
+			t.Errorf("unexpected entry %s", name)
+		}
+	}
+
+	if contents["data/big"] != strings.Repeat("x", 1<<16) || headers["data/big"].Mode != 0600 {
+		t.Errorf("unexpected metacopy entry: %+v", headers["data/big"])
+	}
+	if contents["opt/renamed/config"] != "config" {
+		t.Errorf("unexpected content of a redirected dir entry: %q", contents["opt/renamed/config"])
+	}
+	if hdr := headers["etc/new-link"]; hdr == nil || hdr.Typeflag != tar.TypeLink || hdr.Linkname != "etc/new" {
+		t.Errorf("unexpected hardlink entry: %+v", hdr)
+	}
+	if hdr := headers["etc/new"]; hdr == nil || hdr.PAXRecords["SCHILY.xattr.user.test"] != "value" {
+		t.Errorf("unexpected xattrs: %+v", hdr)
+	}
+	for name, hdr := range headers {
+		for key := range hdr.PAXRecords {
+			if strings.Contains(key, "overlay.") {
+				t.Errorf("%s: overlay xattr %s exported", name, key)
+			}
+		}
+	}
+
+	// compressed layers are reproducible with normalized timestamps
+	ts := time.Unix(1700000000, 0)
+	var gz1, gz2 bytes.Buffer
+	layer1, err := exportUpperLayer(ctx, opts.LowerDir, opts.UpperDir, &gz1, LayerCompressionGzip, ExportLayerOptions{Timestamp: ts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(merged, "etc/new"), time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	layer2, err := exportUpperLayer(ctx, opts.LowerDir, opts.UpperDir, &gz2, LayerCompressionGzip, ExportLayerOptions{Timestamp: ts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layer1.Digest != layer2.Digest || layer1.Digest != digest.FromBytes(gz1.Bytes()) {
+		t.Errorf("layers differ: %+v %+v", layer1, layer2)
+	}
+
+	zr, err := gzip.NewReader(&gz1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffID, err := digest.FromReader(zr)
+	if err != nil || diffID != layer1.DiffID {
+		t.Errorf("unexpected diffID %s, expected %s", layer1.DiffID, diffID)
+	}
+
+	var zst bytes.Buffer
+	layer3, err := exportUpperLayer(ctx, opts.LowerDir, opts.UpperDir, &zst, LayerCompressionZstd, ExportLayerOptions{Timestamp: ts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layer3.DiffID != layer1.DiffID || layer3.MediaType != "application/vnd.oci.image.layer.v1.tar+zstd" {
+		t.Errorf("unexpected zstd layer: %+v", layer3)
+	}
+}
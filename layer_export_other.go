@@ -0,0 +1,20 @@
+//go:build !linux
+
+package container
+
+import (
+	"io/fs"
+)
+
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+func linkedFileID(fi fs.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
+
+func listXattrs(path string) (map[string]string, error) {
+	return nil, nil
+}
@@ -0,0 +1,348 @@
+// Package metrics exposes the network counters of the containers on the node as Prometheus metrics.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	container "github.com/bougou/go-container-utils"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultCacheTTL is the default duration during which the collected counters are served
+	// without entering the network namespaces again.
+	DefaultCacheTTL = 10 * time.Second
+
+	// DefaultTimeout is the default duration after which a collection stops waiting for the
+	// network namespaces which are not done yet.
+	DefaultTimeout = 5 * time.Second
+)
+
+var containerLabels = []string{"runtime", "container_id", "pod_namespace", "pod_name", "interface", "host_interface"}
+
+var (
+	receiveBytesDesc = prometheus.NewDesc("container_netns_receive_bytes_total",
+		"Number of bytes received by the interface inside the container network namespace.", containerLabels, nil)
+	receivePacketsDesc = prometheus.NewDesc("container_netns_receive_packets_total",
+		"Number of packets received by the interface inside the container network namespace.", containerLabels, nil)
+	receiveDroppedDesc = prometheus.NewDesc("container_netns_receive_packets_dropped_total",
+		"Number of packets dropped while receiving on the interface inside the container network namespace.", containerLabels, nil)
+	receiveErrorsDesc = prometheus.NewDesc("container_netns_receive_errors_total",
+		"Number of errors while receiving on the interface inside the container network namespace.", containerLabels, nil)
+	transmitBytesDesc = prometheus.NewDesc("container_netns_transmit_bytes_total",
+		"Number of bytes transmitted by the interface inside the container network namespace.", containerLabels, nil)
+	transmitPacketsDesc = prometheus.NewDesc("container_netns_transmit_packets_total",
+		"Number of packets transmitted by the interface inside the container network namespace.", containerLabels, nil)
+	transmitDroppedDesc = prometheus.NewDesc("container_netns_transmit_packets_dropped_total",
+		"Number of packets dropped while transmitting on the interface inside the container network namespace.", containerLabels, nil)
+	transmitErrorsDesc = prometheus.NewDesc("container_netns_transmit_errors_total",
+		"Number of errors while transmitting on the interface inside the container network namespace.", containerLabels, nil)
+
+	collectDurationDesc = prometheus.NewDesc("container_netns_collect_duration_seconds",
+		"Duration of the last collection of the network namespaces.", []string{"runtime"}, nil)
+	collectFailuresDesc = prometheus.NewDesc("container_netns_collect_failures",
+		"Number of failures of the last collection, the listing of the containers or the network namespaces which failed or timed out.", []string{"runtime"}, nil)
+)
+
+// Collector is a prometheus.Collector of the per-interface counters inside the network namespace
+// of every container of a runtime on the node.
+//
+// The containers sharing a network namespace, eg: the containers of a pod, are reported once,
+// under the pod sandbox if there is one. Containers running in host network mode are skipped.
+type Collector struct {
+	// CacheTTL is the duration during which a collection is served to the scrapes, DefaultCacheTTL by default.
+	CacheTTL time.Duration
+
+	// Timeout bounds the duration of a collection, DefaultTimeout by default.
+	// The network namespaces not done in time are served with the counters of the previous collection.
+	Timeout time.Duration
+
+	// Concurrency is the number of network namespaces entered in parallel,
+	// container.DefaultInventoryConcurrency by default.
+	Concurrency int
+
+	runtime  container.Runtime
+	hostRoot string
+
+	// listGroups lists the network namespaces of the node, replaced by the tests.
+	listGroups func() ([]container.NetNSGroup, error)
+
+	mu          sync.Mutex
+	samples     map[uint64]*netnsSample
+	collectedAt time.Time
+	duration    time.Duration
+	failures    int
+}
+
+// netnsSample are the counters of the interfaces of a network namespace.
+type netnsSample struct {
+	containerID  string
+	podNamespace string
+	podName      string
+	interfaces   []interfaceSample
+}
+
+type interfaceSample struct {
+	name          string
+	hostInterface string
+	stats         container.InterfaceStats
+}
+
+// NewCollector returns a Collector of the containers of the runtime on the node whose root is mounted at hostRoot.
+// It is registered like any other collector, eg: prometheus.MustRegister(metrics.NewCollector(container.RuntimeContainerd, "/")).
+func NewCollector(runtime container.Runtime, hostRoot string) *Collector {
+	return &Collector{
+		CacheTTL:    DefaultCacheTTL,
+		Timeout:     DefaultTimeout,
+		Concurrency: container.DefaultInventoryConcurrency,
+		runtime:     runtime,
+		hostRoot:    hostRoot,
+		listGroups: func() ([]container.NetNSGroup, error) {
+			return container.NodeNetNSGroups(runtime, hostRoot)
+		},
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		receiveBytesDesc, receivePacketsDesc, receiveDroppedDesc, receiveErrorsDesc,
+		transmitBytesDesc, transmitPacketsDesc, transmitDroppedDesc, transmitErrorsDesc,
+		collectDurationDesc, collectFailuresDesc,
+	} {
+		ch <- desc
+	}
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.samples == nil || time.Since(c.collectedAt) >= c.CacheTTL {
+		c.refresh()
+	}
+
+	runtime := string(c.runtime)
+	ids := make([]uint64, 0, len(c.samples))
+	for id := range c.samples {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		sample := c.samples[id]
+		for _, iface := range sample.interfaces {
+			labels := []string{runtime, sample.containerID, sample.podNamespace, sample.podName, iface.name, iface.hostInterface}
+			for desc, value := range map[*prometheus.Desc]uint64{
+				receiveBytesDesc:    iface.stats.RxBytes,
+				receivePacketsDesc:  iface.stats.RxPackets,
+				receiveDroppedDesc:  iface.stats.RxDropped,
+				receiveErrorsDesc:   iface.stats.RxErrors,
+				transmitBytesDesc:   iface.stats.TxBytes,
+				transmitPacketsDesc: iface.stats.TxPackets,
+				transmitDroppedDesc: iface.stats.TxDropped,
+				transmitErrorsDesc:  iface.stats.TxErrors,
+			} {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value), labels...)
+			}
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(collectDurationDesc, prometheus.GaugeValue, c.duration.Seconds(), runtime)
+	ch <- prometheus.MustNewConstMetric(collectFailuresDesc, prometheus.GaugeValue, float64(c.failures), runtime)
+}
+
+type sampleResult struct {
+	netnsID uint64
+	sample  *netnsSample
+	err     error
+}
+
+// refresh collects the counters of all the network namespaces, within c.Timeout.
+// It must be called with c.mu held.
+func (c *Collector) refresh() {
+	start := time.Now()
+	defer func() {
+		c.collectedAt = time.Now()
+		c.duration = c.collectedAt.Sub(start)
+	}()
+
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	concurrency := c.Concurrency
+	if concurrency <= 0 {
+		concurrency = container.DefaultInventoryConcurrency
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// listing the containers can not be cancelled either, it is abandoned on timeout.
+	type listResult struct {
+		groups []container.NetNSGroup
+		err    error
+	}
+	listCh := make(chan listResult, 1)
+	go func() {
+		groups, err := c.listGroups()
+		listCh <- listResult{groups, err}
+	}()
+
+	var groups []container.NetNSGroup
+	select {
+	case r := <-listCh:
+		groups = r.groups
+		if r.err != nil {
+			// keep serving the previous counters, the failure is reported by the failures gauge.
+			c.failures = 1
+			return
+		}
+	case <-ctx.Done():
+		c.failures = 1
+		return
+	}
+
+	hostNetNSID, _ := container.HostNetNSID(c.hostRoot)
+
+	var pending []container.NetNSGroup
+	for _, group := range groups {
+		if group.NetNSID != hostNetNSID && len(group.Containers) > 0 {
+			pending = append(pending, group)
+		}
+	}
+
+	// the channel is large enough for the goroutines still running after the timeout to never block.
+	results := make(chan sampleResult, len(pending))
+	sem := make(chan struct{}, concurrency)
+	go func() {
+		for _, group := range pending {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				results <- sampleResult{netnsID: group.NetNSID, err: ctx.Err()}
+				continue
+			}
+
+			go func(group container.NetNSGroup) {
+				defer func() { <-sem }()
+				sample, err := sampleNetNS(ctx, group)
+				results <- sampleResult{netnsID: group.NetNSID, sample: sample, err: err}
+			}(group)
+		}
+	}()
+
+	samples := make(map[uint64]*netnsSample, len(pending))
+	failures := 0
+
+	fallback := func(netnsID uint64) {
+		failures++
+		if previous, ok := c.samples[netnsID]; ok {
+			samples[netnsID] = previous
+		}
+	}
+
+	received := map[uint64]bool{}
+	for len(received) < len(pending) {
+		select {
+		case r := <-results:
+			received[r.netnsID] = true
+			if r.err != nil {
+				fallback(r.netnsID)
+				continue
+			}
+			samples[r.netnsID] = r.sample
+		case <-ctx.Done():
+			for _, group := range pending {
+				if !received[group.NetNSID] {
+					received[group.NetNSID] = true
+					fallback(group.NetNSID)
+				}
+			}
+		}
+	}
+
+	c.samples = samples
+	c.failures = failures
+}
+
+// sampleNetNS reads the counters of the interfaces inside the network namespace of the group.
+func sampleNetNS(ctx context.Context, group container.NetNSGroup) (*netnsSample, error) {
+	owner, labels := netnsOwner(group.Containers)
+
+	state, err := owner.NetworkState(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get network state of container (%s) failed, err: %s", owner.RuntimeID(), err)
+	}
+
+	// GetInterfacesNodeMapping can not be cancelled, it is abandoned on timeout.
+	type nodeMapping struct {
+		m   map[string]string
+		err error
+	}
+	mappingCh := make(chan nodeMapping, 1)
+	go func() {
+		m, err := owner.GetInterfacesNodeMapping()
+		mappingCh <- nodeMapping{m, err}
+	}()
+
+	var hostInterfaces map[string]string
+	select {
+	case r := <-mappingCh:
+		if r.err != nil {
+			return nil, fmt.Errorf("get interfaces node mapping of container (%s) failed, err: %s", owner.RuntimeID(), r.err)
+		}
+		hostInterfaces = r.m
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	sample := &netnsSample{
+		containerID:  owner.RuntimeID(),
+		podNamespace: labels[podNamespaceLabel],
+		podName:      labels[podNameLabel],
+	}
+
+	for _, iface := range state.Interfaces {
+		if iface.Name == "lo" || iface.Stats == nil {
+			continue
+		}
+		sample.interfaces = append(sample.interfaces, interfaceSample{
+			name:          iface.Name,
+			hostInterface: hostInterfaces[iface.Name],
+			stats:         *iface.Stats,
+		})
+	}
+
+	return sample, nil
+}
+
+const (
+	podNamespaceLabel = "io.kubernetes.pod.namespace"
+	podNameLabel      = "io.kubernetes.pod.name"
+)
+
+// netnsOwner returns the container the counters of a network namespace are reported under:
+// the pod sandbox if there is one, or else the first container. The labels of the container are returned along.
+func netnsOwner(containers []container.Container) (container.Container, map[string]string) {
+	var firstLabels map[string]string
+	for i, c := range containers {
+		labels, err := c.Labels()
+		if err != nil {
+			continue
+		}
+		if i == 0 {
+			firstLabels = labels
+		}
+		// set by the CRI plugin of containerd and by dockershim/cri-dockerd respectively
+		if labels["io.cri-containerd.kind"] == "sandbox" || labels["io.kubernetes.docker.type"] == "podsandbox" {
+			return c, labels
+		}
+	}
+
+	return containers[0], firstLabels
+}
@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	container "github.com/bougou/go-container-utils"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// fakeContainer implements the methods of container.Container used by the collector.
+type fakeContainer struct {
+	container.Container
+
+	id     string
+	labels map[string]string
+	delay  time.Duration
+	rx     uint64
+}
+
+func (f *fakeContainer) RuntimeID() string {
+	return "containerd://" + f.id
+}
+
+func (f *fakeContainer) Labels() (map[string]string, error) {
+	return f.labels, nil
+}
+
+func (f *fakeContainer) NetworkState(ctx context.Context) (*container.NetworkState, error) {
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return &container.NetworkState{
+		Interfaces: []container.InterfaceState{
+			{Name: "lo", Stats: &container.InterfaceStats{RxBytes: 1}},
+			{Name: "eth0", Stats: &container.InterfaceStats{RxBytes: f.rx, TxPackets: 7}},
+		},
+	}, nil
+}
+
+func (f *fakeContainer) GetInterfacesNodeMapping() (map[string]string, error) {
+	return map[string]string{"eth0": "veth-" + f.id}, nil
+}
+
+// collect returns the receive bytes per container id and the failures gauge.
+func collect(t *testing.T, c *Collector) (map[string]float64, float64) {
+	ch := make(chan prometheus.Metric, 100)
+	c.Collect(ch)
+	close(ch)
+
+	rx := map[string]float64{}
+	var failures float64
+	for m := range ch {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		labels := map[string]string{}
+		for _, l := range metric.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+
+		switch m.Desc() {
+		case receiveBytesDesc:
+			if labels["interface"] != "eth0" || labels["host_interface"] != "veth-"+labels["container_id"][len("containerd://"):] {
+				t.Errorf("unexpected labels: %v", labels)
+			}
+			rx[labels["container_id"]+"/"+labels["pod_name"]] = metric.GetCounter().GetValue()
+		case collectFailuresDesc:
+			failures = metric.GetGauge().GetValue()
+		}
+	}
+	return rx, failures
+}
+
+func TestCollector(t *testing.T) {
+	sandbox := &fakeContainer{id: "sandbox", rx: 100, labels: map[string]string{
+		"io.cri-containerd.kind": "sandbox", podNameLabel: "web", podNamespaceLabel: "default",
+	}}
+	app := &fakeContainer{id: "app", labels: map[string]string{"io.cri-containerd.kind": "container"}}
+	slow := &fakeContainer{id: "slow", rx: 200}
+
+	var lists atomic.Int32
+	c := NewCollector(container.RuntimeContainerd, "/")
+	c.Timeout = 200 * time.Millisecond
+	c.listGroups = func() ([]container.NetNSGroup, error) {
+		lists.Add(1)
+		return []container.NetNSGroup{
+			{NetNSID: 1, Containers: []container.Container{app, sandbox}},
+			{NetNSID: 2, Containers: []container.Container{slow}},
+		}, nil
+	}
+
+	rx, failures := collect(t, c)
+	if len(rx) != 2 || rx["containerd://sandbox/web"] != 100 || rx["containerd://slow/"] != 200 || failures != 0 {
+		t.Fatalf("unexpected metrics: %v, failures: %v", rx, failures)
+	}
+
+	// served from the cache
+	sandbox.rx = 150
+	collect(t, c)
+	if lists.Load() != 1 {
+		t.Errorf("unexpected collections: %d", lists.Load())
+	}
+
+	// the slow network namespace is served with its previous counters
+	c.CacheTTL = 0
+	slow.delay = time.Second
+	start := time.Now()
+	rx, failures = collect(t, c)
+	if elapsed := time.Since(start); elapsed > time.Second/2 {
+		t.Errorf("collection not bounded in time: %s", elapsed)
+	}
+	if rx["containerd://sandbox/web"] != 150 || rx["containerd://slow/"] != 200 || failures != 1 {
+		t.Errorf("unexpected metrics: %v, failures: %v", rx, failures)
+	}
+}
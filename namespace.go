@@ -0,0 +1,66 @@
+package container
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// NamespaceType is the type of a linux namespace, named after the entries under /proc/<pid>/ns.
+type NamespaceType string
+
+const (
+	NamespaceNet    NamespaceType = "net"
+	NamespaceUTS    NamespaceType = "uts"
+	NamespaceIPC    NamespaceType = "ipc"
+	NamespacePID    NamespaceType = "pid"
+	NamespaceCgroup NamespaceType = "cgroup"
+	NamespaceUser   NamespaceType = "user"
+	NamespaceMnt    NamespaceType = "mnt"
+)
+
+// namespaceOrder is the order in which namespaces are joined.
+// The user namespace must be joined first so that the capabilities gained in it apply to
+// the others, and the mount namespace is joined last so that the namespace paths are still
+// resolved in the mount namespace of the caller.
+var namespaceOrder = []NamespaceType{
+	NamespaceUser,
+	NamespaceCgroup,
+	NamespaceIPC,
+	NamespaceUTS,
+	NamespaceNet,
+	NamespacePID,
+	NamespaceMnt,
+}
+
+var ErrNotRunning error = fmt.Errorf("container is not running")
+
+// ErrReexecRequired is returned by Do for namespaces which can only be joined by
+// a single-threaded process. Use DoReexec for them instead.
+var ErrReexecRequired error = fmt.Errorf("namespace must be joined by a re-executed process")
+
+// namespacePaths returns the paths under /proc/<pid>/ns of the given namespaces of the process.
+func namespacePaths(hostRoot string, pid int, nsTypes []NamespaceType) map[NamespaceType]string {
+	paths := make(map[NamespaceType]string, len(nsTypes))
+	for _, nsType := range nsTypes {
+		paths[nsType] = filepath.Join(hostRoot, "proc", strconv.Itoa(pid), "ns", string(nsType))
+	}
+	return paths
+}
+
+// hostNetNSPath returns the path of the network namespace of the host, the one of its init process.
+func hostNetNSPath(hostRoot string) string {
+	return namespacePaths(hostRoot, 1, []NamespaceType{NamespaceNet})[NamespaceNet]
+}
+
+// hostRunPath returns the path under hostRoot of a path reported by the container runtime.
+// The runtimes report paths under /var/run, which is a symbolic link to /run on the node,
+// and can not be followed when the host root is mounted elsewhere.
+// eg: "/var/run/docker/netns/5048a1a60e3b" -> "<hostRoot>/run/docker/netns/5048a1a60e3b"
+func hostRunPath(hostRoot, path string) string {
+	if strings.HasPrefix(path, "/var/run/") {
+		path, _ = strings.CutPrefix(path, "/var")
+	}
+	return filepath.Join(hostRoot, path)
+}
@@ -0,0 +1,263 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+var namespaceCloneFlags = map[NamespaceType]int{
+	NamespaceNet:    unix.CLONE_NEWNET,
+	NamespaceUTS:    unix.CLONE_NEWUTS,
+	NamespaceIPC:    unix.CLONE_NEWIPC,
+	NamespacePID:    unix.CLONE_NEWPID,
+	NamespaceCgroup: unix.CLONE_NEWCGROUP,
+	NamespaceUser:   unix.CLONE_NEWUSER,
+	NamespaceMnt:    unix.CLONE_NEWNS,
+}
+
+// namespaceInode returns the inode of the namespace file, which identifies the namespace on the node.
+func namespaceInode(path string) (uint64, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return 0, fmt.Errorf("stat namespace (%s) failed, err: %w", path, err)
+	}
+	return st.Ino, nil
+}
+
+// doInNamespaces runs fn on a locked OS thread which has joined the namespaces at the given paths.
+// The original namespaces of the thread are restored after fn returns. If that fails, the thread
+// is left locked so that the go runtime terminates it instead of reusing it.
+//
+// Only namespaces which are per-thread can be joined this way, the user and mount namespaces
+// require a single-threaded process and are rejected with ErrReexecRequired.
+//
+// ctx is only checked before the namespaces are joined: once fn started, doInNamespaces waits for it
+// to return, so that fn never runs in the foreign namespaces after doInNamespaces returned.
+// fn has to honor ctx itself to be cancelled.
+func doInNamespaces(ctx context.Context, paths map[NamespaceType]string, fn func() error) error {
+	for nsType := range paths {
+		if _, ok := namespaceCloneFlags[nsType]; !ok {
+			return fmt.Errorf("unknown namespace type: (%s)", nsType)
+		}
+		if nsType == NamespaceUser || nsType == NamespaceMnt {
+			return fmt.Errorf("join namespace (%s) failed, err: %w", nsType, ErrReexecRequired)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// the goroutine exits with the thread locked when the namespaces can not be restored,
+	// which terminates the thread.
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- doInNamespacesLocked(paths, fn)
+	}()
+	return <-errCh
+}
+
+func doInNamespacesLocked(paths map[NamespaceType]string, fn func() error) (err error) {
+	runtime.LockOSThread()
+
+	restored := true
+	defer func() {
+		if restored {
+			runtime.UnlockOSThread()
+		}
+	}()
+
+	type joinedNamespace struct {
+		nsType NamespaceType
+		orig   *os.File
+	}
+	var joined []joinedNamespace
+
+	defer func() {
+		for i := len(joined) - 1; i >= 0; i-- {
+			ns := joined[i]
+			if e := unix.Setns(int(ns.orig.Fd()), namespaceCloneFlags[ns.nsType]); e != nil {
+				restored = false
+				if err == nil {
+					err = fmt.Errorf("restore namespace (%s) failed, err: %s", ns.nsType, e)
+				}
+			}
+			ns.orig.Close()
+		}
+	}()
+
+	for _, nsType := range namespaceOrder {
+		path, ok := paths[nsType]
+		if !ok {
+			continue
+		}
+
+		orig, err := os.Open(fmt.Sprintf("/proc/thread-self/ns/%s", nsType))
+		if err != nil {
+			return fmt.Errorf("open current namespace (%s) failed, err: %s", nsType, err)
+		}
+
+		target, err := os.Open(path)
+		if err != nil {
+			orig.Close()
+			return fmt.Errorf("open namespace (%s) failed, err: %w", path, err)
+		}
+
+		err = unix.Setns(int(target.Fd()), namespaceCloneFlags[nsType])
+		target.Close()
+		if err != nil {
+			orig.Close()
+			return fmt.Errorf("join namespace (%s) failed, err: %s", path, err)
+		}
+
+		joined = append(joined, joinedNamespace{nsType: nsType, orig: orig})
+	}
+
+	return fn()
+}
+
+const (
+	// reexecHelperEnv holds the name of the helper which the re-executed process runs.
+	reexecHelperEnv = "_CONTAINER_UTILS_REEXEC_HELPER"
+
+	// reexecInodesEnv holds the expected inodes of the namespaces of the re-executed process,
+	// eg: "user:4026531837,mnt:4026532289"
+	reexecInodesEnv = "_CONTAINER_UTILS_REEXEC_INODES"
+
+	// nsenterEnv holds the namespaces which the nsenter package joins before the go runtime starts,
+	// eg: "user:/proc/1234/ns/user,mnt:/proc/1234/ns/mnt"
+	nsenterEnv = "_CONTAINER_UTILS_NSENTER"
+
+	// reexecProcFd is the fd of the /proc directory of the parent passed to the re-executed process.
+	// It is still usable after the mount namespace of the container is joined.
+	reexecProcFd = 3
+)
+
+var reexecHelpers = map[string]func(args []string) error{}
+
+// RegisterReexecHelper registers fn under the given name so that it can be run by DoReexec.
+// It must be called before ReexecInit, typically from an init function.
+func RegisterReexecHelper(name string, fn func(args []string) error) {
+	if _, exists := reexecHelpers[name]; exists {
+		panic(fmt.Sprintf("reexec helper (%s) is already registered", name))
+	}
+	reexecHelpers[name] = fn
+}
+
+// ReexecInit must be called at the very beginning of main (or TestMain) of programs using DoReexec.
+// If the current process was started by DoReexec, ReexecInit runs the requested helper inside the
+// joined namespaces and exits, otherwise it returns immediately.
+func ReexecInit() {
+	name := os.Getenv(reexecHelperEnv)
+	if name == "" {
+		return
+	}
+
+	if err := runReexecHelper(name); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func runReexecHelper(name string) error {
+	fn, ok := reexecHelpers[name]
+	if !ok {
+		return fmt.Errorf("reexec helper (%s) is not registered", name)
+	}
+
+	procDir := os.NewFile(reexecProcFd, "proc")
+	defer procDir.Close()
+
+	for _, item := range strings.Split(os.Getenv(reexecInodesEnv), ",") {
+		nsType, inode, ok := strings.Cut(item, ":")
+		if !ok {
+			continue
+		}
+
+		// joining a pid namespace only applies to the children of the process.
+		nsFile := nsType
+		if NamespaceType(nsType) == NamespacePID {
+			nsFile = "pid_for_children"
+		}
+
+		var st unix.Stat_t
+		if err := unix.Fstatat(int(procDir.Fd()), "thread-self/ns/"+nsFile, &st, 0); err != nil {
+			return fmt.Errorf("stat namespace (%s) failed, err: %s", nsType, err)
+		}
+		if strconv.FormatUint(st.Ino, 10) != inode {
+			return fmt.Errorf("namespace (%s) is not joined, is the nsenter package imported?", nsType)
+		}
+	}
+
+	return fn(os.Args[1:])
+}
+
+// doReexec re-executes the current binary, which joins the namespaces at the given paths before
+// the go runtime starts and then runs the registered helper with args.
+// It returns what the helper writes to stdout.
+func doReexec(ctx context.Context, paths map[NamespaceType]string, helper string, args ...string) ([]byte, error) {
+	if _, ok := reexecHelpers[helper]; !ok {
+		return nil, fmt.Errorf("reexec helper (%s) is not registered", helper)
+	}
+
+	for nsType := range paths {
+		if _, ok := namespaceCloneFlags[nsType]; !ok {
+			return nil, fmt.Errorf("unknown namespace type: (%s)", nsType)
+		}
+	}
+
+	var nsenterItems, inodeItems []string
+	for _, nsType := range namespaceOrder {
+		path, ok := paths[nsType]
+		if !ok {
+			continue
+		}
+
+		var target, current unix.Stat_t
+		if err := unix.Stat(path, &target); err != nil {
+			return nil, fmt.Errorf("stat namespace (%s) failed, err: %s", path, err)
+		}
+		if err := unix.Stat(fmt.Sprintf("/proc/self/ns/%s", nsType), &current); err != nil {
+			return nil, fmt.Errorf("stat current namespace (%s) failed, err: %s", nsType, err)
+		}
+
+		// joining the namespace the process is already in fails for the user namespace,
+		// and is a no-op for the others.
+		if target.Ino != current.Ino || target.Dev != current.Dev {
+			nsenterItems = append(nsenterItems, fmt.Sprintf("%s:%s", nsType, path))
+		}
+		inodeItems = append(inodeItems, fmt.Sprintf("%s:%d", nsType, target.Ino))
+	}
+
+	procDir, err := os.Open("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("open /proc failed, err: %s", err)
+	}
+	defer procDir.Close()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "/proc/self/exe", args...)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%s=%s", reexecHelperEnv, helper),
+		fmt.Sprintf("%s=%s", reexecInodesEnv, strings.Join(inodeItems, ",")),
+		fmt.Sprintf("%s=%s", nsenterEnv, strings.Join(nsenterItems, ",")),
+	)
+	cmd.ExtraFiles = []*os.File{procDir}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("run reexec helper (%s) failed, err: %s, stderr: %s", helper, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
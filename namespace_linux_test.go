@@ -0,0 +1,158 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/bougou/go-container-utils/nsenter"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+func TestMain(m *testing.M) {
+	ReexecInit()
+	os.Exit(m.Run())
+}
+
+func init() {
+	// ReexecInit has already verified the joined namespaces when the helper runs.
+	RegisterReexecHelper("test-echo", func(args []string) error {
+		fmt.Print(strings.Join(args, " "))
+		return nil
+	})
+}
+
+func requireRoot(t *testing.T) {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("test requires root")
+	}
+}
+
+// newTestNetNS creates a named network namespace and returns its path.
+func newTestNetNS(t *testing.T, name string) string {
+	t.Helper()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	orig, err := netns.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orig.Close()
+
+	ns, err := netns.NewNamed(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.Close()
+
+	if err := netns.Set(orig); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		netns.DeleteNamed(name)
+	})
+
+	return "/var/run/netns/" + name
+}
+
+func nsInode(t *testing.T, path string) uint64 {
+	t.Helper()
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		t.Fatal(err)
+	}
+	return st.Ino
+}
+
+func Test_doInNamespaces(t *testing.T) {
+	requireRoot(t)
+
+	netnsPath := newTestNetNS(t, "gcu-test-do")
+	want := nsInode(t, netnsPath)
+	orig := nsInode(t, "/proc/self/ns/net")
+
+	// fn does not run on the test goroutine, it returns its errors instead of failing the test.
+	var got uint64
+	err := doInNamespaces(context.Background(), map[NamespaceType]string{NamespaceNet: netnsPath}, func() error {
+		var st unix.Stat_t
+		if err := unix.Stat("/proc/thread-self/ns/net", &st); err != nil {
+			return err
+		}
+		got = st.Ino
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("inode inside namespace: got %d, want %d", got, want)
+	}
+	if after := nsInode(t, "/proc/self/ns/net"); after != orig {
+		t.Errorf("namespace of the process changed: got %d, want %d", after, orig)
+	}
+
+	// doInNamespaces waits for fn even when ctx is cancelled meanwhile.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := false
+	err = doInNamespaces(ctx, map[NamespaceType]string{NamespaceNet: netnsPath}, func() error {
+		cancel()
+		time.Sleep(50 * time.Millisecond)
+		done = true
+		return nil
+	})
+	if err != nil || !done {
+		t.Errorf("returned before fn, done: %t, err: %v", done, err)
+	}
+	if err := doInNamespaces(ctx, map[NamespaceType]string{NamespaceNet: netnsPath}, func() error { return nil }); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+
+	err = doInNamespaces(context.Background(), map[NamespaceType]string{NamespaceMnt: "/proc/1/ns/mnt"}, func() error { return nil })
+	if err == nil || !strings.Contains(err.Error(), ErrReexecRequired.Error()) {
+		t.Errorf("expected ErrReexecRequired for mount namespace, got: %v", err)
+	}
+}
+
+func Test_doReexec(t *testing.T) {
+	requireRoot(t)
+
+	cmd := exec.Command("unshare", "--mount", "--propagation", "private", "sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("unshare is not available: %s", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	mntPath := "/proc/" + strconv.Itoa(cmd.Process.Pid) + "/ns/mnt"
+
+	// wait for unshare to exec sleep in the new mount namespace.
+	orig := nsInode(t, "/proc/self/ns/mnt")
+	deadline := time.Now().Add(5 * time.Second)
+	for nsInode(t, mntPath) == orig {
+		if time.Now().After(deadline) {
+			t.Fatal("mount namespace was not created")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	out, err := doReexec(context.Background(), map[NamespaceType]string{NamespaceMnt: mntPath}, "test-echo", "hello", "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(out); got != "hello world" {
+		t.Errorf("helper output: got %q, want %q", got, "hello world")
+	}
+}
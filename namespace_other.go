@@ -0,0 +1,25 @@
+//go:build !linux
+
+package container
+
+import (
+	"context"
+)
+
+func namespaceInode(path string) (uint64, error) {
+	return 0, ErrNotImplemented
+}
+
+func doInNamespaces(ctx context.Context, paths map[NamespaceType]string, fn func() error) error {
+	return ErrNotImplemented
+}
+
+func RegisterReexecHelper(name string, fn func(args []string) error) {
+}
+
+func ReexecInit() {
+}
+
+func doReexec(ctx context.Context, paths map[NamespaceType]string, helper string, args ...string) ([]byte, error) {
+	return nil, ErrNotImplemented
+}
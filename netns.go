@@ -0,0 +1,113 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/errdefs"
+)
+
+// NetNSGroup is a network namespace on the node and the containers sharing it,
+// eg: the containers of a pod share the network namespace of the pod sandbox.
+type NetNSGroup struct {
+	NetNSID    uint64
+	NetNSPath  string
+	Containers []Container
+}
+
+// GroupContainersByNetNS groups the containers by their network namespace.
+// Containers which are not running, or which are gone while they are grouped, are skipped.
+// The groups are sorted by NetNSID.
+func GroupContainersByNetNS(containers []Container) ([]NetNSGroup, error) {
+	var items []containerNetNS
+
+	for _, c := range containers {
+		netnsPath, err := c.NetNSPath()
+		if err != nil {
+			if isContainerGone(err) {
+				continue
+			}
+			return nil, fmt.Errorf("get netns path of container (%s) failed, err: %s", c.RuntimeID(), err)
+		}
+		items = append(items, containerNetNS{container: c, netnsPath: netnsPath})
+	}
+
+	return groupContainerNetNS(items)
+}
+
+// containerNetNS is a running container and the path of its network namespace.
+type containerNetNS struct {
+	container Container
+	netnsPath string
+}
+
+// isContainerGone reports whether err is caused by a container which stopped or was deleted,
+// eg: while the containers of the node are listed. Node-wide operations skip such containers.
+func isContainerGone(err error) bool {
+	return errors.Is(err, ErrNotRunning) || errors.Is(err, fs.ErrNotExist) || cerrdefs.IsNotFound(err) || errdefs.IsNotFound(err)
+}
+
+// groupContainerNetNS groups the items by their network namespace,
+// the items whose network namespace is gone are skipped.
+func groupContainerNetNS(items []containerNetNS) ([]NetNSGroup, error) {
+	var groups = map[uint64]*NetNSGroup{}
+
+	for _, item := range items {
+		netnsID, err := namespaceInode(item.netnsPath)
+		if err != nil {
+			if isContainerGone(err) {
+				continue
+			}
+			return nil, fmt.Errorf("get netns id of container (%s) failed, err: %s", item.container.RuntimeID(), err)
+		}
+
+		group, ok := groups[netnsID]
+		if !ok {
+			group = &NetNSGroup{
+				NetNSID:   netnsID,
+				NetNSPath: item.netnsPath,
+			}
+			groups[netnsID] = group
+		}
+		group.Containers = append(group.Containers, item.container)
+	}
+
+	var ret = []NetNSGroup{}
+	for _, group := range groups {
+		ret = append(ret, *group)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].NetNSID < ret[j].NetNSID
+	})
+
+	return ret, nil
+}
+
+// NodeNetNSGroups lists the running containers of the runtime on the node and groups them by their network namespace.
+// For containerd, the network namespaces of all the containers are resolved from a single listing of the containers
+// and of the tasks, instead of inspecting each container.
+func NodeNetNSGroups(runtime Runtime, hostRoot string) ([]NetNSGroup, error) {
+	if runtime == RuntimeContainerd {
+		items, err := listContainerdNetNS(hostRoot)
+		if err != nil {
+			return nil, err
+		}
+		return groupContainerNetNS(items)
+	}
+
+	containers, err := ListContainers(runtime, hostRoot)
+	if err != nil {
+		return nil, fmt.Errorf("list containers failed, err: %s", err)
+	}
+
+	return GroupContainersByNetNS(containers)
+}
+
+// HostNetNSID returns the id of the network namespace of the host,
+// containers running in host network mode have the same NetNSID.
+func HostNetNSID(hostRoot string) (uint64, error) {
+	return namespaceInode(hostNetNSPath(hostRoot))
+}
@@ -0,0 +1,70 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/errdefs"
+)
+
+// fakeNetNSContainer implements the methods of Container used by GroupContainersByNetNS.
+type fakeNetNSContainer struct {
+	Container
+
+	id        string
+	netnsPath string
+	err       error
+
+	// failures is the number of calls of NetNSPath failing with failure before it returns err.
+	failures int
+	failure  error
+}
+
+func (f *fakeNetNSContainer) RuntimeID() string {
+	return "containerd://" + f.id
+}
+
+func (f *fakeNetNSContainer) NetNSPath() (string, error) {
+	if f.failures > 0 {
+		f.failures--
+		return "", f.failure
+	}
+	return f.netnsPath, f.err
+}
+
+func (f *fakeNetNSContainer) WithHostRoot(hostRoot string) {}
+
+func Test_GroupContainersByNetNS(t *testing.T) {
+	requireRoot(t)
+
+	podNetNS := newTestNetNS(t, "gcu-test-group")
+
+	groups, err := GroupContainersByNetNS([]Container{
+		&fakeNetNSContainer{id: "app", netnsPath: podNetNS},
+		&fakeNetNSContainer{id: "sidecar", netnsPath: podNetNS},
+		// an exited container of a pod whose sandbox is gone.
+		&fakeNetNSContainer{id: "exited", netnsPath: "/var/run/netns/cni-gone", err: ErrNotRunning},
+		// containers deleted, or whose network namespace is removed, while they are grouped.
+		&fakeNetNSContainer{id: "deleted", err: fmt.Errorf("load container failed, err: %w", cerrdefs.ErrNotFound)},
+		&fakeNetNSContainer{id: "removed", err: errdefs.NotFound(errors.New("no such container"))},
+		&fakeNetNSContainer{id: "stopped", netnsPath: filepath.Join(t.TempDir(), "gone")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || len(groups[0].Containers) != 2 || groups[0].NetNSID != nsInode(t, podNetNS) {
+		t.Errorf("unexpected groups %+v", groups)
+	}
+
+	// the other failures are returned
+	_, err = GroupContainersByNetNS([]Container{
+		&fakeNetNSContainer{id: "app", netnsPath: podNetNS},
+		&fakeNetNSContainer{id: "broken", err: errors.New("connection refused")},
+	})
+	if err == nil {
+		t.Errorf("expected an error for a container failing to resolve its netns")
+	}
+}
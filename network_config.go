@@ -0,0 +1,87 @@
+package container
+
+type NetworkOpKind string
+
+const (
+	NetworkOpAddAddress NetworkOpKind = "add-address"
+	NetworkOpDelAddress NetworkOpKind = "del-address"
+	NetworkOpAddRoute   NetworkOpKind = "add-route"
+	NetworkOpDelRoute   NetworkOpKind = "del-route"
+	NetworkOpAddRule    NetworkOpKind = "add-rule"
+	NetworkOpDelRule    NetworkOpKind = "del-rule"
+	NetworkOpSetMTU     NetworkOpKind = "set-mtu"
+	NetworkOpSetSysctl  NetworkOpKind = "set-sysctl"
+)
+
+// NetworkOp is a mutation of the network configuration inside the network namespace of a container.
+// Applying a NetworkOp returns its undo record, which is the NetworkOp restoring the original state.
+// NetworkOps can be serialized, so that the undo records survive a restart of the caller.
+type NetworkOp struct {
+	Kind NetworkOpKind `json:"kind"`
+
+	// Interface is the interface of add-address, del-address and set-mtu.
+	Interface string `json:"interface,omitempty"`
+
+	// Address is the address of add-address and del-address, in CIDR notation, eg: 10.0.0.2/24
+	Address string `json:"address,omitempty"`
+
+	// Route is the route of add-route and del-route. For del-route, all the routes matching
+	// the non-empty fields are deleted.
+	Route *RouteSpec `json:"route,omitempty"`
+
+	// Rule is the policy rule of add-rule and del-rule. For del-rule, all the rules matching
+	// the non-empty fields are deleted.
+	Rule *RuleSpec `json:"rule,omitempty"`
+
+	// MTU is the mtu of set-mtu.
+	MTU int `json:"mtu,omitempty"`
+
+	// Sysctl is the key of set-sysctl, which must be under net, eg: net.ipv4.ip_forward
+	// Keys containing an interface name with dots can be given with slashes, eg: net/ipv4/conf/eth0.100/rp_filter
+	Sysctl string `json:"sysctl,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+type RouteSpec struct {
+	// Family is either "inet" or "inet6", derived from Dst, Gateway or Src if empty, inet if all are empty.
+	// It tells the family of a "default" Dst.
+	Family string `json:"family,omitempty"`
+
+	// Dst is the destination in CIDR notation, or "default"
+	Dst       string `json:"dst"`
+	Gateway   string `json:"gateway,omitempty"`
+	Interface string `json:"interface,omitempty"`
+	Src       string `json:"src,omitempty"`
+
+	// Table is the routing table, the main table if 0.
+	Table    int `json:"table,omitempty"`
+	Priority int `json:"priority,omitempty"`
+
+	// Scope is the scope of the route, eg: universe, link, host. If empty, it is link without a gateway,
+	// universe otherwise.
+	Scope string `json:"scope,omitempty"`
+
+	// Protocol is the number of the routing protocol which installed the route, eg: 4 for static, boot if 0.
+	Protocol int `json:"protocol,omitempty"`
+
+	// Type is the route type, eg: unicast, blackhole, unicast if empty.
+	Type string `json:"type,omitempty"`
+}
+
+type RuleSpec struct {
+	// Family is either "inet" or "inet6", derived from Src or Dst if empty, inet if both are empty.
+	Family string `json:"family,omitempty"`
+
+	// Priority is the priority of the rule, nil to let the kernel choose it for an added rule.
+	// A deleted rule must have at least one of Priority, Table, Src, Dst, IifName, OifName and Mark, and
+	// the default rules of the kernel (local, main and default) are only matched by their priority.
+	Priority *int   `json:"priority,omitempty"`
+	Table    int    `json:"table,omitempty"`
+	Src      string `json:"src,omitempty"`
+	Dst      string `json:"dst,omitempty"`
+	IifName  string `json:"iif,omitempty"`
+	OifName  string `json:"oif,omitempty"`
+	Mark     int    `json:"mark,omitempty"`
+	Mask     int    `json:"mask,omitempty"`
+	Invert   bool   `json:"invert,omitempty"`
+}
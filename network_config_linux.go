@@ -0,0 +1,575 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// applyNetworkOps applies the ops in order inside the network namespace at netnsPath, entering it once.
+// It returns the undo records of the applied ops, in the order in which they must be applied to
+// restore the original state. If an op fails, the undo records of the ops applied before it are
+// returned together with the error.
+func applyNetworkOps(ctx context.Context, netnsPath string, ops []NetworkOp) ([]NetworkOp, error) {
+	var undos = []NetworkOp{}
+
+	err := doInNamespaces(ctx, map[NamespaceType]string{NamespaceNet: netnsPath}, func() error {
+		for i, op := range ops {
+			opUndos, err := applyNetworkOp(op)
+			if err != nil {
+				return fmt.Errorf("apply network op #%d (%s) failed, err: %s", i, op.Kind, err)
+			}
+			undos = append(opUndos, undos...)
+		}
+		return nil
+	})
+	if err != nil {
+		return undos, fmt.Errorf("failed inside ns, err: %s", err)
+	}
+
+	return undos, nil
+}
+
+// readSysctlInNetNS reads the value of a net.* sysctl inside the network namespace at netnsPath.
+func readSysctlInNetNS(ctx context.Context, netnsPath string, key string) (string, error) {
+	var value string
+
+	err := doInNamespaces(ctx, map[NamespaceType]string{NamespaceNet: netnsPath}, func() error {
+		var err error
+		value, err = readSysctl(key)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed inside ns, err: %s", err)
+	}
+
+	return value, nil
+}
+
+// applyNetworkOp applies the op in the network namespace of the current thread and returns its undo records.
+func applyNetworkOp(op NetworkOp) ([]NetworkOp, error) {
+	switch op.Kind {
+	case NetworkOpAddAddress, NetworkOpDelAddress:
+		link, err := netlink.LinkByName(op.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("get link (%s) failed, err: %s", op.Interface, err)
+		}
+		addr, err := netlink.ParseAddr(op.Address)
+		if err != nil {
+			return nil, fmt.Errorf("parse address (%s) failed, err: %s", op.Address, err)
+		}
+
+		undo := op
+		if op.Kind == NetworkOpAddAddress {
+			if err := netlink.AddrAdd(link, addr); err != nil {
+				return nil, err
+			}
+			undo.Kind = NetworkOpDelAddress
+		} else {
+			if err := netlink.AddrDel(link, addr); err != nil {
+				return nil, err
+			}
+			undo.Kind = NetworkOpAddAddress
+		}
+		return []NetworkOp{undo}, nil
+
+	case NetworkOpSetMTU:
+		link, err := netlink.LinkByName(op.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("get link (%s) failed, err: %s", op.Interface, err)
+		}
+
+		undo := op
+		undo.MTU = link.Attrs().MTU
+		if err := netlink.LinkSetMTU(link, op.MTU); err != nil {
+			return nil, err
+		}
+		return []NetworkOp{undo}, nil
+
+	case NetworkOpSetSysctl:
+		old, err := readSysctl(op.Sysctl)
+		if err != nil {
+			return nil, err
+		}
+
+		undo := op
+		undo.Value = old
+		if err := writeSysctl(op.Sysctl, op.Value); err != nil {
+			return nil, err
+		}
+		return []NetworkOp{undo}, nil
+
+	case NetworkOpAddRoute:
+		if op.Route == nil {
+			return nil, fmt.Errorf("route can not be empty")
+		}
+		route, err := routeFromSpec(*op.Route)
+		if err != nil {
+			return nil, err
+		}
+		if err := netlink.RouteAdd(route); err != nil {
+			return nil, err
+		}
+		return []NetworkOp{{Kind: NetworkOpDelRoute, Route: op.Route}}, nil
+
+	case NetworkOpDelRoute:
+		if op.Route == nil {
+			return nil, fmt.Errorf("route can not be empty")
+		}
+		routes, err := matchRoutes(*op.Route)
+		if err != nil {
+			return nil, err
+		}
+
+		var undos []NetworkOp
+		for _, route := range routes {
+			spec, err := routeSpecFromRoute(route)
+			if err != nil {
+				return undos, err
+			}
+			if err := netlink.RouteDel(&route); err != nil {
+				return undos, err
+			}
+			undos = append([]NetworkOp{{Kind: NetworkOpAddRoute, Route: spec}}, undos...)
+		}
+		return undos, nil
+
+	case NetworkOpAddRule:
+		if op.Rule == nil {
+			return nil, fmt.Errorf("rule can not be empty")
+		}
+		rule, err := ruleFromSpec(*op.Rule)
+		if err != nil {
+			return nil, err
+		}
+		if err := netlink.RuleAdd(rule); err != nil {
+			return nil, err
+		}
+		return []NetworkOp{{Kind: NetworkOpDelRule, Rule: op.Rule}}, nil
+
+	case NetworkOpDelRule:
+		if op.Rule == nil {
+			return nil, fmt.Errorf("rule can not be empty")
+		}
+		rules, err := matchRules(*op.Rule)
+		if err != nil {
+			return nil, err
+		}
+
+		var undos []NetworkOp
+		for _, rule := range rules {
+			spec := ruleSpecFromRule(rule)
+			if err := netlink.RuleDel(&rule); err != nil {
+				return undos, err
+			}
+			undos = append([]NetworkOp{{Kind: NetworkOpAddRule, Rule: spec}}, undos...)
+		}
+		return undos, nil
+
+	default:
+		return nil, fmt.Errorf("unknown network op: (%s)", op.Kind)
+	}
+}
+
+// sysctlPath returns the path under /proc/sys of a net.* sysctl key.
+// The /proc/sys/net files show the network namespace of the thread opening them.
+func sysctlPath(key string) (string, error) {
+	p := key
+	if !strings.Contains(p, "/") {
+		p = strings.ReplaceAll(p, ".", "/")
+	}
+	p = filepath.Clean(p)
+
+	if !strings.HasPrefix(p, "net/") || strings.Contains(p, "..") {
+		return "", fmt.Errorf("sysctl (%s) is not under net", key)
+	}
+
+	return filepath.Join("/proc/sys", p), nil
+}
+
+func readSysctl(key string) (string, error) {
+	p, err := sysctlPath(key)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return "", fmt.Errorf("read sysctl (%s) failed, err: %s", key, err)
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+func writeSysctl(key, value string) error {
+	p, err := sysctlPath(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(p, []byte(value), 0644); err != nil {
+		return fmt.Errorf("write sysctl (%s) failed, err: %s", key, err)
+	}
+
+	return nil
+}
+
+func routeFromSpec(spec RouteSpec) (*netlink.Route, error) {
+	route := &netlink.Route{
+		Table:    spec.Table,
+		Priority: spec.Priority,
+		Protocol: netlink.RouteProtocol(spec.Protocol),
+	}
+
+	if spec.Gateway != "" {
+		if route.Gw = net.ParseIP(spec.Gateway); route.Gw == nil {
+			return nil, fmt.Errorf("parse gateway (%s) failed", spec.Gateway)
+		}
+	} else {
+		route.Scope = netlink.SCOPE_LINK
+	}
+
+	if spec.Scope != "" {
+		scope, err := parseRouteScope(spec.Scope)
+		if err != nil {
+			return nil, err
+		}
+		route.Scope = scope
+	}
+
+	if spec.Type != "" {
+		routeType, err := parseRouteType(spec.Type)
+		if err != nil {
+			return nil, err
+		}
+		route.Type = routeType
+	}
+
+	if spec.Src != "" {
+		if route.Src = net.ParseIP(spec.Src); route.Src == nil {
+			return nil, fmt.Errorf("parse src (%s) failed", spec.Src)
+		}
+	}
+
+	if spec.Interface != "" {
+		link, err := netlink.LinkByName(spec.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("get link (%s) failed, err: %s", spec.Interface, err)
+		}
+		route.LinkIndex = link.Attrs().Index
+	}
+
+	family, err := routeFamily(spec, route)
+	if err != nil {
+		return nil, err
+	}
+	route.Family = family
+
+	dst := spec.Dst
+	if dst == "" || dst == "default" {
+		dst = "0.0.0.0/0"
+		if family == unix.AF_INET6 {
+			dst = "::/0"
+		}
+	}
+	_, ipNet, err := net.ParseCIDR(dst)
+	if err != nil {
+		return nil, fmt.Errorf("parse route destination (%s) failed, err: %s", spec.Dst, err)
+	}
+	if (ipNet.IP.To4() == nil) != (family == unix.AF_INET6) {
+		return nil, fmt.Errorf("route destination (%s) is not of family (%s)", spec.Dst, familyName(family))
+	}
+	route.Dst = ipNet
+
+	return route, nil
+}
+
+// routeFamily returns the family of spec, derived from the addresses of the route if spec.Family is empty.
+func routeFamily(spec RouteSpec, route *netlink.Route) (int, error) {
+	switch spec.Family {
+	case "inet":
+		return unix.AF_INET, nil
+	case "inet6":
+		return unix.AF_INET6, nil
+	case "":
+	default:
+		return 0, fmt.Errorf("unknown family: (%s)", spec.Family)
+	}
+
+	if spec.Dst != "" && spec.Dst != "default" {
+		ip, _, err := net.ParseCIDR(spec.Dst)
+		if err != nil {
+			return 0, fmt.Errorf("parse route destination (%s) failed, err: %s", spec.Dst, err)
+		}
+		if ip.To4() == nil {
+			return unix.AF_INET6, nil
+		}
+		return unix.AF_INET, nil
+	}
+	for _, ip := range []net.IP{route.Gw, route.Src} {
+		if ip == nil {
+			continue
+		}
+		if ip.To4() == nil {
+			return unix.AF_INET6, nil
+		}
+		return unix.AF_INET, nil
+	}
+	return unix.AF_INET, nil
+}
+
+func parseRouteScope(name string) (netlink.Scope, error) {
+	for _, scope := range []netlink.Scope{netlink.SCOPE_UNIVERSE, netlink.SCOPE_SITE, netlink.SCOPE_LINK, netlink.SCOPE_HOST, netlink.SCOPE_NOWHERE} {
+		if scope.String() == name {
+			return scope, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown route scope: (%s)", name)
+}
+
+func parseRouteType(name string) (int, error) {
+	for routeType := unix.RTN_UNICAST; routeType <= unix.RTN_NAT; routeType++ {
+		if routeTypeName(routeType) == name {
+			return routeType, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown route type: (%s)", name)
+}
+
+// routeSpecFromRoute returns the spec of route, from which routeFromSpec restores the same route.
+func routeSpecFromRoute(route netlink.Route) (*RouteSpec, error) {
+	spec := &RouteSpec{
+		Family:   familyName(route.Family),
+		Dst:      "default",
+		Table:    route.Table,
+		Priority: route.Priority,
+		Scope:    route.Scope.String(),
+		Protocol: int(route.Protocol),
+		Type:     routeTypeName(route.Type),
+	}
+
+	if route.Dst != nil {
+		if ones, _ := route.Dst.Mask.Size(); ones != 0 {
+			spec.Dst = route.Dst.String()
+		}
+	}
+	if route.Gw != nil {
+		spec.Gateway = route.Gw.String()
+	}
+	if route.Src != nil {
+		spec.Src = route.Src.String()
+	}
+	if route.LinkIndex != 0 {
+		link, err := netlink.LinkByIndex(route.LinkIndex)
+		if err != nil {
+			return nil, fmt.Errorf("get link (%d) failed, err: %s", route.LinkIndex, err)
+		}
+		spec.Interface = link.Attrs().Name
+	}
+
+	return spec, nil
+}
+
+// matchRoutes returns the routes of the family of spec matching its non-empty fields, in the main table
+// if spec.Table is 0, and of type unicast if spec.Type is empty.
+func matchRoutes(spec RouteSpec) ([]netlink.Route, error) {
+	filter, err := routeFromSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	if filter.Table == 0 {
+		filter.Table = unix.RT_TABLE_MAIN
+	}
+
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: unix.RT_TABLE_UNSPEC}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("list routes failed, err: %s", err)
+	}
+
+	var matched []netlink.Route
+	for _, route := range routes {
+		if route.Family != filter.Family || route.Table != filter.Table {
+			continue
+		}
+		if filter.Type != 0 && route.Type != filter.Type || filter.Type == 0 && route.Type != unix.RTN_UNICAST {
+			continue
+		}
+
+		dst := route.Dst
+		if dst == nil {
+			dst = &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}
+			if route.Family == unix.AF_INET6 {
+				dst = &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+			}
+		}
+		if dst.String() != filter.Dst.String() {
+			continue
+		}
+
+		if filter.Gw != nil && !filter.Gw.Equal(route.Gw) ||
+			filter.Src != nil && !filter.Src.Equal(route.Src) ||
+			filter.LinkIndex != 0 && filter.LinkIndex != route.LinkIndex ||
+			filter.Priority != 0 && filter.Priority != route.Priority {
+			continue
+		}
+
+		// a default route is listed without a destination, which netlink requires to delete it.
+		route.Dst = dst
+		matched = append(matched, route)
+	}
+
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("no route matches (%+v)", spec)
+	}
+
+	return matched, nil
+}
+
+func ruleFamily(spec RuleSpec) (int, error) {
+	switch spec.Family {
+	case "inet":
+		return unix.AF_INET, nil
+	case "inet6":
+		return unix.AF_INET6, nil
+	case "":
+		for _, s := range []string{spec.Src, spec.Dst} {
+			if s == "" {
+				continue
+			}
+			ip, _, err := net.ParseCIDR(s)
+			if err != nil {
+				return 0, fmt.Errorf("parse (%s) failed, err: %s", s, err)
+			}
+			if ip.To4() == nil {
+				return unix.AF_INET6, nil
+			}
+			return unix.AF_INET, nil
+		}
+		return unix.AF_INET, nil
+	default:
+		return 0, fmt.Errorf("unknown family: (%s)", spec.Family)
+	}
+}
+
+func ruleFromSpec(spec RuleSpec) (*netlink.Rule, error) {
+	family, err := ruleFamily(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := netlink.NewRule()
+	rule.Family = family
+	rule.Table = spec.Table
+	rule.IifName = spec.IifName
+	rule.OifName = spec.OifName
+	rule.Invert = spec.Invert
+
+	if spec.Priority != nil {
+		rule.Priority = *spec.Priority
+	}
+	if spec.Mark != 0 {
+		rule.Mark = spec.Mark
+	}
+	if spec.Mask != 0 {
+		rule.Mask = spec.Mask
+	}
+	if spec.Src != "" {
+		if _, rule.Src, err = net.ParseCIDR(spec.Src); err != nil {
+			return nil, fmt.Errorf("parse rule src (%s) failed, err: %s", spec.Src, err)
+		}
+	}
+	if spec.Dst != "" {
+		if _, rule.Dst, err = net.ParseCIDR(spec.Dst); err != nil {
+			return nil, fmt.Errorf("parse rule dst (%s) failed, err: %s", spec.Dst, err)
+		}
+	}
+
+	return rule, nil
+}
+
+func ruleSpecFromRule(rule netlink.Rule) *RuleSpec {
+	r := ruleState(rule)
+	// the kernel does not send the priority 0, which netlink reports as -1.
+	if r.Priority < 0 {
+		r.Priority = 0
+	}
+	return &RuleSpec{
+		Family:   r.Family,
+		Priority: &r.Priority,
+		Table:    r.Table,
+		Src:      r.Src,
+		Dst:      r.Dst,
+		IifName:  r.IifName,
+		OifName:  r.OifName,
+		Mark:     r.Mark,
+		Mask:     r.Mask,
+		Invert:   r.Invert,
+	}
+}
+
+// defaultRules are the priorities and tables of the rules the kernel creates in each network namespace.
+var defaultRules = map[int]int{
+	0:     unix.RT_TABLE_LOCAL,
+	32766: unix.RT_TABLE_MAIN,
+	32767: unix.RT_TABLE_DEFAULT,
+}
+
+// isDefaultRule tells if the rule is one of the rules the kernel creates in each network namespace.
+func isDefaultRule(r *RuleSpec) bool {
+	table, ok := defaultRules[*r.Priority]
+	return ok && r.Table == table && r.Src == "" && r.Dst == "" && r.IifName == "" && r.OifName == "" &&
+		r.Mark == 0 && !r.Invert
+}
+
+// matchRules returns the policy rules matching the non-empty fields of spec, which must have a selector.
+// The default rules of the kernel are matched only if the priority of spec is set.
+func matchRules(spec RuleSpec) ([]netlink.Rule, error) {
+	if spec.Priority == nil && spec.Table == 0 && spec.Src == "" && spec.Dst == "" &&
+		spec.IifName == "" && spec.OifName == "" && spec.Mark == 0 {
+		return nil, fmt.Errorf("rule has no selector, one of priority, table, src, dst, iif, oif and mark is required")
+	}
+
+	family, err := ruleFamily(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := netlink.RuleList(family)
+	if err != nil {
+		return nil, fmt.Errorf("list rules failed, err: %s", err)
+	}
+
+	var matched []netlink.Rule
+	for _, rule := range rules {
+		// netlink does not report the family of the listed rules.
+		rule.Family = family
+		r := ruleSpecFromRule(rule)
+		if spec.Priority == nil && isDefaultRule(r) ||
+			spec.Priority != nil && *spec.Priority != *r.Priority ||
+			spec.Table != 0 && spec.Table != r.Table ||
+			spec.Src != "" && spec.Src != r.Src ||
+			spec.Dst != "" && spec.Dst != r.Dst ||
+			spec.IifName != "" && spec.IifName != r.IifName ||
+			spec.OifName != "" && spec.OifName != r.OifName ||
+			spec.Mark != 0 && spec.Mark != r.Mark ||
+			spec.Mask != 0 && spec.Mask != r.Mask ||
+			spec.Invert != r.Invert {
+			continue
+		}
+		matched = append(matched, rule)
+	}
+
+	if len(matched) == 0 {
+		b, _ := json.Marshal(spec)
+		return nil, fmt.Errorf("no rule matches (%s)", b)
+	}
+
+	return matched, nil
+}
@@ -0,0 +1,228 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+func Test_applyNetworkOps(t *testing.T) {
+	requireRoot(t)
+
+	ctx := context.Background()
+	netnsPath := newTestNetNS(t, "gcu-test-netcfg")
+
+	ns, err := netns.GetFromPath(netnsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ns.Close()
+	handle, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handle.Delete()
+
+	if err := handle.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}, PeerName: "eth1"}); err != nil {
+		t.Fatal(err)
+	}
+	link, err := handle.LinkByName("eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handle.LinkSetUp(link); err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := netlink.ParseAddr("10.10.0.2/24")
+	if err := handle.AddrAdd(link, addr); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := networkStateInNetNS(ctx, netnsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forwarding, err := readSysctlInNetNS(ctx, netnsPath, "net.ipv4.ip_forward")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	priority := 1000
+	undos, err := applyNetworkOps(ctx, netnsPath, []NetworkOp{
+		{Kind: NetworkOpAddAddress, Interface: "eth0", Address: "10.20.0.2/24"},
+		{Kind: NetworkOpDelAddress, Interface: "eth0", Address: "10.10.0.2/24"},
+		{Kind: NetworkOpAddRoute, Route: &RouteSpec{Dst: "default", Gateway: "10.20.0.1"}},
+		{Kind: NetworkOpAddRoute, Route: &RouteSpec{Dst: "172.16.0.0/16", Interface: "eth0", Table: 100}},
+		{Kind: NetworkOpAddRule, Rule: &RuleSpec{Priority: &priority, Src: "10.20.0.0/24", Table: 100}},
+		{Kind: NetworkOpSetMTU, Interface: "eth0", MTU: 1400},
+		{Kind: NetworkOpSetSysctl, Sysctl: "net.ipv4.ip_forward", Value: "1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(undos) != 7 || undos[0].Kind != NetworkOpSetSysctl || undos[0].Value != forwarding {
+		t.Fatalf("unexpected undo records: %+v", undos)
+	}
+
+	if v, err := readSysctlInNetNS(ctx, netnsPath, "net.ipv4.ip_forward"); err != nil || v != "1" {
+		t.Errorf("unexpected ip_forward: %s, err: %v", v, err)
+	}
+	if _, err := readSysctlInNetNS(ctx, netnsPath, "kernel.hostname"); err == nil {
+		t.Errorf("expected an error for a sysctl outside net")
+	}
+
+	// the undo records survive serialization
+	b, err := json.Marshal(undos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []NetworkOp
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	redos, err := applyNetworkOps(ctx, netnsPath, decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(redos) != len(undos) {
+		t.Errorf("unexpected redo records: %+v", redos)
+	}
+
+	after, err := networkStateInNetNS(ctx, netnsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range after.Interfaces {
+		after.Interfaces[i].Stats = nil
+		before.Interfaces[i].Stats = nil
+	}
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("state not restored\nbefore: %+v\nafter:  %+v", before, after)
+	}
+	if v, err := readSysctlInNetNS(ctx, netnsPath, "net.ipv4.ip_forward"); err != nil || v != forwarding {
+		t.Errorf("ip_forward not restored: %s, err: %v", v, err)
+	}
+
+	// a failing op returns the undo records of the ops applied before it
+	undos, err = applyNetworkOps(ctx, netnsPath, []NetworkOp{
+		{Kind: NetworkOpSetMTU, Interface: "eth0", MTU: 1300},
+		{Kind: NetworkOpDelRoute, Route: &RouteSpec{Dst: "192.0.2.0/24"}},
+	})
+	if err == nil {
+		t.Fatalf("expected an error for deleting a missing route")
+	}
+	if len(undos) != 1 || undos[0].MTU != 1500 {
+		t.Errorf("unexpected undo records: %+v", undos)
+	}
+
+	// a rule without selector, or only matching the default rules of the kernel by their table, is not deleted
+	for _, spec := range []RuleSpec{{}, {Family: "inet6"}, {Table: unix.RT_TABLE_MAIN}, {Table: unix.RT_TABLE_LOCAL}} {
+		if _, err := applyNetworkOps(ctx, netnsPath, []NetworkOp{{Kind: NetworkOpDelRule, Rule: &spec}}); err == nil {
+			t.Errorf("rule (%+v) deleted", spec)
+		}
+	}
+
+	// the local rule, of priority 0, is deleted when its priority is named
+	local := 0
+	undos, err = applyNetworkOps(ctx, netnsPath, []NetworkOp{{Kind: NetworkOpDelRule, Rule: &RuleSpec{Priority: &local}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(undos) != 1 || *undos[0].Rule.Priority != 0 || undos[0].Rule.Table != unix.RT_TABLE_LOCAL {
+		t.Errorf("unexpected undo records: %+v", undos)
+	}
+	if _, err := applyNetworkOps(ctx, netnsPath, undos); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := handle.RuleList(netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != len(defaultRules) {
+		t.Errorf("unexpected rules after restoring the local rule: %+v", rules)
+	}
+}
+
+func Test_applyNetworkOps_routeRoundTrip(t *testing.T) {
+	requireRoot(t)
+
+	ctx := context.Background()
+	netnsPath := newTestNetNS(t, "gcu-test-netcfg6")
+
+	ns, err := netns.GetFromPath(netnsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ns.Close()
+	handle, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handle.Delete()
+
+	if err := handle.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}, PeerName: "eth1"}); err != nil {
+		t.Fatal(err)
+	}
+	link, err := handle.LinkByName("eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handle.LinkSetUp(link); err != nil {
+		t.Fatal(err)
+	}
+
+	// a dev-only ipv6 default route and an ipv4 route of another protocol and scope.
+	_, v6Default, _ := net.ParseCIDR("::/0")
+	_, v4Dst, _ := net.ParseCIDR("198.51.100.0/24")
+	for _, route := range []*netlink.Route{
+		{LinkIndex: link.Attrs().Index, Dst: v6Default, Protocol: unix.RTPROT_STATIC},
+		{LinkIndex: link.Attrs().Index, Dst: v4Dst, Protocol: unix.RTPROT_DHCP, Scope: netlink.SCOPE_HOST},
+	} {
+		if err := handle.RouteAdd(route); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before, err := networkStateInNetNS(ctx, netnsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	undos, err := applyNetworkOps(ctx, netnsPath, []NetworkOp{
+		{Kind: NetworkOpDelRoute, Route: &RouteSpec{Family: "inet6", Dst: "default", Interface: "eth0"}},
+		{Kind: NetworkOpDelRoute, Route: &RouteSpec{Dst: "198.51.100.0/24"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(undos) != 2 || undos[1].Route.Family != "inet6" || undos[1].Route.Protocol != unix.RTPROT_STATIC {
+		t.Fatalf("unexpected undo records: %+v", undos)
+	}
+
+	b, err := json.Marshal(undos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []NetworkOp
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := applyNetworkOps(ctx, netnsPath, decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := networkStateInNetNS(ctx, netnsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(before.Routes, after.Routes) {
+		t.Errorf("routes not restored\nbefore: %+v\nafter:  %+v", before.Routes, after.Routes)
+	}
+}
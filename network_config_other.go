@@ -0,0 +1,15 @@
+//go:build !linux
+
+package container
+
+import (
+	"context"
+)
+
+func applyNetworkOps(ctx context.Context, netnsPath string, ops []NetworkOp) ([]NetworkOp, error) {
+	return nil, ErrNotImplemented
+}
+
+func readSysctlInNetNS(ctx context.Context, netnsPath string, key string) (string, error) {
+	return "", ErrNotImplemented
+}
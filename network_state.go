@@ -0,0 +1,117 @@
+package container
+
+// NetworkState is the network configuration and state inside the network namespace of a container,
+// like what `ip addr`, `ip route show table all`, `ip rule` and `ip neigh` print inside the container.
+type NetworkState struct {
+	Interfaces []InterfaceState `json:"interfaces"`
+	Routes     []RouteState     `json:"routes"`
+	Rules      []RuleState      `json:"rules"`
+	Neighbors  []NeighborState  `json:"neighbors"`
+}
+
+type InterfaceState struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+
+	// Type is the link type, eg: veth, macvlan, ipvlan, device
+	Type string `json:"type"`
+
+	MTU          int    `json:"mtu"`
+	HardwareAddr string `json:"hardwareAddr,omitempty"`
+
+	// OperState is the RFC2863 operational state, eg: up, down, unknown
+	OperState string `json:"operState"`
+
+	// Flags are the interface flags, eg: up, broadcast, multicast
+	Flags []string `json:"flags"`
+
+	// ParentIndex is the index of the peer of a veth, or the parent of a macvlan/ipvlan.
+	// It may refer to an interface in another network namespace, see LinkNetNSID.
+	ParentIndex int `json:"parentIndex,omitempty"`
+
+	// LinkNetNSID is the id of the network namespace of the parent interface as assigned
+	// inside the container network namespace, -1 means the same network namespace.
+	LinkNetNSID int `json:"linkNetnsid"`
+
+	MasterIndex int `json:"masterIndex,omitempty"`
+
+	Addresses []AddressState  `json:"addresses"`
+	Stats     *InterfaceStats `json:"stats,omitempty"`
+}
+
+type AddressState struct {
+	// Family is either "inet" or "inet6"
+	Family    string `json:"family"`
+	IP        string `json:"ip"`
+	PrefixLen int    `json:"prefixLen"`
+
+	// Scope is the address scope, eg: universe, link, host
+	Scope     string `json:"scope"`
+	Label     string `json:"label,omitempty"`
+	Broadcast string `json:"broadcast,omitempty"`
+	Peer      string `json:"peer,omitempty"`
+	Secondary bool   `json:"secondary,omitempty"`
+}
+
+type InterfaceStats struct {
+	RxBytes    uint64 `json:"rxBytes"`
+	RxPackets  uint64 `json:"rxPackets"`
+	RxErrors   uint64 `json:"rxErrors"`
+	RxDropped  uint64 `json:"rxDropped"`
+	TxBytes    uint64 `json:"txBytes"`
+	TxPackets  uint64 `json:"txPackets"`
+	TxErrors   uint64 `json:"txErrors"`
+	TxDropped  uint64 `json:"txDropped"`
+	Multicast  uint64 `json:"multicast"`
+	Collisions uint64 `json:"collisions"`
+}
+
+type RouteState struct {
+	Family string `json:"family"`
+	Table  int    `json:"table"`
+
+	// Type is the route type, eg: unicast, local, broadcast, blackhole
+	Type string `json:"type"`
+
+	// Dst is the destination in CIDR notation, or "default"
+	Dst       string `json:"dst"`
+	Src       string `json:"src,omitempty"`
+	Gateway   string `json:"gateway,omitempty"`
+	Interface string `json:"interface,omitempty"`
+	Protocol  string `json:"protocol"`
+	Scope     string `json:"scope"`
+	Priority  int    `json:"priority,omitempty"`
+	MTU       int    `json:"mtu,omitempty"`
+
+	NextHops []RouteNextHop `json:"nextHops,omitempty"`
+}
+
+type RouteNextHop struct {
+	Gateway   string `json:"gateway,omitempty"`
+	Interface string `json:"interface,omitempty"`
+	Weight    int    `json:"weight"`
+}
+
+type RuleState struct {
+	Family   string `json:"family"`
+	Priority int    `json:"priority"`
+	Table    int    `json:"table"`
+	Src      string `json:"src,omitempty"`
+	Dst      string `json:"dst,omitempty"`
+	IifName  string `json:"iif,omitempty"`
+	OifName  string `json:"oif,omitempty"`
+	Mark     int    `json:"mark,omitempty"`
+	Mask     int    `json:"mask,omitempty"`
+	Invert   bool   `json:"invert,omitempty"`
+}
+
+type NeighborState struct {
+	Family       string `json:"family"`
+	IP           string `json:"ip"`
+	HardwareAddr string `json:"hardwareAddr,omitempty"`
+	Interface    string `json:"interface"`
+
+	// State is the neighbor state, eg: reachable, stale, permanent, failed
+	State  string `json:"state"`
+	Router bool   `json:"router,omitempty"`
+}
@@ -0,0 +1,288 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// networkStateInNetNS collects the network state inside the network namespace at netnsPath,
+// entering the namespace only once.
+func networkStateInNetNS(ctx context.Context, netnsPath string) (*NetworkState, error) {
+	state := &NetworkState{
+		Interfaces: []InterfaceState{},
+		Routes:     []RouteState{},
+		Rules:      []RuleState{},
+		Neighbors:  []NeighborState{},
+	}
+
+	err := doInNamespaces(ctx, map[NamespaceType]string{NamespaceNet: netnsPath}, func() error {
+		links, err := netlink.LinkList()
+		if err != nil {
+			return fmt.Errorf("list links failed, err: %s", err)
+		}
+
+		linkNames := map[int]string{}
+		for _, link := range links {
+			linkNames[link.Attrs().Index] = link.Attrs().Name
+		}
+
+		for _, link := range links {
+			intf, err := interfaceState(link)
+			if err != nil {
+				return err
+			}
+			state.Interfaces = append(state.Interfaces, intf)
+		}
+
+		routes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: unix.RT_TABLE_UNSPEC}, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			return fmt.Errorf("list routes failed, err: %s", err)
+		}
+		for _, route := range routes {
+			state.Routes = append(state.Routes, routeState(route, linkNames))
+		}
+
+		rules, err := netlink.RuleList(netlink.FAMILY_ALL)
+		if err != nil {
+			return fmt.Errorf("list rules failed, err: %s", err)
+		}
+		for _, rule := range rules {
+			state.Rules = append(state.Rules, ruleState(rule))
+		}
+
+		neighs, err := netlink.NeighList(0, netlink.FAMILY_ALL)
+		if err != nil {
+			return fmt.Errorf("list neighbors failed, err: %s", err)
+		}
+		for _, neigh := range neighs {
+			state.Neighbors = append(state.Neighbors, neighborState(neigh, linkNames))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed inside ns, err: %w", err)
+	}
+
+	return state, nil
+}
+
+func interfaceState(link netlink.Link) (InterfaceState, error) {
+	attrs := link.Attrs()
+
+	intf := InterfaceState{
+		Index:       attrs.Index,
+		Name:        attrs.Name,
+		Type:        link.Type(),
+		MTU:         attrs.MTU,
+		OperState:   attrs.OperState.String(),
+		Flags:       []string{},
+		ParentIndex: attrs.ParentIndex,
+		LinkNetNSID: attrs.NetNsID,
+		MasterIndex: attrs.MasterIndex,
+		Addresses:   []AddressState{},
+	}
+
+	if len(attrs.HardwareAddr) != 0 {
+		intf.HardwareAddr = attrs.HardwareAddr.String()
+	}
+
+	if attrs.Flags != 0 {
+		intf.Flags = strings.Split(attrs.Flags.String(), "|")
+	}
+
+	if stats := attrs.Statistics; stats != nil {
+		intf.Stats = &InterfaceStats{
+			RxBytes:    stats.RxBytes,
+			RxPackets:  stats.RxPackets,
+			RxErrors:   stats.RxErrors,
+			RxDropped:  stats.RxDropped,
+			TxBytes:    stats.TxBytes,
+			TxPackets:  stats.TxPackets,
+			TxErrors:   stats.TxErrors,
+			TxDropped:  stats.TxDropped,
+			Multicast:  stats.Multicast,
+			Collisions: stats.Collisions,
+		}
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return intf, fmt.Errorf("list addresses of (%s) failed, err: %s", attrs.Name, err)
+	}
+
+	for _, addr := range addrs {
+		ones, _ := addr.Mask.Size()
+		address := AddressState{
+			Family:    ipFamilyName(addr.IP),
+			IP:        addr.IP.String(),
+			PrefixLen: ones,
+			Scope:     netlink.Scope(addr.Scope).String(),
+			Label:     addr.Label,
+			Secondary: addr.Flags&unix.IFA_F_SECONDARY != 0,
+		}
+		if addr.Broadcast != nil {
+			address.Broadcast = addr.Broadcast.String()
+		}
+		if addr.Peer != nil {
+			address.Peer = addr.Peer.String()
+		}
+		intf.Addresses = append(intf.Addresses, address)
+	}
+
+	return intf, nil
+}
+
+func routeState(route netlink.Route, linkNames map[int]string) RouteState {
+	r := RouteState{
+		Family:    familyName(route.Family),
+		Table:     route.Table,
+		Type:      routeTypeName(route.Type),
+		Dst:       "default",
+		Interface: linkNames[route.LinkIndex],
+		Protocol:  route.Protocol.String(),
+		Scope:     route.Scope.String(),
+		Priority:  route.Priority,
+		MTU:       route.MTU,
+	}
+
+	if route.Dst != nil {
+		r.Dst = route.Dst.String()
+	}
+	if route.Src != nil {
+		r.Src = route.Src.String()
+	}
+	if route.Gw != nil {
+		r.Gateway = route.Gw.String()
+	}
+
+	for _, nh := range route.MultiPath {
+		nextHop := RouteNextHop{
+			Interface: linkNames[nh.LinkIndex],
+			Weight:    nh.Hops + 1,
+		}
+		if nh.Gw != nil {
+			nextHop.Gateway = nh.Gw.String()
+		}
+		r.NextHops = append(r.NextHops, nextHop)
+	}
+
+	return r
+}
+
+func ruleState(rule netlink.Rule) RuleState {
+	r := RuleState{
+		Family:   familyName(rule.Family),
+		Priority: rule.Priority,
+		Table:    rule.Table,
+		IifName:  rule.IifName,
+		OifName:  rule.OifName,
+		Invert:   rule.Invert,
+	}
+
+	// netlink reports unset mark and mask as -1
+	if rule.Mark > 0 {
+		r.Mark = rule.Mark
+	}
+	if rule.Mask > 0 {
+		r.Mask = rule.Mask
+	}
+	if rule.Src != nil {
+		r.Src = rule.Src.String()
+	}
+	if rule.Dst != nil {
+		r.Dst = rule.Dst.String()
+	}
+
+	return r
+}
+
+func neighborState(neigh netlink.Neigh, linkNames map[int]string) NeighborState {
+	n := NeighborState{
+		Family:    familyName(neigh.Family),
+		IP:        neigh.IP.String(),
+		Interface: linkNames[neigh.LinkIndex],
+		State:     neighborStateName(neigh.State),
+		Router:    neigh.Flags&netlink.NTF_ROUTER != 0,
+	}
+	if len(neigh.HardwareAddr) != 0 {
+		n.HardwareAddr = neigh.HardwareAddr.String()
+	}
+
+	return n
+}
+
+func familyName(family int) string {
+	switch family {
+	case unix.AF_INET:
+		return "inet"
+	case unix.AF_INET6:
+		return "inet6"
+	default:
+		return fmt.Sprintf("%d", family)
+	}
+}
+
+func ipFamilyName(ip net.IP) string {
+	if ip.To4() != nil {
+		return "inet"
+	}
+	return "inet6"
+}
+
+func routeTypeName(routeType int) string {
+	switch routeType {
+	case unix.RTN_UNICAST:
+		return "unicast"
+	case unix.RTN_LOCAL:
+		return "local"
+	case unix.RTN_BROADCAST:
+		return "broadcast"
+	case unix.RTN_ANYCAST:
+		return "anycast"
+	case unix.RTN_MULTICAST:
+		return "multicast"
+	case unix.RTN_BLACKHOLE:
+		return "blackhole"
+	case unix.RTN_UNREACHABLE:
+		return "unreachable"
+	case unix.RTN_PROHIBIT:
+		return "prohibit"
+	case unix.RTN_THROW:
+		return "throw"
+	case unix.RTN_NAT:
+		return "nat"
+	default:
+		return fmt.Sprintf("%d", routeType)
+	}
+}
+
+func neighborStateName(state int) string {
+	switch state {
+	case netlink.NUD_INCOMPLETE:
+		return "incomplete"
+	case netlink.NUD_REACHABLE:
+		return "reachable"
+	case netlink.NUD_STALE:
+		return "stale"
+	case netlink.NUD_DELAY:
+		return "delay"
+	case netlink.NUD_PROBE:
+		return "probe"
+	case netlink.NUD_FAILED:
+		return "failed"
+	case netlink.NUD_NOARP:
+		return "noarp"
+	case netlink.NUD_PERMANENT:
+		return "permanent"
+	case netlink.NUD_NONE:
+		return "none"
+	default:
+		return fmt.Sprintf("0x%x", state)
+	}
+}
@@ -0,0 +1,74 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func Test_networkStateInNetNS(t *testing.T) {
+	requireRoot(t)
+
+	netnsPath := newTestNetNS(t, "gcu-test-state")
+
+	err := doInNamespaces(context.Background(), map[NamespaceType]string{NamespaceNet: netnsPath}, func() error {
+		veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth0", MTU: 1400}, PeerName: "veth1"}
+		if err := netlink.LinkAdd(veth); err != nil {
+			return err
+		}
+		addr, _ := netlink.ParseAddr("10.10.0.2/24")
+		if err := netlink.AddrAdd(veth, addr); err != nil {
+			return err
+		}
+		for _, name := range []string{"veth0", "veth1"} {
+			if err := netlink.LinkSetUp(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := networkStateInNetNS(context.Background(), netnsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var veth *InterfaceState
+	for i := range state.Interfaces {
+		if state.Interfaces[i].Name == "veth0" {
+			veth = &state.Interfaces[i]
+		}
+	}
+	if veth == nil {
+		t.Fatalf("veth0 not found in %+v", state.Interfaces)
+	}
+	if veth.Type != "veth" || veth.MTU != 1400 {
+		t.Errorf("unexpected interface: %+v", veth)
+	}
+	if len(veth.Addresses) == 0 || veth.Addresses[0].IP != "10.10.0.2" || veth.Addresses[0].PrefixLen != 24 {
+		t.Errorf("unexpected addresses: %+v", veth.Addresses)
+	}
+
+	var foundRoute bool
+	for _, route := range state.Routes {
+		if route.Dst == "10.10.0.0/24" && route.Interface == "veth0" {
+			foundRoute = true
+		}
+	}
+	if !foundRoute {
+		t.Errorf("connected route not found in %+v", state.Routes)
+	}
+
+	if len(state.Rules) == 0 {
+		t.Errorf("expected the default policy rules")
+	}
+
+	if _, err := json.Marshal(state); err != nil {
+		t.Errorf("marshal network state failed, err: %s", err)
+	}
+}
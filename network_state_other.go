@@ -0,0 +1,11 @@
+//go:build !linux
+
+package container
+
+import (
+	"context"
+)
+
+func networkStateInNetNS(ctx context.Context, netnsPath string) (*NetworkState, error) {
+	return nil, ErrNotImplemented
+}
@@ -0,0 +1,11 @@
+// Package nsenter joins the namespaces requested by DoReexec of the container package
+// before the go runtime starts, like the nsenter package of runc does.
+//
+// The user and mount namespaces can only be joined by a single-threaded process,
+// so programs calling DoReexec must import this package for its side effect:
+//
+//	import _ "github.com/bougou/go-container-utils/nsenter"
+//
+// It requires cgo, without it the re-executed helpers fail instead of running
+// in the wrong namespaces.
+package nsenter
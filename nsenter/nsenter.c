@@ -0,0 +1,101 @@
+//go:build linux && cgo
+
+#define _GNU_SOURCE
+#include <errno.h>
+#include <fcntl.h>
+#include <sched.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <unistd.h>
+
+#define NSENTER_ENV "_CONTAINER_UTILS_NSENTER"
+#define MAX_NAMESPACES 16
+
+struct namespace {
+	const char *type;
+	int flag;
+	int fd;
+};
+
+static int namespace_flag(const char *type)
+{
+	if (strcmp(type, "user") == 0)
+		return CLONE_NEWUSER;
+	if (strcmp(type, "mnt") == 0)
+		return CLONE_NEWNS;
+	if (strcmp(type, "net") == 0)
+		return CLONE_NEWNET;
+	if (strcmp(type, "uts") == 0)
+		return CLONE_NEWUTS;
+	if (strcmp(type, "ipc") == 0)
+		return CLONE_NEWIPC;
+	if (strcmp(type, "pid") == 0)
+		return CLONE_NEWPID;
+	if (strcmp(type, "cgroup") == 0)
+		return CLONE_NEWCGROUP;
+	return -1;
+}
+
+static void bail(const char *msg, const char *arg)
+{
+	fprintf(stderr, "nsenter: %s (%s): %s\n", msg, arg, strerror(errno));
+	_exit(1);
+}
+
+/*
+ * nsenter joins the namespaces listed in NSENTER_ENV, eg: "user:/proc/1234/ns/user,mnt:/proc/1234/ns/mnt".
+ * It runs before the go runtime starts, while the process is still single-threaded, which is
+ * required to join user and mount namespaces. All namespace files are opened before any of them
+ * is joined, because the paths may not be resolvable anymore once the mount namespace is joined.
+ */
+void nsenter(void)
+{
+	struct namespace namespaces[MAX_NAMESPACES];
+	int n = 0;
+	char *spec, *item, *saveptr;
+	const char *env;
+
+	env = getenv(NSENTER_ENV);
+	if (env == NULL || *env == '\0')
+		return;
+
+	spec = strdup(env);
+	if (spec == NULL)
+		bail("copy namespace spec", env);
+
+	for (item = strtok_r(spec, ",", &saveptr); item != NULL; item = strtok_r(NULL, ",", &saveptr)) {
+		char *path = strchr(item, ':');
+
+		if (path == NULL) {
+			errno = EINVAL;
+			bail("parse namespace spec", item);
+		}
+		*path++ = '\0';
+
+		if (n == MAX_NAMESPACES) {
+			errno = E2BIG;
+			bail("parse namespace spec", env);
+		}
+
+		namespaces[n].type = item;
+		namespaces[n].flag = namespace_flag(item);
+		if (namespaces[n].flag < 0) {
+			errno = EINVAL;
+			bail("unknown namespace type", item);
+		}
+
+		namespaces[n].fd = open(path, O_RDONLY | O_CLOEXEC);
+		if (namespaces[n].fd < 0)
+			bail("open namespace", path);
+		n++;
+	}
+
+	for (int i = 0; i < n; i++) {
+		if (setns(namespaces[i].fd, namespaces[i].flag) < 0)
+			bail("join namespace", namespaces[i].type);
+		close(namespaces[i].fd);
+	}
+
+	free(spec);
+}
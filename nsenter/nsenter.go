@@ -0,0 +1,12 @@
+//go:build linux && cgo
+
+package nsenter
+
+/*
+#cgo CFLAGS: -Wall
+extern void nsenter();
+static void __attribute__((constructor)) nsenter_init(void) {
+	nsenter();
+}
+*/
+import "C"
@@ -18,9 +18,52 @@ type OverlayFS struct {
 	upperDir  string
 	mergedDir string
 
+	opts OverlayOptions
+
 	m *mount.Mount
 }
 
+// OverlayToggle is the value of an overlay mount option which is either on or off.
+// The empty value leaves the option to the kernel default.
+type OverlayToggle string
+
+const (
+	OverlayOn  OverlayToggle = "on"
+	OverlayOff OverlayToggle = "off"
+)
+
+// OverlayOptions are the dirs and the mount options of an overlay filesystem,
+// see https://docs.kernel.org/filesystems/overlayfs.html for the meaning of the options.
+type OverlayOptions struct {
+	// LowerDir are the lower dirs separated by ":", the uppermost first, as returned by GetOverlayDirs.
+	LowerDir  string
+	UpperDir  string
+	MergedDir string
+
+	// WorkDir is the work dir, which must be on the same filesystem as UpperDir.
+	// Defaults to the "work" dir next to UpperDir.
+	WorkDir string
+
+	Index    OverlayToggle
+	Metacopy OverlayToggle
+
+	// RedirectDir is one of "on", "follow", "nofollow" and "off".
+	RedirectDir string
+
+	// Xino is one of "on", "off" and "auto".
+	Xino string
+
+	// Volatile skips the syncs of the upper dir, it is ignored for readonly mounts.
+	Volatile bool
+
+	// UserXattr stores the overlay attributes in the "user.overlay." xattrs
+	// instead of the "trusted.overlay." ones.
+	UserXattr bool
+
+	// ExtraOptions are appended as is to the mount options, eg: "nodev", "nosuid".
+	ExtraOptions []string
+}
+
 func NewOverlayFS(lowerDir, upperDir, mergedDir string) *OverlayFS {
 	return &OverlayFS{
 		lowerDir:  lowerDir,
@@ -29,7 +72,154 @@ func NewOverlayFS(lowerDir, upperDir, mergedDir string) *OverlayFS {
 	}
 }
 
+// NewOverlayFSWithOptions returns an OverlayFS with the given dirs and mount options.
+// The values of the options are validated, whether the running kernel supports them is checked by Mount.
+func NewOverlayFSWithOptions(opts OverlayOptions) (*OverlayFS, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	return &OverlayFS{
+		lowerDir:  opts.LowerDir,
+		upperDir:  opts.UpperDir,
+		mergedDir: opts.MergedDir,
+		opts:      opts,
+	}, nil
+}
+
+func (opts OverlayOptions) validate() error {
+	for name, value := range map[string]OverlayToggle{"index": opts.Index, "metacopy": opts.Metacopy} {
+		if value != "" && value != OverlayOn && value != OverlayOff {
+			return fmt.Errorf("invalid overlay option %s=%s", name, value)
+		}
+	}
+
+	switch opts.RedirectDir {
+	case "", "on", "follow", "nofollow", "off":
+	default:
+		return fmt.Errorf("invalid overlay option redirect_dir=%s", opts.RedirectDir)
+	}
+
+	switch opts.Xino {
+	case "", "on", "off", "auto":
+	default:
+		return fmt.Errorf("invalid overlay option xino=%s", opts.Xino)
+	}
+
+	for _, option := range opts.ExtraOptions {
+		key, _, _ := strings.Cut(option, "=")
+		switch key {
+		case "lowerdir", "upperdir", "workdir":
+			return fmt.Errorf("overlay option %s must not be passed as an extra option", key)
+		}
+	}
+
+	return nil
+}
+
+// featureOptions returns the mount options for the features of opts.
+func (opts OverlayOptions) featureOptions(readOnly bool) []string {
+	var options []string
+
+	if opts.Index != "" {
+		options = append(options, fmt.Sprintf("index=%s", opts.Index))
+	}
+	if opts.Metacopy != "" {
+		options = append(options, fmt.Sprintf("metacopy=%s", opts.Metacopy))
+	}
+	if opts.RedirectDir != "" {
+		options = append(options, fmt.Sprintf("redirect_dir=%s", opts.RedirectDir))
+	}
+	if opts.Xino != "" {
+		options = append(options, fmt.Sprintf("xino=%s", opts.Xino))
+	}
+	if opts.Volatile && !readOnly {
+		options = append(options, "volatile")
+	}
+	if opts.UserXattr {
+		options = append(options, "userxattr")
+	}
+
+	return append(options, opts.ExtraOptions...)
+}
+
+// overlayModuleParameters are the parameters of the overlay module under /sys/module/overlay/parameters
+// for the options of OverlayOptions. The kernel does not know the option if the parameter is missing.
+// volatile and userxattr have no module parameter, and are only checked by the probe mount.
+var overlayModuleParameters = map[string]string{
+	"index":        "index",
+	"metacopy":     "metacopy",
+	"redirect_dir": "redirect_dir",
+	"xino":         "xino_auto",
+}
+
+// overlayModuleParametersDir is a variable for the tests.
+var overlayModuleParametersDir = "/sys/module/overlay/parameters"
+
+// CheckSupport checks that the running kernel supports the mount options of the overlay filesystem,
+// first with the parameters of the overlay module, then with a probe mount of empty dirs created
+// next to the upper dir, or in the temp dir for a mount without upper dir.
+func (fs *OverlayFS) CheckSupport() error {
+	options := fs.opts.featureOptions(false)
+	if len(options) == 0 {
+		return nil
+	}
+
+	// the parameters are missing as well if the module is not loaded yet, the probe mount loads it.
+	if _, err := os.Stat(overlayModuleParametersDir); err == nil {
+		for _, option := range options {
+			key, _, _ := strings.Cut(option, "=")
+			param, ok := overlayModuleParameters[key]
+			if !ok {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(overlayModuleParametersDir, param)); err != nil {
+				return fmt.Errorf("overlay option %s is not supported by the kernel", key)
+			}
+		}
+	}
+
+	probeParent := os.TempDir()
+	if fs.upperDir != "" {
+		probeParent = filepath.Dir(fs.upperDir)
+	}
+	probeDir, err := os.MkdirTemp(probeParent, ".overlay-probe-")
+	if err != nil {
+		return fmt.Errorf("create overlay probe dir failed, err: %s", err)
+	}
+	defer os.RemoveAll(probeDir)
+
+	for _, dir := range []string{"lower", "upper", "work", "merged"} {
+		if err := os.Mkdir(filepath.Join(probeDir, dir), 0711); err != nil {
+			return fmt.Errorf("create overlay probe dir failed, err: %s", err)
+		}
+	}
+
+	probe := mount.Mount{
+		Type:   "overlay",
+		Source: "overlay",
+		Options: append([]string{
+			fmt.Sprintf("lowerdir=%s", filepath.Join(probeDir, "lower")),
+			fmt.Sprintf("upperdir=%s", filepath.Join(probeDir, "upper")),
+			fmt.Sprintf("workdir=%s", filepath.Join(probeDir, "work")),
+		}, options...),
+	}
+	target := filepath.Join(probeDir, "merged")
+	if err := probe.Mount(target); err != nil {
+		return fmt.Errorf("probe overlay mount with options (%s) failed, err: %s", strings.Join(options, ","), err)
+	}
+	if err := mount.UnmountAll(target, 0); err != nil {
+		return fmt.Errorf("unmount overlay probe mount failed, err: %s", err)
+	}
+
+	return nil
+}
+
 func (fs *OverlayFS) workDir() string {
+	if fs.opts.WorkDir != "" {
+		return fs.opts.WorkDir
+	}
+
 	parent := filepath.Dir(fs.upperDir)
 	return path.Join(parent, "work")
 }
@@ -46,6 +236,7 @@ func (fs *OverlayFS) mount_ro() mount.Mount {
 		"relatime",
 		fmt.Sprintf("lowerdir=%s", strings.Join(lowerDirs, ":")),
 	}
+	mountOptions = append(mountOptions, fs.opts.featureOptions(true)...)
 
 	return mount.Mount{
 		Type:    "overlay",
@@ -64,6 +255,7 @@ func (fs *OverlayFS) mount() mount.Mount {
 		fmt.Sprintf("upperdir=%s", fs.upperDir),
 		fmt.Sprintf("workdir=%s", fs.workDir()),
 	}
+	mountOptions = append(mountOptions, fs.opts.featureOptions(false)...)
 
 	return mount.Mount{
 		Type:    "overlay",
@@ -78,6 +270,10 @@ func (fs *OverlayFS) Mount(readOnly bool) error {
 		return fmt.Errorf("overlayfs lower dir can not be empty")
 	}
 
+	if err := fs.CheckSupport(); err != nil {
+		return err
+	}
+
 	var m mount.Mount
 
 	if readOnly {
@@ -93,6 +289,13 @@ func (fs *OverlayFS) Mount(readOnly bool) error {
 
 		m = fs.mount_ro()
 	} else {
+		// unlike the default work dir, a work dir given by the options may not exist yet.
+		if fs.opts.WorkDir != "" {
+			if err := os.MkdirAll(fs.workDir(), 0711); err != nil {
+				return fmt.Errorf("failed to create overlayfs work dir (%s): %s", fs.workDir(), err)
+			}
+		}
+
 		m = fs.mount()
 	}
 
@@ -103,7 +306,7 @@ func (fs *OverlayFS) Mount(readOnly bool) error {
 		return fmt.Errorf("failed to create overlayfs merged dir (%s): %s", fs.mergedDir, err)
 	}
 
-	if err := m.Mount(""); err != nil {
+	if err := mountOverlay(m); err != nil {
 		pretty.Println(m)
 		return err
 	}
@@ -0,0 +1,199 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/mount"
+	"golang.org/x/sys/unix"
+)
+
+// errLowerdirPlusUnsupported is returned when the kernel does not support the "lowerdir+"
+// parameter of the new mount API, added in linux 6.8.
+var errLowerdirPlusUnsupported = errors.New("overlay lowerdir+ parameter is not supported")
+
+// mountOverlay mounts the overlay mount m.
+//
+// The mount data passed to mount(2) is limited to a page, which a lowerdir option easily exceeds
+// for images with many layers. The lower dirs are then passed one by one with the "lowerdir+" parameter
+// of the new mount API, or, on older kernels, through short relative symbolic links.
+func mountOverlay(m mount.Mount) error {
+	if len(strings.Join(m.Options, ",")) < os.Getpagesize() {
+		return m.Mount("")
+	}
+
+	err := mountOverlayLowerdirPlus(m)
+	if errors.Is(err, errLowerdirPlusUnsupported) {
+		return mountOverlaySymlinks(m)
+	}
+	return err
+}
+
+// splitLowerdirOption returns the lower dirs of the lowerdir option and the other options.
+func splitLowerdirOption(options []string) ([]string, []string) {
+	var lowerDirs, others []string
+	for _, option := range options {
+		if dirs, ok := strings.CutPrefix(option, "lowerdir="); ok {
+			lowerDirs = strings.Split(dirs, ":")
+			continue
+		}
+		others = append(others, option)
+	}
+	return lowerDirs, others
+}
+
+// overlayMountAttrs are the mount options which are attributes of the mount instead of parameters
+// of the overlay filesystem with the new mount API.
+var overlayMountAttrs = map[string]int{
+	"ro":          unix.MOUNT_ATTR_RDONLY,
+	"nosuid":      unix.MOUNT_ATTR_NOSUID,
+	"nodev":       unix.MOUNT_ATTR_NODEV,
+	"noexec":      unix.MOUNT_ATTR_NOEXEC,
+	"noatime":     unix.MOUNT_ATTR_NOATIME,
+	"relatime":    unix.MOUNT_ATTR_RELATIME,
+	"strictatime": unix.MOUNT_ATTR_STRICTATIME,
+	"nodiratime":  unix.MOUNT_ATTR_NODIRATIME,
+	"rw":          0,
+}
+
+// mountOverlayLowerdirPlus mounts m with fsopen/fsconfig/fsmount, passing each lower dir with
+// its own "lowerdir+" parameter.
+func mountOverlayLowerdirPlus(m mount.Mount) error {
+	lowerDirs, others := splitLowerdirOption(m.Options)
+
+	fsfd, err := unix.Fsopen("overlay", unix.FSOPEN_CLOEXEC)
+	if errors.Is(err, unix.ENOSYS) {
+		return errLowerdirPlusUnsupported
+	}
+	if err != nil {
+		return fmt.Errorf("fsopen overlay failed, err: %s", err)
+	}
+	defer unix.Close(fsfd)
+
+	for i, dir := range lowerDirs {
+		err := unix.FsconfigSetString(fsfd, "lowerdir+", dir)
+		if i == 0 && errors.Is(err, unix.EINVAL) {
+			return errLowerdirPlusUnsupported
+		}
+		if err != nil {
+			return fmt.Errorf("set overlay lower dir (%s) failed, err: %s", dir, err)
+		}
+	}
+
+	var attrs int
+	for _, option := range others {
+		key, value, hasValue := strings.Cut(option, "=")
+		if attr, ok := overlayMountAttrs[option]; ok {
+			attrs |= attr
+			if option == "ro" {
+				err = unix.FsconfigSetFlag(fsfd, "ro")
+			}
+		} else if hasValue {
+			err = unix.FsconfigSetString(fsfd, key, value)
+		} else {
+			err = unix.FsconfigSetFlag(fsfd, key)
+		}
+		if err != nil {
+			return fmt.Errorf("set overlay option (%s) failed, err: %s", option, err)
+		}
+	}
+
+	if err := unix.FsconfigCreate(fsfd); err != nil {
+		return fmt.Errorf("create overlay filesystem failed, err: %s", err)
+	}
+
+	mfd, err := unix.Fsmount(fsfd, unix.FSMOUNT_CLOEXEC, attrs)
+	if err != nil {
+		return fmt.Errorf("fsmount overlay failed, err: %s", err)
+	}
+	defer unix.Close(mfd)
+
+	if err := unix.MoveMount(mfd, "", unix.AT_FDCWD, m.Target, unix.MOVE_MOUNT_F_EMPTY_PATH); err != nil {
+		return fmt.Errorf("move overlay mount to (%s) failed, err: %s", m.Target, err)
+	}
+
+	return nil
+}
+
+// mountOverlaySymlinks mounts m with the lower dirs replaced by symbolic links named 0, 1, 2, ...
+// in a temporary directory, relative to it. The mount is done by a thread whose working directory is
+// the temporary directory, and the links are removed once mounted, overlay having resolved them.
+func mountOverlaySymlinks(m mount.Mount) error {
+	lowerDirs, others := splitLowerdirOption(m.Options)
+
+	linkDir, err := os.MkdirTemp("", ".overlay-lower-")
+	if err != nil {
+		return fmt.Errorf("create overlay lower links dir failed, err: %s", err)
+	}
+	defer os.RemoveAll(linkDir)
+
+	links := make([]string, len(lowerDirs))
+	for i, dir := range lowerDirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return fmt.Errorf("get absolute path of overlay lower dir (%s) failed, err: %s", dir, err)
+		}
+		links[i] = strconv.Itoa(i)
+		if err := os.Symlink(abs, filepath.Join(linkDir, links[i])); err != nil {
+			return fmt.Errorf("create overlay lower link failed, err: %s", err)
+		}
+	}
+
+	// the other dirs must not be relative to the temporary directory.
+	options := []string{}
+	for _, option := range others {
+		key, value, _ := strings.Cut(option, "=")
+		if key == "upperdir" || key == "workdir" {
+			abs, err := filepath.Abs(value)
+			if err != nil {
+				return fmt.Errorf("get absolute path of overlay %s (%s) failed, err: %s", key, value, err)
+			}
+			option = key + "=" + abs
+		}
+		options = append(options, option)
+	}
+	options = append(options, "lowerdir="+strings.Join(links, ":"))
+
+	target, err := filepath.Abs(m.Target)
+	if err != nil {
+		return fmt.Errorf("get absolute path of overlay target (%s) failed, err: %s", m.Target, err)
+	}
+
+	linked := mount.Mount{Type: m.Type, Source: m.Source, Options: options}
+
+	errCh := make(chan error, 1)
+	go func() {
+		// the working directory is shared by the threads of the process, unless unshared.
+		// The thread is not unlocked and exits with the goroutine.
+		runtime.LockOSThread()
+
+		if err := unix.Unshare(unix.CLONE_FS); err != nil {
+			errCh <- fmt.Errorf("unshare fs attributes failed, err: %s", err)
+			return
+		}
+		if err := unix.Chdir(linkDir); err != nil {
+			errCh <- fmt.Errorf("chdir to overlay lower links dir failed, err: %s", err)
+			return
+		}
+		errCh <- linked.Mount(target)
+	}()
+
+	return <-errCh
+}
+
+// unmountOverlayAt unmounts the mount at target, and detaches it if it is busy.
+func unmountOverlayAt(target string) error {
+	err := unix.Unmount(target, 0)
+	if errors.Is(err, unix.EBUSY) {
+		err = unix.Unmount(target, unix.MNT_DETACH)
+	}
+	if err != nil {
+		return fmt.Errorf("unmount overlay (%s) failed, err: %w", target, err)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package container
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/mount"
+	"golang.org/x/sys/unix"
+)
+
+// newTestOverlayDirs creates a lower dir with a file, an empty upper dir, and returns the options for them.
+func newTestOverlayDirs(t *testing.T) OverlayOptions {
+	t.Helper()
+
+	dir := t.TempDir()
+	opts := OverlayOptions{
+		LowerDir:  filepath.Join(dir, "lower"),
+		UpperDir:  filepath.Join(dir, "upper"),
+		MergedDir: filepath.Join(dir, "merged"),
+		WorkDir:   filepath.Join(dir, "custom-work"),
+	}
+	for _, d := range []string{opts.LowerDir, opts.UpperDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(opts.LowerDir, "file"), []byte("lower"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return opts
+}
+
+// mountOptionsOf returns the super options of the mount at target in /proc/self/mountinfo.
+func mountOptionsOf(t *testing.T, target string) string {
+	t.Helper()
+
+	data, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 4 && fields[4] == target {
+			return fields[len(fields)-1]
+		}
+	}
+	return ""
+}
+
+func TestOverlayFS_MountWithOptions(t *testing.T) {
+	requireRoot(t)
+
+	opts := newTestOverlayDirs(t)
+	opts.Index = OverlayOn
+	opts.RedirectDir = "on"
+	opts.Xino = "off"
+	opts.Volatile = true
+	opts.ExtraOptions = []string{"nodev"}
+
+	fs, err := NewOverlayFSWithOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mount(false); err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Unmount()
+
+	superOptions := mountOptionsOf(t, opts.MergedDir)
+	for _, option := range []string{"workdir=" + opts.WorkDir, "index=on", "redirect_dir=on", "volatile"} {
+		if !strings.Contains(superOptions, option) {
+			t.Errorf("option %s not in %s", option, superOptions)
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(opts.MergedDir, "file")); err != nil || string(data) != "lower" {
+		t.Errorf("unexpected merged file: %q, err: %v", data, err)
+	}
+}
+
+func TestOverlayFS_CheckSupport(t *testing.T) {
+	requireRoot(t)
+
+	opts := newTestOverlayDirs(t)
+	opts.Metacopy = OverlayOn
+	opts.RedirectDir = "on"
+
+	fs, err := NewOverlayFSWithOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a kernel without the metacopy feature
+	paramsDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(paramsDir, "redirect_dir"), []byte("N\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(dir string) { overlayModuleParametersDir = dir }(overlayModuleParametersDir)
+	overlayModuleParametersDir = paramsDir
+
+	if err := fs.CheckSupport(); err == nil || !strings.Contains(err.Error(), "metacopy") {
+		t.Errorf("unexpected err: %v", err)
+	}
+
+	// the probe mount rejects invalid extra options
+	opts.Metacopy = ""
+	opts.ExtraOptions = []string{"no_such_option"}
+	fs, _ = NewOverlayFSWithOptions(opts)
+	if err := fs.CheckSupport(); err == nil || !strings.Contains(err.Error(), "probe") {
+		t.Errorf("unexpected err: %v", err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(opts.UpperDir))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".overlay-probe-") {
+			t.Errorf("probe dir %s not removed", entry.Name())
+		}
+	}
+}
+
+func TestNewOverlayFSWithOptions_Invalid(t *testing.T) {
+	for _, opts := range []OverlayOptions{
+		{Index: "yes"},
+		{RedirectDir: "maybe"},
+		{Xino: "always"},
+		{ExtraOptions: []string{"upperdir=/tmp"}},
+	} {
+		if _, err := NewOverlayFSWithOptions(opts); err == nil {
+			t.Errorf("options %+v accepted", opts)
+		}
+	}
+}
+
+func TestOverlayFS_MountManyLayers(t *testing.T) {
+	requireRoot(t)
+
+	layersDir := t.TempDir()
+	if err := unix.Mount("tmpfs", layersDir, "tmpfs", 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Unmount(layersDir, unix.MNT_DETACH)
+
+	// like /var/lib/containerd/io.containerd.snapshotter.v1.overlayfs/snapshots/N/fs, the uppermost first
+	const layers = 220
+	var lowerDirs []string
+	for i := layers - 1; i >= 0; i-- {
+		dir := filepath.Join(layersDir, "io.containerd.snapshotter.v1.overlayfs", "snapshots", strconv.Itoa(i), "fs")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "layer-"+strconv.Itoa(i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "top"), []byte(strconv.Itoa(i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		lowerDirs = append(lowerDirs, dir)
+	}
+	lowerDir := strings.Join(lowerDirs, ":")
+	if len(lowerDir) < os.Getpagesize() {
+		t.Fatalf("lowerdir option of %d bytes is not long enough", len(lowerDir))
+	}
+
+	check := func(t *testing.T, mergedDir string) {
+		t.Helper()
+		entries, err := os.ReadDir(mergedDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the layer-N files, the top file and the file of the upper dir
+		if len(entries) != layers+2 {
+			t.Errorf("unexpected number of entries in the merged dir: %d", len(entries))
+		}
+		if data, err := os.ReadFile(filepath.Join(mergedDir, "top")); err != nil || string(data) != strconv.Itoa(layers-1) {
+			t.Errorf("unexpected top file: %q, err: %v", data, err)
+		}
+	}
+
+	newDirs := func(t *testing.T) (string, string, string) {
+		dir := t.TempDir()
+		upperDir, workDir, mergedDir := filepath.Join(dir, "upper"), filepath.Join(dir, "work"), filepath.Join(dir, "merged")
+		for _, d := range []string{upperDir, workDir, mergedDir} {
+			if err := os.MkdirAll(d, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(upperDir, "upper"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return upperDir, workDir, mergedDir
+	}
+
+	t.Run("OverlayFS", func(t *testing.T) {
+		upperDir, _, mergedDir := newDirs(t)
+		fs := NewOverlayFS(lowerDir, upperDir, mergedDir)
+		if err := fs.Mount(false); err != nil {
+			t.Fatal(err)
+		}
+		defer fs.Unmount()
+		check(t, mergedDir)
+	})
+
+	for name, mountFn := range map[string]func(mount.Mount) error{
+		"lowerdir+": mountOverlayLowerdirPlus,
+		"symlinks":  mountOverlaySymlinks,
+	} {
+		t.Run(name, func(t *testing.T) {
+			upperDir, workDir, mergedDir := newDirs(t)
+			m := mount.Mount{
+				Type:   "overlay",
+				Source: "overlay",
+				Target: mergedDir,
+				Options: []string{
+					"rw", "relatime", "nodev",
+					"lowerdir=" + lowerDir,
+					"upperdir=" + upperDir,
+					"workdir=" + workDir,
+				},
+			}
+			err := mountFn(m)
+			if errors.Is(err, errLowerdirPlusUnsupported) {
+				t.Skip(err)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer unix.Unmount(mergedDir, 0)
+
+			check(t, mergedDir)
+			if options := mountOptionsOf(t, mergedDir); !strings.Contains(options, "upperdir="+upperDir) {
+				t.Errorf("unexpected mount options: %s", options)
+			}
+		})
+	}
+}
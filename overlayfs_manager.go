@@ -0,0 +1,403 @@
+package container
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/moby/sys/mountinfo"
+)
+
+// OverlayMountManager mounts and unmounts overlay filesystems, counting the users of each merged dir,
+// and records its mounts in a state file so that they can be found again after a restart.
+//
+// There must be only one manager, in one process, for a state file.
+type OverlayMountManager struct {
+	statePath string
+
+	mu     sync.Mutex
+	mounts map[string]*OverlayMountRecord
+}
+
+// OverlayMountRecord is an overlay filesystem mounted by an OverlayMountManager.
+type OverlayMountRecord struct {
+	Options  OverlayOptions `json:"options"`
+	ReadOnly bool           `json:"readOnly"`
+
+	// RefCount is the number of users of the mount, which is unmounted when it drops to zero.
+	RefCount  int       `json:"refCount"`
+	MountedAt time.Time `json:"mountedAt"`
+}
+
+type overlayMountState struct {
+	Mounts []*OverlayMountRecord `json:"mounts"`
+}
+
+// NewOverlayMountManager returns a manager recording its mounts in the state file at statePath,
+// and loads the mounts recorded by a previous manager. The state file is created on the first mount.
+// Call Reconcile to check the loaded mounts against the mounts of the node.
+func NewOverlayMountManager(statePath string) (*OverlayMountManager, error) {
+	mm := &OverlayMountManager{
+		statePath: statePath,
+		mounts:    map[string]*OverlayMountRecord{},
+	}
+
+	data, err := os.ReadFile(statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return mm, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read overlay mount state failed, err: %s", err)
+	}
+
+	var state overlayMountState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("decode overlay mount state (%s) failed, err: %s", statePath, err)
+	}
+	for _, record := range state.Mounts {
+		mm.mounts[record.Options.MergedDir] = record
+	}
+
+	return mm, nil
+}
+
+// Mounts returns the mounts recorded by the manager, sorted by merged dir.
+func (mm *OverlayMountManager) Mounts() []OverlayMountRecord {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	records := make([]OverlayMountRecord, 0, len(mm.mounts))
+	for _, record := range mm.mounts {
+		records = append(records, *record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Options.MergedDir < records[j].Options.MergedDir
+	})
+
+	return records
+}
+
+// Mount mounts the overlay filesystem, or only takes a reference if it is already mounted at its merged dir
+// by the manager or by a previous manager. An overlay found mounted at the merged dir without being recorded
+// is adopted if it has the same dirs, and is an error otherwise, like a recorded mount of other dirs.
+// The mount is recorded in the state file before it is done, so that it can be found again after a crash.
+func (mm *OverlayMountManager) Mount(fs *OverlayFS, readOnly bool) error {
+	opts, err := fs.absOptions()
+	if err != nil {
+		return err
+	}
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	mounted, err := overlayMountedAt(opts.MergedDir)
+	if err != nil {
+		return err
+	}
+
+	record, ok := mm.mounts[opts.MergedDir]
+	if ok && (record.Options.LowerDir != opts.LowerDir || record.Options.UpperDir != opts.UpperDir || record.ReadOnly != readOnly) {
+		return fmt.Errorf("merged dir (%s) is already mounted with other dirs", opts.MergedDir)
+	}
+
+	switch {
+	case ok && mounted != nil:
+		record.RefCount++
+
+	case mounted != nil:
+		if !overlayMountHasDirs(mounted, opts, readOnly) {
+			return fmt.Errorf("merged dir (%s) is already mounted by another overlay", opts.MergedDir)
+		}
+		mm.mounts[opts.MergedDir] = &OverlayMountRecord{Options: opts, ReadOnly: readOnly, RefCount: 1, MountedAt: time.Now()}
+
+	default:
+		// a recorded mount which disappeared is mounted again, its users are kept.
+		if !ok {
+			record = &OverlayMountRecord{Options: opts, ReadOnly: readOnly, MountedAt: time.Now()}
+			mm.mounts[opts.MergedDir] = record
+		}
+		record.RefCount++
+
+		// the dirs are mounted by their absolute paths, which are compared to the mountinfo.
+		err := mm.save()
+		if err == nil {
+			fs, err = NewOverlayFSWithOptions(opts)
+		}
+		if err == nil {
+			err = fs.Mount(readOnly)
+		}
+		if err != nil {
+			record.RefCount--
+			if !ok {
+				delete(mm.mounts, opts.MergedDir)
+			}
+			return errors.Join(err, mm.save())
+		}
+		return nil
+	}
+
+	return mm.save()
+}
+
+// Unmount releases a reference on the overlay mounted at mergedDir, and unmounts it when it was the last one.
+// A mount which is busy is detached: it disappears from the mount table and is released by the kernel once unused.
+// A mount which is already gone, eg: unmounted by someone else, is forgotten.
+func (mm *OverlayMountManager) Unmount(mergedDir string) error {
+	mergedDir, err := realPath(mergedDir)
+	if err != nil {
+		return fmt.Errorf("resolve path of merged dir (%s) failed, err: %s", mergedDir, err)
+	}
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	record, ok := mm.mounts[mergedDir]
+	if !ok {
+		return fmt.Errorf("merged dir (%s) is not mounted by the manager", mergedDir)
+	}
+
+	if record.RefCount > 1 {
+		record.RefCount--
+		return mm.save()
+	}
+
+	// the kernel returns EINVAL for a target which is not a mountpoint.
+	if err := unmountOverlayAt(mergedDir); err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	delete(mm.mounts, mergedDir)
+
+	return mm.save()
+}
+
+// Reconcile checks the recorded mounts against the mounts of the node, typically after a restart.
+// Mounts which disappeared are mounted again if remount is true, and forgotten otherwise, like mounts
+// replaced by another overlay. The mounts which can not be mounted again are forgotten and reported in the error.
+func (mm *OverlayMountManager) Reconcile(remount bool) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	var errs []error
+	for mergedDir, record := range mm.mounts {
+		mounted, err := overlayMountedAt(mergedDir)
+		if err != nil {
+			return err
+		}
+		if mounted != nil && overlayMountHasDirs(mounted, record.Options, record.ReadOnly) {
+			continue
+		}
+
+		if !remount || mounted != nil {
+			delete(mm.mounts, mergedDir)
+			continue
+		}
+
+		fs, err := NewOverlayFSWithOptions(record.Options)
+		if err == nil {
+			err = fs.Mount(record.ReadOnly)
+		}
+		if err != nil {
+			delete(mm.mounts, mergedDir)
+			errs = append(errs, fmt.Errorf("mount overlay (%s) again failed, err: %s", mergedDir, err))
+			continue
+		}
+		record.MountedAt = time.Now()
+	}
+
+	if err := mm.save(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// UnmountAll unmounts all the recorded mounts whatever their number of users, detaching the busy ones,
+// eg: to clean up the mounts left by a previous run.
+func (mm *OverlayMountManager) UnmountAll() error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	var errs []error
+	for mergedDir := range mm.mounts {
+		mounted, err := overlayMountedAt(mergedDir)
+		if err == nil && mounted != nil {
+			err = unmountOverlayAt(mergedDir)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delete(mm.mounts, mergedDir)
+	}
+
+	if err := mm.save(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// save writes the state file, atomically. It must be called with mm.mu held.
+func (mm *OverlayMountManager) save() error {
+	state := overlayMountState{Mounts: []*OverlayMountRecord{}}
+	for _, record := range mm.mounts {
+		state.Mounts = append(state.Mounts, record)
+	}
+	sort.Slice(state.Mounts, func(i, j int) bool {
+		return state.Mounts[i].Options.MergedDir < state.Mounts[j].Options.MergedDir
+	})
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode overlay mount state failed, err: %s", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(mm.statePath), 0700); err != nil {
+		return fmt.Errorf("create overlay mount state dir failed, err: %s", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(mm.statePath), filepath.Base(mm.statePath)+".tmp-")
+	if err != nil {
+		return fmt.Errorf("create overlay mount state failed, err: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write overlay mount state failed, err: %s", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync overlay mount state failed, err: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write overlay mount state failed, err: %s", err)
+	}
+
+	if err := os.Rename(tmp.Name(), mm.statePath); err != nil {
+		return fmt.Errorf("replace overlay mount state failed, err: %s", err)
+	}
+
+	return nil
+}
+
+// absOptions returns the options of the overlay filesystem with its dirs made absolute, and their symbolic links resolved.
+func (fs *OverlayFS) absOptions() (OverlayOptions, error) {
+	opts := fs.opts
+	opts.LowerDir, opts.UpperDir, opts.MergedDir = fs.lowerDir, fs.upperDir, fs.mergedDir
+
+	lowerDirs := strings.Split(opts.LowerDir, ":")
+	dirs := []*string{&opts.UpperDir, &opts.MergedDir, &opts.WorkDir}
+	for i := range lowerDirs {
+		dirs = append(dirs, &lowerDirs[i])
+	}
+
+	for _, dir := range dirs {
+		if *dir == "" {
+			continue
+		}
+		real, err := realPath(*dir)
+		if err != nil {
+			return opts, fmt.Errorf("resolve path of overlay dir (%s) failed, err: %s", *dir, err)
+		}
+		*dir = real
+	}
+	opts.LowerDir = strings.Join(lowerDirs, ":")
+
+	return opts, nil
+}
+
+// realPath returns the absolute path of dir with its symbolic links resolved, like the mountpoints in the
+// mountinfo, eg: /run/xxx for /var/run/xxx. A dir which does not exist yet is resolved from its nearest
+// existing parent.
+func realPath(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	var missing []string
+	for {
+		real, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			return filepath.Join(append([]string{real}, missing...)...), nil
+		}
+		parent := filepath.Dir(dir)
+		if !errors.Is(err, os.ErrNotExist) || parent == dir {
+			return "", err
+		}
+		missing = append([]string{filepath.Base(dir)}, missing...)
+		dir = parent
+	}
+}
+
+// overlayMountedAt returns the topmost mount at target from /proc/self/mountinfo if it is an overlay,
+// or nil if there is none.
+func overlayMountedAt(target string) (*mountinfo.Info, error) {
+	mounts, err := mountinfo.GetMounts(mountinfo.SingleEntryFilter(target))
+	if err != nil {
+		return nil, fmt.Errorf("get mounts failed, err: %s", err)
+	}
+	if len(mounts) == 0 {
+		return nil, nil
+	}
+
+	// mounts stacked at the same mountpoint are listed in the order they were mounted.
+	top := mounts[len(mounts)-1]
+	if top.FSType != "overlay" {
+		return nil, nil
+	}
+	return top, nil
+}
+
+// overlayMountHasDirs tells if the overlay mount has the dirs of opts. The lower dirs of a readonly mount
+// are its upper dir followed by the lower dirs of opts, like in OverlayFS.Mount.
+//
+// The lower dirs mounted through symbolic links on kernels without the "lowerdir+" parameter are shown
+// by the names of the links, which can not be checked.
+func overlayMountHasDirs(info *mountinfo.Info, opts OverlayOptions, readOnly bool) bool {
+	lowerDirs := strings.Split(opts.LowerDir, ":")
+	upperDir := opts.UpperDir
+	if readOnly {
+		if upperDir != "" {
+			lowerDirs = append([]string{upperDir}, lowerDirs...)
+		}
+		upperDir = ""
+	}
+
+	var mountedLowerDirs []string
+	var mountedUpperDir string
+	for _, option := range strings.Split(info.VFSOptions, ",") {
+		key, value, _ := strings.Cut(option, "=")
+		switch key {
+		case "lowerdir":
+			mountedLowerDirs = append(mountedLowerDirs, strings.Split(value, ":")...)
+		case "lowerdir+":
+			mountedLowerDirs = append(mountedLowerDirs, value)
+		case "upperdir":
+			mountedUpperDir = value
+		}
+	}
+
+	if mountedUpperDir != upperDir || len(mountedLowerDirs) != len(lowerDirs) {
+		return false
+	}
+	if slices.Equal(mountedLowerDirs, lowerDirs) {
+		return true
+	}
+	for i, dir := range mountedLowerDirs {
+		if dir != strconv.Itoa(i) {
+			return false
+		}
+	}
+	return true
+}
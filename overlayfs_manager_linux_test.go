@@ -0,0 +1,220 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/moby/sys/mountinfo"
+	"golang.org/x/sys/unix"
+)
+
+func TestOverlayMountManager(t *testing.T) {
+	requireRoot(t)
+
+	opts := newTestOverlayDirs(t)
+	statePath := filepath.Join(t.TempDir(), "state", "overlay-mounts.json")
+
+	mm, err := NewOverlayMountManager(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mm.UnmountAll()
+
+	mountCount := func() int {
+		mounts, err := mountinfo.GetMounts(mountinfo.SingleEntryFilter(opts.MergedDir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(mounts)
+	}
+
+	// mounting twice takes a second reference instead of stacking mounts
+	for i := 0; i < 2; i++ {
+		fs, _ := NewOverlayFSWithOptions(opts)
+		if err := mm.Mount(fs, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := mountCount(); n != 1 {
+		t.Fatalf("unexpected number of mounts: %d", n)
+	}
+
+	// other dirs can not be mounted at the same merged dir
+	other := opts
+	other.UpperDir = t.TempDir()
+	fs, _ := NewOverlayFSWithOptions(other)
+	if err := mm.Mount(fs, false); err == nil {
+		t.Error("mount of other dirs at the same merged dir succeeded")
+	}
+
+	// a new manager, like after a restart, finds the mount in the state file
+	mm, err = NewOverlayMountManager(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.Reconcile(false); err != nil {
+		t.Fatal(err)
+	}
+	records := mm.Mounts()
+	if len(records) != 1 || records[0].RefCount != 2 || records[0].Options.WorkDir != opts.WorkDir {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+
+	if err := mm.Unmount(opts.MergedDir); err != nil {
+		t.Fatal(err)
+	}
+	if n := mountCount(); n != 1 {
+		t.Fatalf("unmounted while still referenced")
+	}
+
+	// the last reference unmounts, detaching the busy mount
+	busy, err := os.Open(filepath.Join(opts.MergedDir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	if err := mm.Unmount(opts.MergedDir); err != nil {
+		t.Fatal(err)
+	}
+	if n := mountCount(); n != 0 || len(mm.Mounts()) != 0 {
+		t.Fatalf("not unmounted: %d mounts, records: %+v", n, mm.Mounts())
+	}
+
+	// a recorded mount which disappeared is mounted again by Reconcile
+	fs, _ = NewOverlayFSWithOptions(opts)
+	if err := mm.Mount(fs, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Unmount(opts.MergedDir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.Reconcile(true); err != nil {
+		t.Fatal(err)
+	}
+	if n := mountCount(); n != 1 {
+		t.Fatalf("not mounted again: %d mounts", n)
+	}
+
+	// or forgotten
+	if err := unix.Unmount(opts.MergedDir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.Reconcile(false); err != nil {
+		t.Fatal(err)
+	}
+	if len(mm.Mounts()) != 0 {
+		t.Fatalf("stale mount not forgotten: %+v", mm.Mounts())
+	}
+}
+
+func TestOverlayMountManager_dirs(t *testing.T) {
+	requireRoot(t)
+
+	opts := newTestOverlayDirs(t)
+	statePath := filepath.Join(t.TempDir(), "overlay-mounts.json")
+
+	mm, err := NewOverlayMountManager(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mm.UnmountAll()
+
+	// a relative lower dir is recorded as an absolute path
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Dir(opts.LowerDir)); err != nil {
+		t.Fatal(err)
+	}
+	relative := opts
+	relative.LowerDir = filepath.Base(opts.LowerDir)
+	fs, _ := NewOverlayFSWithOptions(relative)
+	err = mm.Mount(fs, false)
+	if err := os.Chdir(wd); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := mm.Mounts(); len(records) != 1 || records[0].Options.LowerDir != opts.LowerDir {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	if err := mm.Reconcile(false); err != nil || len(mm.Mounts()) != 1 {
+		t.Fatalf("mount with a relative lower dir not found again: %v", err)
+	}
+	if err := mm.Unmount(opts.MergedDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// a merged dir under a symbolic link, like /var/run, is recorded and found by its real path
+	link := filepath.Join(t.TempDir(), "run")
+	if err := os.Symlink(filepath.Dir(opts.MergedDir), link); err != nil {
+		t.Fatal(err)
+	}
+	linked := opts
+	linked.MergedDir = filepath.Join(link, filepath.Base(opts.MergedDir))
+	fs, _ = NewOverlayFSWithOptions(linked)
+	if err := mm.Mount(fs, false); err != nil {
+		t.Fatal(err)
+	}
+	if records := mm.Mounts(); len(records) != 1 || records[0].Options.MergedDir != opts.MergedDir {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	if err := mm.Reconcile(false); err != nil || len(mm.Mounts()) != 1 {
+		t.Fatalf("mount under a symbolic link not found again: %v", err)
+	}
+
+	// a mount unmounted by someone else is forgotten by Unmount
+	if err := unix.Unmount(opts.MergedDir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.Unmount(linked.MergedDir); err != nil {
+		t.Fatal(err)
+	}
+	if records := mm.Mounts(); len(records) != 0 {
+		t.Fatalf("unmounted mount not forgotten: %+v", records)
+	}
+
+	// a readonly overlay of other dirs is not adopted, one of the same dirs is
+	other := opts
+	other.UpperDir = t.TempDir()
+	foreign, _ := NewOverlayFSWithOptions(other)
+	if err := foreign.Mount(true); err != nil {
+		t.Fatal(err)
+	}
+	fs, _ = NewOverlayFSWithOptions(opts)
+	if err := mm.Mount(fs, true); err == nil {
+		t.Error("readonly overlay of other dirs adopted")
+	}
+	if err := unix.Unmount(opts.MergedDir, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	same, _ := NewOverlayFSWithOptions(opts)
+	if err := same.Mount(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.Mount(fs, true); err != nil {
+		t.Fatalf("readonly overlay of the same dirs not adopted: %v", err)
+	}
+	if err := mm.Unmount(opts.MergedDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed mount is not left in the state file
+	missing := opts
+	missing.LowerDir = filepath.Join(t.TempDir(), "missing")
+	fs, _ = NewOverlayFSWithOptions(missing)
+	if err := mm.Mount(fs, false); err == nil {
+		t.Fatal("mount of a missing lower dir succeeded")
+	}
+	mm, err = NewOverlayMountManager(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := mm.Mounts(); len(records) != 0 {
+		t.Fatalf("failed mount recorded: %+v", records)
+	}
+}
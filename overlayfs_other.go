@@ -0,0 +1,15 @@
+//go:build !linux
+
+package container
+
+import (
+	"github.com/containerd/containerd/mount"
+)
+
+func mountOverlay(m mount.Mount) error {
+	return m.Mount("")
+}
+
+func unmountOverlayAt(target string) error {
+	return mount.UnmountAll(target, 0)
+}
@@ -0,0 +1,503 @@
+package container
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// overlayMaxSymlinks is the number of symbolic links followed when resolving a path, like the kernel.
+const overlayMaxSymlinks = 40
+
+// OverlayView is a read-only view of the merged dir of an overlay filesystem, computed in userspace
+// from its upper dir and lower dirs, which does not require to mount it. Lookups are resolved across the
+// dirs like overlayfs does: whiteouts hide the entries of the lower dirs, opaque dirs hide the lower dirs,
+// redirected dirs continue in the lower dirs at their redirect path, and metacopy files take their data
+// from the lower dirs.
+//
+// Symbolic links are resolved inside the view, as if it were the root of the filesystem,
+// so that a link of the container never escapes to the files of the host.
+//
+// OverlayView implements fs.FS, fs.StatFS and fs.ReadDirFS.
+type OverlayView struct {
+	// layers are the upper dir if any, followed by the lower dirs, the uppermost first.
+	layers []string
+
+	xattrPrefix    string
+	followRedirect bool
+}
+
+// NewOverlayView returns the view of the overlay filesystem of the given dirs, as returned by GetOverlayDirs.
+// The upper dir may be empty for a readonly view of the lower dirs.
+func NewOverlayView(lowerDir, upperDir string) *OverlayView {
+	return NewOverlayViewWithOptions(OverlayOptions{LowerDir: lowerDir, UpperDir: upperDir})
+}
+
+// NewOverlayViewWithOptions returns the view of the overlay filesystem of the dirs of the options.
+// The options UserXattr and RedirectDir are honored like the kernel does, the others are ignored.
+func NewOverlayViewWithOptions(opts OverlayOptions) *OverlayView {
+	v := &OverlayView{
+		xattrPrefix:    "trusted.overlay.",
+		followRedirect: opts.RedirectDir != "nofollow",
+	}
+	if opts.UserXattr {
+		v.xattrPrefix = "user.overlay."
+	}
+
+	if opts.UpperDir != "" {
+		v.layers = append(v.layers, opts.UpperDir)
+	}
+	for _, dir := range strings.Split(opts.LowerDir, ":") {
+		if dir != "" {
+			v.layers = append(v.layers, dir)
+		}
+	}
+
+	return v
+}
+
+// layerPath is a path in a layer, relative to the root of the layer.
+type layerPath struct {
+	layer int
+	path  string
+}
+
+// overlayNode is a resolved path of the view.
+type overlayNode struct {
+	name string
+
+	// info is the information of the uppermost entry, which has the metadata of the merged entry.
+	info fs.FileInfo
+
+	// top is the uppermost entry, data the entry with the content of a regular file or the target of a link.
+	top  layerPath
+	data layerPath
+
+	// dirs are the merged dirs of a directory, the uppermost first.
+	dirs []layerPath
+}
+
+func (v *OverlayView) realPath(p layerPath) string {
+	return filepath.Join(v.layers[p.layer], filepath.FromSlash(p.path))
+}
+
+func (v *OverlayView) root() (*overlayNode, error) {
+	if len(v.layers) == 0 {
+		return nil, fs.ErrNotExist
+	}
+
+	node := &overlayNode{name: "."}
+	for i := range v.layers {
+		p := layerPath{layer: i, path: "."}
+		fi, err := os.Stat(v.realPath(p))
+		if err != nil {
+			return nil, err
+		}
+		if node.info == nil {
+			node.info, node.top = fi, p
+		}
+		node.dirs = append(node.dirs, p)
+	}
+
+	return node, nil
+}
+
+// lookup looks up the name in the directory parent, like ovl_lookup.
+func (v *OverlayView) lookup(parent *overlayNode, name string) (*overlayNode, error) {
+	var node *overlayNode
+	var metacopy bool
+
+	// the name is looked up in the parent dir of each layer, or from the root of the
+	// layers after an absolute redirect.
+	lookupName, absPath := name, ""
+	pi := 0
+
+	for layer := parent.dirs[0].layer; layer < len(v.layers); layer++ {
+		var p string
+		if absPath != "" {
+			p = absPath
+		} else {
+			for pi < len(parent.dirs) && parent.dirs[pi].layer < layer {
+				pi++
+			}
+			if pi == len(parent.dirs) {
+				break
+			}
+			if parent.dirs[pi].layer != layer {
+				continue
+			}
+			p = path.Join(parent.dirs[pi].path, lookupName)
+		}
+
+		lp := layerPath{layer: layer, path: p}
+		real := v.realPath(lp)
+		fi, err := os.Lstat(real)
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// a whiteout hides the entries of the lower layers.
+		if isOverlayWhiteout(fi) {
+			break
+		}
+
+		if !fi.IsDir() {
+			if node == nil {
+				node = &overlayNode{name: name, info: fi, top: lp, data: lp}
+				// the data of a metacopy file is in the lower layers, at its redirect path if it was renamed.
+				if fi.Mode().IsRegular() && v.hasXattr(real, "metacopy") {
+					metacopy = true
+					if redirect, ok := v.redirect(real); ok {
+						lookupName, absPath = redirectTarget(lookupName, absPath, redirect)
+					}
+					continue
+				}
+			} else if metacopy && fi.Mode().IsRegular() {
+				node.data = lp
+			}
+			break
+		}
+
+		if node == nil {
+			node = &overlayNode{name: name, info: fi, top: lp}
+		} else if node.dirs == nil {
+			// a directory below a non-directory is hidden.
+			break
+		}
+		node.dirs = append(node.dirs, lp)
+
+		if v.isOpaque(real) {
+			break
+		}
+		if redirect, ok := v.redirect(real); ok {
+			lookupName, absPath = redirectTarget(lookupName, absPath, redirect)
+		}
+	}
+
+	if node == nil {
+		return nil, fs.ErrNotExist
+	}
+	return node, nil
+}
+
+// redirectTarget returns the name and the absolute path looked up in the lower layers after a redirect,
+// which is either a path from the root of the layers or a name in the same parent dir.
+func redirectTarget(name, absPath, redirect string) (string, string) {
+	if strings.HasPrefix(redirect, "/") {
+		return name, strings.TrimPrefix(path.Clean(redirect), "/")
+	}
+	if absPath != "" {
+		return name, path.Join(path.Dir(absPath), redirect)
+	}
+	return redirect, ""
+}
+
+func (v *OverlayView) isOpaque(real string) bool {
+	value, ok := overlayXattr(real, v.xattrPrefix+"opaque")
+	return ok && value == "y"
+}
+
+func (v *OverlayView) redirect(real string) (string, bool) {
+	if !v.followRedirect {
+		return "", false
+	}
+	value, ok := overlayXattr(real, v.xattrPrefix+"redirect")
+	return value, ok && value != ""
+}
+
+func (v *OverlayView) hasXattr(real, name string) bool {
+	_, ok := overlayXattr(real, v.xattrPrefix+name)
+	return ok
+}
+
+// resolve resolves the path name of the view, following the symbolic links inside the view,
+// except the last component if followLast is false.
+func (v *OverlayView) resolve(name string, followLast bool) (*overlayNode, error) {
+	root, err := v.root()
+	if err != nil {
+		return nil, err
+	}
+
+	// the nodes from the root to the current directory, ".." pops them, stopping at the root.
+	nodes := []*overlayNode{root}
+	parts := splitViewPath(name)
+	links := 0
+
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+
+		switch part {
+		case ".":
+			continue
+		case "..":
+			if len(nodes) > 1 {
+				nodes = nodes[:len(nodes)-1]
+			}
+			continue
+		}
+
+		dir := nodes[len(nodes)-1]
+		if dir.dirs == nil {
+			return nil, syscall.ENOTDIR
+		}
+
+		node, err := v.lookup(dir, part)
+		if err != nil {
+			return nil, err
+		}
+
+		if node.info.Mode()&fs.ModeSymlink != 0 && (len(parts) > 0 || followLast) {
+			links++
+			if links > overlayMaxSymlinks {
+				return nil, syscall.ELOOP
+			}
+
+			target, err := os.Readlink(v.realPath(node.data))
+			if err != nil {
+				return nil, err
+			}
+			if path.IsAbs(target) {
+				nodes = nodes[:1]
+			}
+			parts = append(splitViewPath(target), parts...)
+			continue
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes[len(nodes)-1], nil
+}
+
+func splitViewPath(name string) []string {
+	var parts []string
+	for _, part := range strings.Split(name, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+func (v *OverlayView) resolveValid(op, name string, followLast bool) (*overlayNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	node, err := v.resolve(name, followLast)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	// the name of the view, not the one in the layer which may differ after a redirect.
+	node.name = path.Base(name)
+	return node, nil
+}
+
+// Open opens the named file of the view. Directories implement fs.ReadDirFile.
+func (v *OverlayView) Open(name string) (fs.File, error) {
+	node, err := v.resolveValid("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	info := overlayFileInfo{FileInfo: node.info, name: node.name}
+
+	if node.dirs != nil {
+		return &overlayDir{view: v, node: node, info: info}, nil
+	}
+
+	if !node.info.Mode().IsRegular() {
+		// devices, fifos and sockets are not opened, only their information is available.
+		return &overlaySpecialFile{info: info}, nil
+	}
+
+	f, err := os.Open(v.realPath(node.data))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.Unwrap(err)}
+	}
+	return &overlayFile{File: f, info: info}, nil
+}
+
+// Stat returns the information of the named file of the view, following symbolic links.
+func (v *OverlayView) Stat(name string) (fs.FileInfo, error) {
+	node, err := v.resolveValid("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return overlayFileInfo{FileInfo: node.info, name: node.name}, nil
+}
+
+// Lstat returns the information of the named file of the view, without following a symbolic link.
+func (v *OverlayView) Lstat(name string) (fs.FileInfo, error) {
+	node, err := v.resolveValid("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return overlayFileInfo{FileInfo: node.info, name: node.name}, nil
+}
+
+// ReadLink returns the target of the named symbolic link of the view.
+func (v *OverlayView) ReadLink(name string) (string, error) {
+	node, err := v.resolveValid("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if node.info.Mode()&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.Readlink(v.realPath(node.data))
+}
+
+// ReadDir reads the named directory of the view and returns its entries sorted by name.
+func (v *OverlayView) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, err := v.resolveValid("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if node.dirs == nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	entries, err := v.readDir(node)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+// RealPath returns the path of the uppermost entry of the named file of the view in the layers,
+// without following a symbolic link, and the index of its layer, the upper dir being the first if any.
+func (v *OverlayView) RealPath(name string) (string, int, error) {
+	node, err := v.resolveValid("realpath", name, false)
+	if err != nil {
+		return "", 0, err
+	}
+	return v.realPath(node.top), node.top.layer, nil
+}
+
+// readDir merges the entries of the dirs of the node. The entries of the upper dirs hide the ones
+// of the lower dirs with the same name, whiteouts included.
+func (v *OverlayView) readDir(node *overlayNode) ([]fs.DirEntry, error) {
+	seen := map[string]bool{}
+	var entries []fs.DirEntry
+
+	for _, dir := range node.dirs {
+		dirEntries, err := os.ReadDir(v.realPath(dir))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range dirEntries {
+			if seen[entry.Name()] {
+				continue
+			}
+			seen[entry.Name()] = true
+
+			if entry.Type()&fs.ModeCharDevice != 0 {
+				info, err := entry.Info()
+				if err == nil && isOverlayWhiteout(info) {
+					continue
+				}
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+// overlayFileInfo is the information of a file of the view, with the name in the view.
+type overlayFileInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (fi overlayFileInfo) Name() string {
+	return fi.name
+}
+
+type overlayFile struct {
+	*os.File
+	info fs.FileInfo
+}
+
+func (f *overlayFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+type overlaySpecialFile struct {
+	info fs.FileInfo
+}
+
+func (f *overlaySpecialFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *overlaySpecialFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: f.info.Name(), Err: fs.ErrInvalid}
+}
+
+func (f *overlaySpecialFile) Close() error {
+	return nil
+}
+
+type overlayDir struct {
+	view *OverlayView
+	node *overlayNode
+	info fs.FileInfo
+
+	entries []fs.DirEntry
+	read    bool
+	offset  int
+}
+
+func (d *overlayDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *overlayDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: syscall.EISDIR}
+}
+
+func (d *overlayDir) Close() error {
+	return nil
+}
+
+func (d *overlayDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.view.readDir(d.node)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.info.Name(), Err: err}
+		}
+		d.entries, d.read = entries, true
+	}
+
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
@@ -0,0 +1,47 @@
+package container
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// isOverlayWhiteout tells if the file is a whiteout, a character device with 0/0 device number.
+func isOverlayWhiteout(fi fs.FileInfo) bool {
+	if fi.Mode()&fs.ModeCharDevice == 0 {
+		return false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && st.Rdev == 0
+}
+
+// overlayXattr returns the value of the extended attribute of the file, without following a symbolic link.
+func overlayXattr(path, name string) (string, bool) {
+	buf := make([]byte, 256)
+	for {
+		n, err := unix.Lgetxattr(path, name, buf)
+		if errors.Is(err, unix.ERANGE) {
+			size, err := unix.Lgetxattr(path, name, nil)
+			if err != nil {
+				return "", false
+			}
+			buf = make([]byte, size)
+			continue
+		}
+		if err != nil {
+			return "", false
+		}
+		return string(buf[:n]), true
+	}
+}
+
+// fileOwner returns the uid and gid of the file.
+func fileOwner(fi fs.FileInfo) (int, int) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return int(st.Uid), int(st.Gid)
+}
@@ -0,0 +1,193 @@
+package container
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// newTestOverlayLayers creates two lower dirs and an upper dir, mounts them with redirect_dir and metacopy,
+// and changes the merged dir so that the upper dir has whiteouts, opaque dirs, redirects and metacopy files.
+// It returns the options and the merged dir, which stays mounted until the end of the test.
+func newTestOverlayLayers(t *testing.T) (OverlayOptions, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	lower1, lower2 := filepath.Join(dir, "lower1"), filepath.Join(dir, "lower2")
+
+	writeFiles := func(root string, files map[string]string) {
+		for name, content := range files {
+			p := filepath.Join(root, name)
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if strings.HasPrefix(content, "->") {
+				if err := os.Symlink(strings.TrimPrefix(content, "->"), p); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// lower2 is the lowest layer
+	writeFiles(lower2, map[string]string{
+		"etc/passwd":          "root:x:0:0::/root:/bin/sh\n",
+		"etc/hosts":           "127.0.0.1 localhost\n",
+		"usr/bin/tool":        "tool v1",
+		"usr/share/doc/a.txt": "a",
+		"usr/share/doc/b.txt": "b",
+		"var/cache/x/1":       "1",
+		"opt/app/config":      "config",
+		"shadowed":            "a file hidden by a dir",
+	})
+	writeFiles(lower1, map[string]string{
+		"usr/bin/tool":      "tool v2",
+		"usr/share/doc/c":   "c",
+		"shadowed/file":     "in a dir",
+		"data/big":          strings.Repeat("x", 1<<16),
+		"link-abs":          "->/etc/hosts",
+		"link-rel":          "->usr/bin/../bin/tool",
+		"usr/lib/escape":    "->../../../../../../../etc/passwd",
+		"var/cache/x/2":     "2",
+		"opt/app/data/file": "data",
+	})
+
+	opts := newTestOverlayDirs(t)
+	opts.LowerDir = lower1 + ":" + lower2
+	opts.RedirectDir = "on"
+	opts.Metacopy = OverlayOn
+
+	fs, err := NewOverlayFSWithOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mount(false); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fs.Unmount() })
+
+	merged := opts.MergedDir
+	steps := []func() error{
+		// whiteouts
+		func() error { return os.Remove(filepath.Join(merged, "etc/hosts")) },
+		func() error { return os.Remove(filepath.Join(merged, "usr/share/doc/a.txt")) },
+		// opaque dir
+		func() error { return os.RemoveAll(filepath.Join(merged, "var/cache/x")) },
+		func() error { return os.Mkdir(filepath.Join(merged, "var/cache/x"), 0700) },
+		func() error { return os.WriteFile(filepath.Join(merged, "var/cache/x/3"), []byte("3"), 0644) },
+		// redirect dir
+		func() error { return os.Rename(filepath.Join(merged, "opt/app"), filepath.Join(merged, "opt/renamed")) },
+		func() error { return os.Rename(filepath.Join(merged, "usr/share/doc"), filepath.Join(merged, "doc")) },
+		// copy up
+		func() error { return os.WriteFile(filepath.Join(merged, "usr/bin/tool"), []byte("tool v3"), 0755) },
+		// metacopy
+		func() error { return os.Chmod(filepath.Join(merged, "data/big"), 0600) },
+		// new files
+		func() error { return os.WriteFile(filepath.Join(merged, "etc/new"), []byte("new"), 0644) },
+		func() error { return os.Symlink("/doc/c", filepath.Join(merged, "link-doc")) },
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("step %d: %s", i, err)
+		}
+	}
+
+	return opts, merged
+}
+
+func TestOverlayView(t *testing.T) {
+	requireRoot(t)
+
+	opts, merged := newTestOverlayLayers(t)
+	view := NewOverlayViewWithOptions(opts)
+
+	if _, ok := overlayXattr(filepath.Join(opts.UpperDir, "data/big"), "trusted.overlay.metacopy"); !ok {
+		t.Log("the kernel did not create a metacopy file")
+	}
+	if _, ok := overlayXattr(filepath.Join(opts.UpperDir, "opt/renamed"), "trusted.overlay.redirect"); !ok {
+		t.Error("the kernel did not create a redirect dir")
+	}
+
+	// the view has the same entries as the kernel mount
+	kernel := os.DirFS(merged)
+	var kernelPaths, viewPaths []string
+	walk := func(fsys fs.FS, paths *[]string) {
+		err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			*paths = append(*paths, p+":"+d.Type().String())
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	walk(kernel, &kernelPaths)
+	walk(view, &viewPaths)
+	if strings.Join(kernelPaths, "\n") != strings.Join(viewPaths, "\n") {
+		t.Fatalf("view:\n%s\nkernel:\n%s", strings.Join(viewPaths, "\n"), strings.Join(kernelPaths, "\n"))
+	}
+
+	// with the same metadata and content
+	for _, entry := range kernelPaths {
+		p, _, _ := strings.Cut(entry, ":")
+		kfi, err := os.Lstat(filepath.Join(merged, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		vfi, err := view.Lstat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kfi.Mode() != vfi.Mode() || (kfi.Mode().IsRegular() && kfi.Size() != vfi.Size()) || (p != "." && kfi.Name() != vfi.Name()) {
+			t.Errorf("%s: view %s %d %s, kernel %s %d %s", p, vfi.Mode(), vfi.Size(), vfi.Name(), kfi.Mode(), kfi.Size(), kfi.Name())
+		}
+
+		switch {
+		case kfi.Mode().IsRegular():
+			kdata, _ := os.ReadFile(filepath.Join(merged, p))
+			vdata, err := fs.ReadFile(view, p)
+			if err != nil || !bytes.Equal(kdata, vdata) {
+				t.Errorf("%s: unexpected content %q, err: %v", p, vdata, err)
+			}
+		case kfi.Mode()&fs.ModeSymlink != 0:
+			ktarget, _ := os.Readlink(filepath.Join(merged, p))
+			vtarget, err := view.ReadLink(p)
+			if err != nil || ktarget != vtarget {
+				t.Errorf("%s: unexpected link target %q, err: %v", p, vtarget, err)
+			}
+		}
+	}
+
+	// symbolic links are resolved inside the view
+	for name, expected := range map[string]string{
+		"link-rel":       "tool v3",
+		"link-doc":       "c",
+		"usr/lib/escape": "root:x:0:0::/root:/bin/sh\n",
+	} {
+		data, err := fs.ReadFile(view, name)
+		if err != nil || string(data) != expected {
+			t.Errorf("%s: unexpected content %q, err: %v", name, data, err)
+		}
+	}
+	if _, err := view.Stat("link-abs"); err == nil {
+		t.Error("link to a deleted file resolved")
+	}
+
+	// the checks of fstest open all the files, the dangling link is removed first.
+	if err := os.Remove(filepath.Join(merged, "link-abs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(view, "etc/passwd", "opt/renamed/data/file", "var/cache/x/3", "doc/b.txt"); err != nil {
+		t.Error(err)
+	}
+}
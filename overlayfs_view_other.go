@@ -0,0 +1,19 @@
+//go:build !linux
+
+package container
+
+import (
+	"io/fs"
+)
+
+func isOverlayWhiteout(fi fs.FileInfo) bool {
+	return false
+}
+
+func overlayXattr(path, name string) (string, bool) {
+	return "", false
+}
+
+func fileOwner(fi fs.FileInfo) (int, int) {
+	return 0, 0
+}
@@ -0,0 +1,141 @@
+package container
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// The link types of the captured packets, see https://www.tcpdump.org/linktypes.html
+const (
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+)
+
+// packetWriter writes captured packets in a capture file format.
+type packetWriter interface {
+	writePacket(ts time.Time, data []byte, origLen int) error
+}
+
+// newPacketWriter writes the file header of the format to w and returns the writer of the packets.
+func newPacketWriter(w io.Writer, format CaptureFormat, linkType int, snaplen int, iface string) (packetWriter, error) {
+	switch format {
+	case "", CaptureFormatPcap:
+		return newPcapWriter(w, linkType, snaplen)
+	case CaptureFormatPcapNG:
+		return newPcapNGWriter(w, linkType, snaplen, iface)
+	default:
+		return nil, fmt.Errorf("unknown capture format: (%s)", format)
+	}
+}
+
+// pcapWriter writes the classic pcap format with microsecond timestamps.
+type pcapWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func newPcapWriter(w io.Writer, linkType int, snaplen int) (*pcapWriter, error) {
+	header := make([]byte, 0, 24)
+	header = binary.LittleEndian.AppendUint32(header, 0xa1b2c3d4)
+	header = binary.LittleEndian.AppendUint16(header, 2)
+	header = binary.LittleEndian.AppendUint16(header, 4)
+	header = binary.LittleEndian.AppendUint32(header, 0) // thiszone
+	header = binary.LittleEndian.AppendUint32(header, 0) // sigfigs
+	header = binary.LittleEndian.AppendUint32(header, uint32(snaplen))
+	header = binary.LittleEndian.AppendUint32(header, uint32(linkType))
+
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("write pcap header failed, err: %s", err)
+	}
+
+	return &pcapWriter{w: w}, nil
+}
+
+func (pw *pcapWriter) writePacket(ts time.Time, data []byte, origLen int) error {
+	pw.buf = pw.buf[:0]
+	pw.buf = binary.LittleEndian.AppendUint32(pw.buf, uint32(ts.Unix()))
+	pw.buf = binary.LittleEndian.AppendUint32(pw.buf, uint32(ts.Nanosecond()/1000))
+	pw.buf = binary.LittleEndian.AppendUint32(pw.buf, uint32(len(data)))
+	pw.buf = binary.LittleEndian.AppendUint32(pw.buf, uint32(origLen))
+	pw.buf = append(pw.buf, data...)
+
+	_, err := pw.w.Write(pw.buf)
+	return err
+}
+
+// pcapngWriter writes a pcapng section with a single interface and microsecond timestamps.
+type pcapngWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func newPcapNGWriter(w io.Writer, linkType int, snaplen int, iface string) (*pcapngWriter, error) {
+	// section header block
+	shb := make([]byte, 0, 16)
+	shb = binary.LittleEndian.AppendUint32(shb, 0x1a2b3c4d)
+	shb = binary.LittleEndian.AppendUint16(shb, 1)
+	shb = binary.LittleEndian.AppendUint16(shb, 0)
+	shb = binary.LittleEndian.AppendUint64(shb, 0xffffffffffffffff) // unknown section length
+
+	// interface description block, with the if_name option
+	idb := make([]byte, 0, 32)
+	idb = binary.LittleEndian.AppendUint16(idb, uint16(linkType))
+	idb = binary.LittleEndian.AppendUint16(idb, 0)
+	idb = binary.LittleEndian.AppendUint32(idb, uint32(snaplen))
+	if iface != "" {
+		idb = appendPcapNGOption(idb, 2, []byte(iface))
+		idb = appendPcapNGOption(idb, 0, nil)
+	}
+
+	var header []byte
+	header = appendPcapNGBlock(header, 0x0a0d0d0a, shb)
+	header = appendPcapNGBlock(header, 0x00000001, idb)
+
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("write pcapng header failed, err: %s", err)
+	}
+
+	return &pcapngWriter{w: w}, nil
+}
+
+func (pw *pcapngWriter) writePacket(ts time.Time, data []byte, origLen int) error {
+	micros := uint64(ts.UnixMicro())
+
+	// enhanced packet block
+	epb := make([]byte, 0, 20+len(data)+3)
+	epb = binary.LittleEndian.AppendUint32(epb, 0) // interface id
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(micros>>32))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(micros))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(data)))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(origLen))
+	epb = append(epb, data...)
+
+	pw.buf = appendPcapNGBlock(pw.buf[:0], 0x00000006, epb)
+
+	_, err := pw.w.Write(pw.buf)
+	return err
+}
+
+// appendPcapNGBlock appends a block with the body padded to 32 bits.
+func appendPcapNGBlock(b []byte, blockType uint32, body []byte) []byte {
+	padding := (4 - len(body)%4) % 4
+	total := uint32(12 + len(body) + padding)
+
+	b = binary.LittleEndian.AppendUint32(b, blockType)
+	b = binary.LittleEndian.AppendUint32(b, total)
+	b = append(b, body...)
+	b = append(b, make([]byte, padding)...)
+	b = binary.LittleEndian.AppendUint32(b, total)
+
+	return b
+}
+
+// appendPcapNGOption appends an option with the value padded to 32 bits, code 0 ends the options.
+func appendPcapNGOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return append(b, make([]byte, (4-len(value)%4)%4)...)
+}
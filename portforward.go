@@ -0,0 +1,104 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// portForward accepts TCP connections on l and forwards each of them to 127.0.0.1:port inside the
+// network namespace at netnsPath, until ctx is done. l is closed when portForward returns.
+func portForward(ctx context.Context, l net.Listener, netnsPath string, port int) error {
+	if port <= 0 || port > 65535 {
+		l.Close()
+		return fmt.Errorf("invalid port: (%d)", port)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stop := context.AfterFunc(ctx, func() {
+		l.Close()
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			l.Close()
+			return fmt.Errorf("accept failed, err: %s", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// a connection which can not be forwarded is closed, so that the client sees the failure.
+			forwardConn(ctx, conn, netnsPath, port)
+		}()
+	}
+}
+
+// forwardConn dials 127.0.0.1:port inside the network namespace at netnsPath and splices it with conn.
+func forwardConn(ctx context.Context, conn net.Conn, netnsPath string, port int) error {
+	defer conn.Close()
+
+	var upstream net.Conn
+
+	// the socket is created by the thread inside the netns and stays there after the thread leaves.
+	err := doInNamespaces(ctx, map[NamespaceType]string{NamespaceNet: netnsPath}, func() error {
+		var d net.Dialer
+		var err error
+		upstream, err = d.DialContext(ctx, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("dial inside ns failed, err: %s", err)
+	}
+	defer upstream.Close()
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+		upstream.Close()
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		spliceHalf(upstream, conn)
+	}()
+	go func() {
+		defer wg.Done()
+		spliceHalf(conn, upstream)
+	}()
+	wg.Wait()
+
+	return nil
+}
+
+// spliceHalf copies src to dst, then half-closes dst, so that the peer sees the EOF of src
+// while the other direction keeps flowing.
+func spliceHalf(dst, src net.Conn) {
+	io.Copy(dst, src)
+
+	if c, ok := dst.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+	} else {
+		dst.Close()
+	}
+}
@@ -0,0 +1,99 @@
+package container
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func Test_portForward(t *testing.T) {
+	requireRoot(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	netnsPath := newTestNetNS(t, "gcu-test-portfwd")
+
+	// an echo server listening on localhost only inside the netns
+	var upstream net.Listener
+	err := doInNamespaces(ctx, map[NamespaceType]string{NamespaceNet: netnsPath}, func() error {
+		lo, err := netlink.LinkByName("lo")
+		if err != nil {
+			return err
+		}
+		if err := netlink.LinkSetUp(lo); err != nil {
+			return err
+		}
+		upstream, err = net.Listen("tcp", "127.0.0.1:0")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- portForward(ctx, l, netnsPath, upstream.Addr().(*net.TCPAddr).Port)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+
+			msg := fmt.Sprintf("hello %d\n", i)
+			if _, err := conn.Write([]byte(msg)); err != nil {
+				t.Error(err)
+				return
+			}
+			conn.(*net.TCPConn).CloseWrite()
+
+			got, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil || got != msg {
+				t.Errorf("got %q, err: %v, expected %q", got, err, msg)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("portForward returned %v", err)
+	}
+	if _, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		t.Errorf("listener still accepts after cancel")
+	}
+}
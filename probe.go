@@ -0,0 +1,369 @@
+package container
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type ProbeKind string
+
+const (
+	// ProbeTCP connects to Target, eg: 10.96.0.1:443 or kubernetes.default:443
+	ProbeTCP ProbeKind = "tcp"
+
+	// ProbeUDP sends Payload to Target and waits for an ICMP error or a reply until the timeout.
+	// Without any of them, the port is considered open (or filtered) and the probe succeeds.
+	ProbeUDP ProbeKind = "udp"
+
+	// ProbeHTTP sends a GET request to the url Target, without following redirects.
+	ProbeHTTP ProbeKind = "http"
+
+	// ProbeDNS looks up the name Target.
+	ProbeDNS ProbeKind = "dns"
+)
+
+// DefaultProbeTimeout is used when ProbeSpec.Timeout is 0.
+const DefaultProbeTimeout = 5 * time.Second
+
+// ProbeSpec describes a connectivity check run from inside the network namespace of a container.
+// Names are resolved with the nameservers, search domains and options of the container's resolv.conf.
+type ProbeSpec struct {
+	Kind   ProbeKind `json:"kind"`
+	Target string    `json:"target"`
+
+	Timeout time.Duration `json:"timeout,omitempty"`
+
+	// Payload is the datagram sent by a udp probe.
+	Payload string `json:"payload,omitempty"`
+
+	// RecordType is the record type looked up by a dns probe, A or AAAA, both if empty.
+	RecordType string `json:"recordType,omitempty"`
+
+	// InsecureSkipVerify disables the verification of the server certificate of an https probe.
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+type ProbeErrorClass string
+
+const (
+	ProbeErrorTimeout     ProbeErrorClass = "timeout"
+	ProbeErrorRefused     ProbeErrorClass = "refused"
+	ProbeErrorUnreachable ProbeErrorClass = "unreachable"
+	ProbeErrorReset       ProbeErrorClass = "reset"
+
+	// ProbeErrorNXDomain means the name does not exist, ProbeErrorNoRecords that it exists
+	// without records of the requested type, ProbeErrorDNS any other failure of the nameservers.
+	ProbeErrorNXDomain  ProbeErrorClass = "nxdomain"
+	ProbeErrorNoRecords ProbeErrorClass = "no-records"
+	ProbeErrorDNS       ProbeErrorClass = "dns"
+
+	ProbeErrorTLS        ProbeErrorClass = "tls"
+	ProbeErrorHTTPStatus ProbeErrorClass = "http-status"
+	ProbeErrorOther      ProbeErrorClass = "other"
+)
+
+// ProbeResult is the outcome of a probe. A failed probe is reported by Success, ErrorClass and Error,
+// not by the error returned along with the result.
+type ProbeResult struct {
+	Success bool `json:"success"`
+
+	// Latency is the duration of the whole probe, including the name resolution.
+	Latency time.Duration `json:"latency"`
+
+	// Addresses are the addresses the target name resolved to.
+	Addresses []string `json:"addresses,omitempty"`
+
+	// RemoteAddr is the address which was connected to, for tcp, udp and http probes.
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+
+	// Reply is true if a udp probe received a reply.
+	Reply bool `json:"reply,omitempty"`
+
+	StatusCode int `json:"statusCode,omitempty"`
+
+	ErrorClass ProbeErrorClass `json:"errorClass,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// probeError is an error with a known class.
+type probeError struct {
+	class ProbeErrorClass
+	msg   string
+}
+
+func (e *probeError) Error() string {
+	return e.msg
+}
+
+// classifyProbeError returns the class of an error encountered by a probe.
+func classifyProbeError(err error) ProbeErrorClass {
+	var pe *probeError
+	if errors.As(err, &pe) {
+		return pe.class
+	}
+
+	var ne net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		return ProbeErrorTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ProbeErrorRefused
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return ProbeErrorUnreachable
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return ProbeErrorReset
+	}
+
+	var recordHeaderErr tls.RecordHeaderError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &recordHeaderErr) || errors.As(err, &certErr) ||
+		errors.As(err, &unknownAuthorityErr) || errors.As(err, &hostnameErr) {
+		return ProbeErrorTLS
+	}
+
+	return ProbeErrorOther
+}
+
+// netnsDial dials address from a socket created inside the network namespace at netnsPath.
+// It can be called from any goroutine, eg: the dialing goroutines of an http.Transport.
+func netnsDial(ctx context.Context, netnsPath string, network, address string) (net.Conn, error) {
+	var conn net.Conn
+
+	err := doInNamespaces(ctx, map[NamespaceType]string{NamespaceNet: netnsPath}, func() error {
+		var d net.Dialer
+		var err error
+		conn, err = d.DialContext(ctx, network, address)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// prober runs probes inside a network namespace, resolving names with a resolv.conf.
+type prober struct {
+	netnsPath string
+	resolver  *dnsResolver
+
+	// mu guards the fields below, which may be written by the dialing goroutines of an http.Transport
+	// after the probe timed out.
+	mu sync.Mutex
+
+	// addresses are the resolved addresses of the target, remoteAddr the last address connected to.
+	addresses  []string
+	remoteAddr string
+}
+
+// probe runs the probe described by spec inside the network namespace at netnsPath.
+// resolvConf is the content of the container's resolv.conf.
+func probe(ctx context.Context, netnsPath string, resolvConf []byte, spec ProbeSpec) (*ProbeResult, error) {
+	timeout := spec.Timeout
+	if timeout == 0 {
+		timeout = DefaultProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	p := &prober{netnsPath: netnsPath}
+	p.resolver = &dnsResolver{
+		conf: parseResolvConf(resolvConf),
+		dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return netnsDial(ctx, netnsPath, network, address)
+		},
+	}
+
+	result := &ProbeResult{}
+	start := time.Now()
+
+	var err error
+	switch spec.Kind {
+	case ProbeTCP:
+		err = p.probeTCP(ctx, spec, result)
+	case ProbeUDP:
+		err = p.probeUDP(ctx, spec, result)
+	case ProbeHTTP:
+		err = p.probeHTTP(ctx, spec, result)
+	case ProbeDNS:
+		err = p.probeDNS(ctx, spec, result)
+	default:
+		return nil, fmt.Errorf("unknown probe kind: (%s)", spec.Kind)
+	}
+
+	result.Latency = time.Since(start)
+
+	p.mu.Lock()
+	result.Addresses = p.addresses
+	result.RemoteAddr = p.remoteAddr
+	p.mu.Unlock()
+
+	if err != nil {
+		var invalid *invalidProbeError
+		if errors.As(err, &invalid) {
+			return nil, invalid.err
+		}
+		result.ErrorClass = classifyProbeError(err)
+		result.Error = err.Error()
+		return result, nil
+	}
+
+	result.Success = true
+	return result, nil
+}
+
+// invalidProbeError wraps an error in the spec, which is returned instead of a failed result.
+type invalidProbeError struct {
+	err error
+}
+
+func (e *invalidProbeError) Error() string {
+	return e.err.Error()
+}
+
+// dial resolves the host of address with the container's resolv.conf and connects
+// to the resolved addresses in turn, from inside the network namespace.
+func (p *prober) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, &invalidProbeError{fmt.Errorf("invalid target (%s), err: %s", address, err)}
+	}
+
+	ips := []string{host}
+	if net.ParseIP(host) == nil {
+		if ips, err = p.resolver.lookup(ctx, host, nil); err != nil {
+			return nil, err
+		}
+		p.mu.Lock()
+		p.addresses = append(p.addresses, ips...)
+		p.mu.Unlock()
+	}
+
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := netnsDial(ctx, p.netnsPath, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			p.mu.Lock()
+			p.remoteAddr = conn.RemoteAddr().String()
+			p.mu.Unlock()
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, lastErr
+}
+
+func (p *prober) probeTCP(ctx context.Context, spec ProbeSpec, result *ProbeResult) error {
+	conn, err := p.dial(ctx, "tcp", spec.Target)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+
+	return nil
+}
+
+func (p *prober) probeUDP(ctx context.Context, spec ProbeSpec, result *ProbeResult) error {
+	conn, err := p.dial(ctx, "udp", spec.Target)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(spec.Payload)); err != nil {
+		return err
+	}
+
+	deadline, _ := ctx.Deadline()
+	conn.SetReadDeadline(deadline)
+
+	// an ICMP port unreachable is reported as ECONNREFUSED by the read on the connected socket.
+	buf := make([]byte, 1)
+	if _, err := conn.Read(buf); err != nil {
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			return nil
+		}
+		return err
+	}
+
+	result.Reply = true
+	return nil
+}
+
+func (p *prober) probeHTTP(ctx context.Context, spec ProbeSpec, result *ProbeResult) error {
+	u, err := url.Parse(spec.Target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return &invalidProbeError{fmt.Errorf("invalid url (%s)", spec.Target)}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, spec.Target, nil)
+	if err != nil {
+		return &invalidProbeError{err}
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext:       p.dial,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: spec.InsecureSkipVerify},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var invalid *invalidProbeError
+		if errors.As(err, &invalid) {
+			return invalid
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 400 {
+		return &probeError{class: ProbeErrorHTTPStatus, msg: fmt.Sprintf("unexpected status: %s", resp.Status)}
+	}
+
+	return nil
+}
+
+func (p *prober) probeDNS(ctx context.Context, spec ProbeSpec, result *ProbeResult) error {
+	var qtypes []string
+	switch spec.RecordType {
+	case "":
+	case "A", "AAAA":
+		qtypes = []string{spec.RecordType}
+	default:
+		return &invalidProbeError{fmt.Errorf("unknown record type: (%s)", spec.RecordType)}
+	}
+
+	addrs, err := p.resolver.lookup(ctx, spec.Target, qtypes)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.addresses = addrs
+	p.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package container
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+func Test_probe(t *testing.T) {
+	requireRoot(t)
+
+	ctx := context.Background()
+	netnsPath := newTestNetNS(t, "gcu-test-probe")
+
+	var dnsConn net.PacketConn
+	var tcpListener, httpListener, closedListener net.Listener
+	err := doInNamespaces(ctx, map[NamespaceType]string{NamespaceNet: netnsPath}, func() error {
+		lo, err := netlink.LinkByName("lo")
+		if err != nil {
+			return err
+		}
+		if err := netlink.LinkSetUp(lo); err != nil {
+			return err
+		}
+		if dnsConn, err = net.ListenPacket("udp", "127.0.0.1:53"); err != nil {
+			return err
+		}
+		if tcpListener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			return err
+		}
+		if httpListener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			return err
+		}
+		closedListener, err = net.Listen("tcp", "127.0.0.1:0")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dnsConn.Close()
+	defer tcpListener.Close()
+	defer httpListener.Close()
+
+	// a port which refuses connections
+	closedAddr := closedListener.Addr().String()
+	closedPort := closedListener.Addr().(*net.TCPAddr).Port
+	closedListener.Close()
+
+	go serveTestDNS(dnsConn)
+	go http.Serve(httpListener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	resolvConf := []byte("nameserver 127.0.0.1\nsearch test\noptions ndots:1 timeout:1 attempts:1\n")
+	_, tcpPort, _ := net.SplitHostPort(tcpListener.Addr().String())
+	_, httpPort, _ := net.SplitHostPort(httpListener.Addr().String())
+
+	tests := []struct {
+		spec       ProbeSpec
+		success    bool
+		class      ProbeErrorClass
+		addresses  []string
+		statusCode int
+	}{
+		{spec: ProbeSpec{Kind: ProbeDNS, Target: "svc"}, success: true, addresses: []string{"127.0.0.1"}},
+		{spec: ProbeSpec{Kind: ProbeDNS, Target: "svc", RecordType: "AAAA"}, class: ProbeErrorNoRecords},
+		{spec: ProbeSpec{Kind: ProbeDNS, Target: "missing"}, class: ProbeErrorNXDomain},
+		{spec: ProbeSpec{Kind: ProbeTCP, Target: "svc:" + tcpPort}, success: true, addresses: []string{"127.0.0.1"}},
+		{spec: ProbeSpec{Kind: ProbeTCP, Target: closedAddr}, class: ProbeErrorRefused},
+		{spec: ProbeSpec{Kind: ProbeTCP, Target: "192.0.2.1:80"}, class: ProbeErrorUnreachable},
+		{spec: ProbeSpec{Kind: ProbeUDP, Target: net.JoinHostPort("127.0.0.1", "53"), Timeout: 200 * time.Millisecond}, success: true},
+		{spec: ProbeSpec{Kind: ProbeUDP, Target: net.JoinHostPort("127.0.0.1", strconv.Itoa(closedPort))}, class: ProbeErrorRefused},
+		{spec: ProbeSpec{Kind: ProbeHTTP, Target: "http://svc:" + httpPort + "/healthz"}, success: true, addresses: []string{"127.0.0.1"}, statusCode: 200},
+		{spec: ProbeSpec{Kind: ProbeHTTP, Target: "http://127.0.0.1:" + httpPort + "/missing"}, class: ProbeErrorHTTPStatus, statusCode: 404},
+	}
+
+	for _, tt := range tests {
+		result, err := probe(ctx, netnsPath, resolvConf, tt.spec)
+		if err != nil {
+			t.Errorf("probe %+v failed, err: %s", tt.spec, err)
+			continue
+		}
+		if result.Success != tt.success || result.ErrorClass != tt.class || result.StatusCode != tt.statusCode ||
+			!reflect.DeepEqual(result.Addresses, tt.addresses) {
+			t.Errorf("probe %+v: unexpected result %+v", tt.spec, result)
+		}
+	}
+
+	if _, err := probe(ctx, netnsPath, resolvConf, ProbeSpec{Kind: ProbeTCP, Target: "no-port"}); err == nil {
+		t.Errorf("expected an error for an invalid target")
+	}
+}
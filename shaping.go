@@ -0,0 +1,32 @@
+package container
+
+import "time"
+
+type ShapingDirection string
+
+const (
+	// ShapingEgress shapes the traffic sent by the container, on its interface inside the container netns.
+	ShapingEgress ShapingDirection = "egress"
+
+	// ShapingIngress shapes the traffic received by the container, on the host-side peer of its interface.
+	ShapingIngress ShapingDirection = "ingress"
+
+	// ShapingBoth shapes the traffic in both directions.
+	ShapingBoth ShapingDirection = "both"
+)
+
+// Shaping describes the latency, loss and bandwidth limit applied to the traffic of a container interface.
+// Delay, Jitter and Loss are implemented with a netem qdisc, Rate with a tbf qdisc.
+type Shaping struct {
+	Delay  time.Duration `json:"delay,omitempty"`
+	Jitter time.Duration `json:"jitter,omitempty"`
+
+	// Loss is the packet loss in percent, eg: 0.5
+	Loss float64 `json:"loss,omitempty"`
+
+	// Rate is the bandwidth limit in bits per second, no limit if 0.
+	Rate uint64 `json:"rate,omitempty"`
+
+	// Direction is from the point of view of the container, egress if empty.
+	Direction ShapingDirection `json:"direction,omitempty"`
+}
@@ -0,0 +1,238 @@
+package container
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// The qdiscs installed by shapeTraffic are identified by these handles, so that clearShaping
+// never removes a qdisc installed by someone else.
+var (
+	shapingRootHandle  = netlink.MakeHandle(0xc0de, 0)
+	shapingNetemHandle = netlink.MakeHandle(0xc0df, 0)
+)
+
+// shapeTraffic installs the qdiscs described by shaping on the interface iface inside the network namespace
+// at netnsPath for egress, and on its host-side veth peer found in mappings for ingress.
+// A previous shaping installed by shapeTraffic is replaced in both directions: the direction not shaped anymore
+// is cleared. If the ingress can not be shaped, the egress shaping installed by this call is removed.
+func shapeTraffic(ctx context.Context, netnsPath string, mappings []InterfaceNodeMapping, iface string, shaping Shaping) error {
+	if shaping.Delay < 0 || shaping.Jitter < 0 || shaping.Loss < 0 || shaping.Loss > 100 {
+		return fmt.Errorf("invalid shaping: %+v", shaping)
+	}
+	if shaping.Delay == 0 && shaping.Jitter == 0 && shaping.Loss == 0 && shaping.Rate == 0 {
+		return fmt.Errorf("shaping is empty, use ClearShaping to remove it")
+	}
+
+	var egress, ingress bool
+	switch shaping.Direction {
+	case "", ShapingEgress:
+		egress = true
+	case ShapingIngress:
+		ingress = true
+	case ShapingBoth:
+		egress, ingress = true, true
+	default:
+		return fmt.Errorf("unknown shaping direction: (%s)", shaping.Direction)
+	}
+
+	// the peer is only required to shape the ingress, otherwise its previous shaping is cleared if it has one.
+	peer, err := hostVethPeer(mappings, iface)
+	if err != nil && ingress {
+		return err
+	}
+
+	if egress {
+		if err := withNetlinkHandle(ctx, netnsPath, func(h *netlink.Handle) error {
+			return shapeLink(h, iface, shaping)
+		}); err != nil {
+			return fmt.Errorf("shape egress of (%s) failed, err: %s", iface, err)
+		}
+	} else {
+		if err := withNetlinkHandle(ctx, netnsPath, func(h *netlink.Handle) error {
+			return clearLinkShaping(h, iface)
+		}); err != nil {
+			return fmt.Errorf("clear shaping of (%s) failed, err: %s", iface, err)
+		}
+	}
+
+	if ingress {
+		if err := withNetlinkHandle(ctx, peer.HostNetNSPath, func(h *netlink.Handle) error {
+			return shapeLink(h, peer.HostInterface, shaping)
+		}); err != nil {
+			// the shaping is applied to both directions or to none.
+			if egress {
+				withNetlinkHandle(context.Background(), netnsPath, func(h *netlink.Handle) error {
+					return clearLinkShaping(h, iface)
+				})
+			}
+			return fmt.Errorf("shape ingress of (%s) on (%s) failed, err: %s", iface, peer.HostInterface, err)
+		}
+	} else if peer != nil {
+		if err := withNetlinkHandle(ctx, peer.HostNetNSPath, func(h *netlink.Handle) error {
+			return clearLinkShaping(h, peer.HostInterface)
+		}); err != nil {
+			return fmt.Errorf("clear shaping of (%s) on (%s) failed, err: %s", iface, peer.HostInterface, err)
+		}
+	}
+
+	return nil
+}
+
+// clearShaping removes the qdiscs installed by shapeTraffic on the interface iface and on its host-side veth peer.
+// Qdiscs installed by others are left untouched.
+func clearShaping(ctx context.Context, netnsPath string, mappings []InterfaceNodeMapping, iface string) error {
+	if err := withNetlinkHandle(ctx, netnsPath, func(h *netlink.Handle) error {
+		return clearLinkShaping(h, iface)
+	}); err != nil {
+		return fmt.Errorf("clear shaping of (%s) failed, err: %s", iface, err)
+	}
+
+	if peer, err := hostVethPeer(mappings, iface); err == nil {
+		if err := withNetlinkHandle(ctx, peer.HostNetNSPath, func(h *netlink.Handle) error {
+			return clearLinkShaping(h, peer.HostInterface)
+		}); err != nil {
+			return fmt.Errorf("clear shaping of (%s) on (%s) failed, err: %s", iface, peer.HostInterface, err)
+		}
+	}
+
+	return nil
+}
+
+func withNetlinkHandle(ctx context.Context, netnsPath string, fn func(h *netlink.Handle) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ns, err := netns.GetFromPath(netnsPath)
+	if err != nil {
+		return fmt.Errorf("open netns (%s) failed, err: %s", netnsPath, err)
+	}
+	defer ns.Close()
+
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		return fmt.Errorf("create netlink handle in netns (%s) failed, err: %s", netnsPath, err)
+	}
+	defer h.Delete()
+
+	return fn(h)
+}
+
+func shapeLink(h *netlink.Handle, name string, shaping Shaping) error {
+	link, err := h.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("get link (%s) failed, err: %s", name, err)
+	}
+
+	root, err := rootQdisc(h, link)
+	if err != nil {
+		return err
+	}
+	if root != nil && root.Attrs().Handle != 0 && root.Attrs().Handle != shapingRootHandle {
+		return fmt.Errorf("link (%s) already has a %s root qdisc not installed by us", name, root.Type())
+	}
+	if root != nil && root.Attrs().Handle == shapingRootHandle {
+		if err := h.QdiscDel(root); err != nil {
+			return fmt.Errorf("delete previous shaping failed, err: %s", err)
+		}
+	}
+
+	withNetem := shaping.Delay > 0 || shaping.Jitter > 0 || shaping.Loss > 0
+
+	var qdiscs []netlink.Qdisc
+	netemParent := uint32(netlink.HANDLE_ROOT)
+	netemHandle := shapingRootHandle
+
+	if shaping.Rate > 0 {
+		qdiscs = append(qdiscs, newShapingTbf(link, shaping.Rate))
+		// the netem is the inner qdisc of the only class of the tbf
+		netemParent = netlink.MakeHandle(0xc0de, 1)
+		netemHandle = shapingNetemHandle
+	}
+
+	if withNetem {
+		qdiscs = append(qdiscs, netlink.NewNetem(
+			netlink.QdiscAttrs{LinkIndex: link.Attrs().Index, Parent: netemParent, Handle: netemHandle},
+			netlink.NetemQdiscAttrs{
+				Latency: uint32(shaping.Delay.Microseconds()),
+				Jitter:  uint32(shaping.Jitter.Microseconds()),
+				Loss:    float32(shaping.Loss),
+			},
+		))
+	}
+
+	for _, qdisc := range qdiscs {
+		if err := h.QdiscAdd(qdisc); err != nil {
+			clearLinkShaping(h, name)
+			return fmt.Errorf("add %s qdisc to (%s) failed, err: %s", qdisc.Type(), name, err)
+		}
+	}
+
+	return nil
+}
+
+// newShapingTbf returns a tbf qdisc limiting the link to rate bits per second.
+func newShapingTbf(link netlink.Link, rate uint64) *netlink.Tbf {
+	bytesPerSecond := rate / 8
+
+	// burst is 10ms worth of traffic, but at least a few full-sized packets.
+	burst := uint32(bytesPerSecond / 100)
+	if mtu := uint32(link.Attrs().MTU); burst < 4*mtu {
+		burst = 4 * mtu
+	}
+
+	// queue at most 50ms worth of traffic before dropping.
+	limit := uint32(bytesPerSecond/20) + burst
+
+	return &netlink.Tbf{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Parent:    netlink.HANDLE_ROOT,
+			Handle:    shapingRootHandle,
+		},
+		Rate:   bytesPerSecond,
+		Limit:  limit,
+		Buffer: netlink.Xmittime(bytesPerSecond, burst),
+	}
+}
+
+func clearLinkShaping(h *netlink.Handle, name string) error {
+	link, err := h.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("get link (%s) failed, err: %s", name, err)
+	}
+
+	root, err := rootQdisc(h, link)
+	if err != nil {
+		return err
+	}
+	if root == nil || root.Attrs().Handle != shapingRootHandle {
+		return nil
+	}
+
+	// deleting the root qdisc deletes its inner netem as well, and restores the default qdisc.
+	if err := h.QdiscDel(root); err != nil {
+		return fmt.Errorf("delete %s qdisc of (%s) failed, err: %s", root.Type(), name, err)
+	}
+
+	return nil
+}
+
+func rootQdisc(h *netlink.Handle, link netlink.Link) (netlink.Qdisc, error) {
+	qdiscs, err := h.QdiscList(link)
+	if err != nil {
+		return nil, fmt.Errorf("list qdiscs of (%s) failed, err: %s", link.Attrs().Name, err)
+	}
+
+	for _, qdisc := range qdiscs {
+		if qdisc.Attrs().Parent == netlink.HANDLE_ROOT {
+			return qdisc, nil
+		}
+	}
+
+	return nil, nil
+}
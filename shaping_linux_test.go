@@ -0,0 +1,215 @@
+package container
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+func Test_shapeTraffic(t *testing.T) {
+	requireRoot(t)
+
+	ctx := context.Background()
+	hostPath := newTestNetNS(t, "gcu-test-shape-host")
+	containerPath := newTestNetNS(t, "gcu-test-shape-ctr")
+
+	if _, err := attachInterface(ctx, containerPath, hostPath, AttachInterfaceSpec{Name: "eth0", Kind: AttachInterfaceVeth, HostName: "vethshape0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	mappings, err := resolveInterfaceNodeMappings(containerPath, []string{hostPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qdiscsOf := func(t *testing.T, path, name string) []netlink.Qdisc {
+		t.Helper()
+		ns, err := netns.GetFromPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ns.Close()
+		h, err := netlink.NewHandleAt(ns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.Delete()
+		link, err := h.LinkByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		qdiscs, err := h.QdiscList(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return qdiscs
+	}
+
+	rootOf := func(t *testing.T, path, name string) netlink.Qdisc {
+		t.Helper()
+		ns, err := netns.GetFromPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ns.Close()
+		h, err := netlink.NewHandleAt(ns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.Delete()
+		link, err := h.LinkByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, err := rootQdisc(h, link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return root
+	}
+
+	if err := shapeTraffic(ctx, containerPath, mappings, "eth0", Shaping{Rate: 10_000_000, Direction: ShapingBoth}); err != nil {
+		t.Fatal(err)
+	}
+	for _, side := range [][2]string{{containerPath, "eth0"}, {hostPath, "vethshape0"}} {
+		root := rootOf(t, side[0], side[1])
+		if root == nil || root.Type() != "tbf" || root.Attrs().Handle != shapingRootHandle {
+			t.Errorf("unexpected root qdisc of %s: %v", side[1], root)
+		}
+	}
+
+	// replacing the shaping with an egress only one
+	if err := shapeTraffic(ctx, containerPath, mappings, "eth0", Shaping{Rate: 1_000_000}); err != nil {
+		t.Fatal(err)
+	}
+	if root := rootOf(t, containerPath, "eth0").(*netlink.Tbf); root.Rate != 1_000_000/8 {
+		t.Errorf("unexpected rate: %d", root.Rate)
+	}
+	if root := rootOf(t, hostPath, "vethshape0"); root != nil && root.Attrs().Handle == shapingRootHandle {
+		t.Errorf("previous ingress shaping not cleared: %v", root)
+	}
+
+	t.Run("netem", func(t *testing.T) {
+		ns, err := netns.GetFromPath(containerPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ns.Close()
+		h, err := netlink.NewHandleAt(ns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.Delete()
+		lo, err := h.LinkByName("lo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		probe := netlink.NewNetem(netlink.QdiscAttrs{LinkIndex: lo.Attrs().Index, Parent: netlink.HANDLE_ROOT}, netlink.NetemQdiscAttrs{})
+		if err := h.QdiscAdd(probe); err != nil {
+			t.Skipf("netem is not supported: %s", err)
+		}
+		h.QdiscDel(probe)
+
+		// a netem alone is the root qdisc
+		if err := shapeTraffic(ctx, containerPath, mappings, "eth0", Shaping{Delay: 10 * time.Millisecond, Loss: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if root, ok := rootOf(t, containerPath, "eth0").(*netlink.Netem); !ok || root.Attrs().Handle != shapingRootHandle || root.Latency == 0 || root.Loss == 0 {
+			t.Errorf("unexpected root qdisc: %v", root)
+		}
+
+		// with a rate, the netem is nested in the class of the tbf
+		if err := shapeTraffic(ctx, containerPath, mappings, "eth0", Shaping{Rate: 1_000_000, Delay: 10 * time.Millisecond}); err != nil {
+			t.Fatal(err)
+		}
+		if root := rootOf(t, containerPath, "eth0"); root == nil || root.Type() != "tbf" {
+			t.Errorf("unexpected root qdisc: %v", root)
+		}
+		var netem netlink.Qdisc
+		for _, qdisc := range qdiscsOf(t, containerPath, "eth0") {
+			if qdisc.Type() == "netem" {
+				netem = qdisc
+			}
+		}
+		if netem == nil || netem.Attrs().Parent != netlink.MakeHandle(0xc0de, 1) || netem.Attrs().Handle != shapingNetemHandle {
+			t.Errorf("unexpected netem qdisc: %v", netem)
+		}
+	})
+
+	if err := clearShaping(ctx, containerPath, mappings, "eth0"); err != nil {
+		t.Fatal(err)
+	}
+	for _, side := range [][2]string{{containerPath, "eth0"}, {hostPath, "vethshape0"}} {
+		if root := rootOf(t, side[0], side[1]); root != nil && root.Attrs().Handle != 0 {
+			t.Errorf("shaping of %s not cleared: %v", side[1], root)
+		}
+	}
+
+	// a root qdisc installed by someone else is neither replaced nor removed
+	ns, err := netns.GetFromPath(containerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ns.Close()
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Delete()
+	link, err := h.LinkByName("eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign := newShapingTbf(link, 8_000_000)
+	foreign.Handle = netlink.MakeHandle(1, 0)
+	if err := h.QdiscAdd(foreign); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := shapeTraffic(ctx, containerPath, mappings, "eth0", Shaping{Rate: 1_000_000}); err == nil {
+		t.Errorf("expected an error for a foreign root qdisc")
+	}
+	if err := clearShaping(ctx, containerPath, mappings, "eth0"); err != nil {
+		t.Fatal(err)
+	}
+	if root := rootOf(t, containerPath, "eth0"); root == nil || root.Attrs().Handle != netlink.MakeHandle(1, 0) {
+		t.Errorf("foreign root qdisc removed: %v", root)
+	}
+
+	// the egress shaping is rolled back when the ingress fails.
+	hostNS, err := netns.GetFromPath(hostPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hostNS.Close()
+	hostHandle, err := netlink.NewHandleAt(hostNS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hostHandle.Delete()
+	hostLink, err := hostHandle.LinkByName("vethshape0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign = newShapingTbf(hostLink, 8_000_000)
+	foreign.Handle = netlink.MakeHandle(1, 0)
+	if err := hostHandle.QdiscAdd(foreign); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.QdiscDel(rootOf(t, containerPath, "eth0")); err != nil {
+		t.Fatal(err)
+	}
+	if err := shapeTraffic(ctx, containerPath, mappings, "eth0", Shaping{Rate: 1_000_000, Direction: ShapingBoth}); err == nil {
+		t.Errorf("expected an error for a foreign root qdisc on the host side")
+	}
+	if root := rootOf(t, containerPath, "eth0"); root != nil && root.Attrs().Handle == shapingRootHandle {
+		t.Errorf("egress shaping not rolled back: %v", root)
+	}
+
+	if err := shapeTraffic(ctx, containerPath, mappings, "lo", Shaping{Delay: 1, Direction: ShapingIngress}); err == nil {
+		t.Errorf("expected an error for ingress shaping without a veth peer")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package container
+
+import (
+	"context"
+)
+
+func shapeTraffic(ctx context.Context, netnsPath string, mappings []InterfaceNodeMapping, iface string, shaping Shaping) error {
+	return ErrNotImplemented
+}
+
+func clearShaping(ctx context.Context, netnsPath string, mappings []InterfaceNodeMapping, iface string) error {
+	return ErrNotImplemented
+}
@@ -0,0 +1,287 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// upperSnapshotDir is the dir of a snapshot holding the copy of the upper dir.
+	upperSnapshotDir = "upper"
+
+	// upperSnapshotManifest is the file of a snapshot holding its UpperSnapshot.
+	upperSnapshotManifest = "manifest.json"
+)
+
+// UpperSnapshot is the manifest of a snapshot of the upper dir of a container written by SnapshotUpper.
+type UpperSnapshot struct {
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Files are the entries of the upper dir, parents first.
+	Files []SnapshotFile `json:"files"`
+}
+
+// SnapshotFile is an entry of the upper dir of a snapshot.
+type SnapshotFile struct {
+	// Path is the path relative to the upper dir, eg: etc/passwd
+	Path    string      `json:"path"`
+	Mode    fs.FileMode `json:"mode"`
+	Size    int64       `json:"size"`
+	UID     int         `json:"uid"`
+	GID     int         `json:"gid"`
+	ModTime time.Time   `json:"modTime"`
+
+	// Whiteout tells if the entry is an overlay whiteout.
+	Whiteout bool `json:"whiteout,omitempty"`
+
+	// Link is the target of a symbolic link, HardLink the path of the first entry linked to the same file.
+	Link     string `json:"link,omitempty"`
+	HardLink string `json:"hardLink,omitempty"`
+
+	// Xattrs are the extended attributes, the ones of overlay included.
+	Xattrs map[string][]byte `json:"xattrs,omitempty"`
+
+	// Digest is the sha256 digest of the content of a regular file, eg: "sha256:e3b0c442..."
+	Digest string `json:"digest,omitempty"`
+}
+
+// pauser is the part of a Container which is paused while its upper dir is copied.
+type pauser interface {
+	// pauseState returns whether the container is running, paused or not, and whether it is paused.
+	pauseState() (running, paused bool, err error)
+	Pause() error
+	Unpause() error
+}
+
+// whilePaused runs fn with c paused if it is running. c is unpaused afterwards only if whilePaused paused it,
+// a container paused by someone else is left paused.
+func whilePaused(c pauser, fn func() error) error {
+	running, paused, err := c.pauseState()
+	if err != nil {
+		return fmt.Errorf("get container state failed, err: %w", err)
+	}
+	if !running || paused {
+		return fn()
+	}
+
+	if err := c.Pause(); err != nil {
+		return fmt.Errorf("pause container failed, err: %s", err)
+	}
+	err = fn()
+	if uerr := c.Unpause(); uerr != nil && err == nil {
+		err = fmt.Errorf("unpause container failed, err: %s", uerr)
+	}
+	return err
+}
+
+// snapshotUpper copies upperDir to the upper dir of dest, pausing c, if it is running, during the copy,
+// and writes the manifest of the snapshot, with the digests of the copied files, to dest.
+func snapshotUpper(ctx context.Context, c pauser, upperDir, dest string) (*UpperSnapshot, error) {
+	if err := os.MkdirAll(dest, 0700); err != nil {
+		return nil, fmt.Errorf("create snapshot dir failed, err: %s", err)
+	}
+	snapshotDir := filepath.Join(dest, upperSnapshotDir)
+	if _, err := os.Lstat(snapshotDir); err == nil {
+		return nil, fmt.Errorf("snapshot dir (%s) already exists", snapshotDir)
+	}
+
+	createdAt := time.Now().UTC()
+	err := whilePaused(c, func() error {
+		if err := copyUpperTree(ctx, upperDir, snapshotDir); err != nil {
+			return fmt.Errorf("copy upper dir (%s) failed, err: %w", upperDir, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the copy does not change once the container is unpaused, it is hashed afterwards.
+	snapshot := &UpperSnapshot{CreatedAt: createdAt, Files: []SnapshotFile{}}
+	links := hardlinks{}
+
+	err = filepath.WalkDir(snapshotDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(snapshotDir, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		file, err := newSnapshotFile(snapshotDir, filepath.ToSlash(rel), fi, links)
+		if err != nil {
+			return err
+		}
+		snapshot.Files = append(snapshot.Files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("hash snapshot files failed, err: %w", err)
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal snapshot manifest failed, err: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(dest, upperSnapshotManifest), data, 0600); err != nil {
+		return nil, fmt.Errorf("write snapshot manifest failed, err: %s", err)
+	}
+
+	return snapshot, nil
+}
+
+func newSnapshotFile(root, name string, fi fs.FileInfo, links hardlinks) (SnapshotFile, error) {
+	p := filepath.Join(root, filepath.FromSlash(name))
+	uid, gid := fileOwner(fi)
+	file := SnapshotFile{
+		Path:     name,
+		Mode:     fi.Mode(),
+		Size:     fi.Size(),
+		UID:      uid,
+		GID:      gid,
+		ModTime:  fi.ModTime(),
+		Whiteout: isOverlayWhiteout(fi),
+	}
+
+	xattrs, err := listXattrs(p)
+	if err != nil {
+		return file, fmt.Errorf("list xattrs of (%s) failed, err: %s", name, err)
+	}
+	for key, value := range xattrs {
+		if file.Xattrs == nil {
+			file.Xattrs = map[string][]byte{}
+		}
+		file.Xattrs[key] = []byte(value)
+	}
+
+	switch {
+	case fi.Mode()&fs.ModeSymlink != 0:
+		if file.Link, err = os.Readlink(p); err != nil {
+			return file, err
+		}
+	case fi.Mode().IsRegular():
+		if first, ok := links.first(fi, name); ok {
+			file.HardLink = first
+			return file, nil
+		}
+		if file.Digest, err = fileDigest(os.DirFS(root), name); err != nil {
+			return file, err
+		}
+	}
+
+	return file, nil
+}
+
+// restoreUpper verifies the snapshot src against its manifest and copies its upper dir into upperDir,
+// replacing the entries of upperDir which the snapshot has as well.
+func restoreUpper(ctx context.Context, src, upperDir string) error {
+	data, err := os.ReadFile(filepath.Join(src, upperSnapshotManifest))
+	if err != nil {
+		return fmt.Errorf("read snapshot manifest failed, err: %s", err)
+	}
+	snapshot := &UpperSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return fmt.Errorf("unmarshal snapshot manifest failed, err: %s", err)
+	}
+
+	snapshotDir := filepath.Join(src, upperSnapshotDir)
+	for _, file := range snapshot.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p := filepath.Join(snapshotDir, filepath.FromSlash(file.Path))
+		fi, err := os.Lstat(p)
+		if err != nil {
+			return fmt.Errorf("snapshot file (%s) is missing, err: %s", file.Path, err)
+		}
+		if fi.Mode().Type() != file.Mode.Type() {
+			return fmt.Errorf("snapshot file (%s) has type %s, expected %s", file.Path, fi.Mode().Type(), file.Mode.Type())
+		}
+		if file.Digest == "" {
+			continue
+		}
+		digest, err := fileDigest(os.DirFS(snapshotDir), file.Path)
+		if err != nil {
+			return err
+		}
+		if digest != file.Digest {
+			return fmt.Errorf("snapshot file (%s) has digest %s, expected %s", file.Path, digest, file.Digest)
+		}
+	}
+
+	if err := copyUpperTree(ctx, snapshotDir, upperDir); err != nil {
+		return fmt.Errorf("copy snapshot to upper dir (%s) failed, err: %w", upperDir, err)
+	}
+
+	return nil
+}
+
+// copyUpperTree copies the tree src to dst, preserving the whiteouts, the xattrs, the ownership, the times,
+// the hardlinks and the holes of sparse files. The files are reflinked when the filesystem supports it.
+// An existing entry of dst is replaced, unless both are dirs.
+func copyUpperTree(ctx context.Context, src, dst string) error {
+	var dirs dirTimes
+	links := hardlinks{}
+
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if existing, err := os.Lstat(target); err == nil && !(existing.IsDir() && fi.IsDir()) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+
+		if first, ok := links.first(fi, target); ok {
+			return os.Link(first, target)
+		}
+
+		if err := copyUpperEntry(p, target, fi); err != nil {
+			return fmt.Errorf("copy (%s) failed, err: %w", rel, err)
+		}
+
+		if fi.IsDir() {
+			dirs.add(func() error { return setFileTimes(target, fi) })
+			return nil
+		}
+		return setFileTimes(target, fi)
+	})
+	if err != nil {
+		return err
+	}
+
+	return dirs.set()
+}
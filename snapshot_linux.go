@@ -0,0 +1,129 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyUpperEntry creates dst as a copy of src, with the ownership, mode and xattrs of src, but not its times.
+// A dst dir which already exists is kept.
+func copyUpperEntry(src, dst string, fi fs.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("unsupported file info of (%s)", src)
+	}
+
+	switch fi.Mode().Type() {
+	case fs.ModeDir:
+		if err := os.Mkdir(dst, 0700); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case 0:
+		if err := copyFileData(src, dst); err != nil {
+			return err
+		}
+	case fs.ModeSymlink:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(target, dst); err != nil {
+			return err
+		}
+	default:
+		// whiteouts, devices, fifos and sockets.
+		if err := unix.Mknod(dst, st.Mode, int(st.Rdev)); err != nil {
+			return fmt.Errorf("mknod failed, err: %s", err)
+		}
+	}
+
+	if err := os.Lchown(dst, int(st.Uid), int(st.Gid)); err != nil {
+		return err
+	}
+
+	xattrs, err := listXattrs(src)
+	if err != nil {
+		return fmt.Errorf("list xattrs failed, err: %s", err)
+	}
+	for key, value := range xattrs {
+		if err := unix.Lsetxattr(dst, key, []byte(value), 0); err != nil {
+			return fmt.Errorf("set xattr %s failed, err: %s", key, err)
+		}
+	}
+
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		return nil
+	}
+	// the setuid and setgid bits of src are restored, the Lchown above dropped them.
+	return unix.Chmod(dst, st.Mode&07777)
+}
+
+// copyFileData copies the content of the regular file src to the new file dst, with a reflink if possible,
+// and otherwise only the data segments of src, which keeps the holes of a sparse file.
+func copyFileData(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err == nil {
+		return out.Close()
+	}
+
+	size, err := in.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	for offset < size {
+		start, err := unix.Seek(int(in.Fd()), offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// no data after offset.
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("seek data failed, err: %s", err)
+		}
+		end, err := unix.Seek(int(in.Fd()), start, unix.SEEK_HOLE)
+		if err != nil {
+			return fmt.Errorf("seek hole failed, err: %s", err)
+		}
+
+		if _, err := out.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, io.NewSectionReader(in, start, end-start)); err != nil {
+			return err
+		}
+		offset = end
+	}
+
+	if err := out.Truncate(size); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// setFileTimes sets the access and modification times of dst to the ones of fi, without following a symbolic link.
+func setFileTimes(dst string, fi fs.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("unsupported file info of (%s)", dst)
+	}
+	times := []unix.Timespec{unix.NsecToTimespec(syscall.TimespecToNsec(st.Atim)), unix.NsecToTimespec(fi.ModTime().UnixNano())}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, dst, times, unix.AT_SYMLINK_NOFOLLOW)
+}
@@ -0,0 +1,187 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+type fakePauser struct {
+	stopped bool
+	paused  bool
+	calls   []string
+}
+
+func (p *fakePauser) pauseState() (bool, bool, error) {
+	return !p.stopped, p.paused, nil
+}
+
+func (p *fakePauser) Pause() error {
+	p.calls = append(p.calls, "pause")
+	return nil
+}
+
+func (p *fakePauser) Unpause() error {
+	p.calls = append(p.calls, "unpause")
+	return nil
+}
+
+func Test_whilePaused(t *testing.T) {
+	tests := []struct {
+		name  string
+		p     *fakePauser
+		calls []string
+	}{
+		{name: "running", p: &fakePauser{}, calls: []string{"pause", "unpause"}},
+		// a container paused by the operator is left paused
+		{name: "paused", p: &fakePauser{paused: true}},
+		{name: "stopped", p: &fakePauser{stopped: true}},
+	}
+
+	for _, tt := range tests {
+		var ran bool
+		if err := whilePaused(tt.p, func() error { ran = true; return nil }); err != nil {
+			t.Fatal(err)
+		}
+		if !ran || !reflect.DeepEqual(tt.p.calls, tt.calls) {
+			t.Errorf("%s: unexpected calls %v", tt.name, tt.p.calls)
+		}
+	}
+}
+
+// describeTree returns, for each entry under root, its type, mode, owner, device, xattrs, link target, size,
+// and whether it is sparse.
+func describeTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+
+	tree := map[string]string{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		if rel == "." {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		xattrs, err := listXattrs(p)
+		if err != nil {
+			return err
+		}
+		link, _ := os.Readlink(p)
+		desc := fmt.Sprintf("%s %d:%d rdev=%d xattrs=%v link=%s", fi.Mode(), st.Uid, st.Gid, st.Rdev, xattrs, link)
+		if !fi.IsDir() {
+			desc += fmt.Sprintf(" size=%d mtime=%d", fi.Size(), fi.ModTime().UnixNano())
+		}
+		// the whiteouts created by overlay are links to a single whiteout.
+		if fi.Mode().IsRegular() {
+			desc += fmt.Sprintf(" sparse=%t nlink=%d", st.Blocks*512 < fi.Size(), st.Nlink)
+		}
+		tree[rel] = desc
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func Test_snapshotUpper(t *testing.T) {
+	requireRoot(t)
+
+	opts, merged := newTestOverlayLayers(t)
+
+	// a sparse file, a hardlink, an owner and a setuid binary
+	f, err := os.Create(filepath.Join(merged, "sparse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("end"), 8<<20); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := os.Link(filepath.Join(merged, "etc/new"), filepath.Join(merged, "etc/new-link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(merged, "suid"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(filepath.Join(merged, "suid"), 1000, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(merged, "suid"), 0755|fs.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	dest := filepath.Join(t.TempDir(), "snapshot")
+	p := &fakePauser{}
+
+	snapshot, err := snapshotUpper(ctx, p, opts.UpperDir, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.calls, []string{"pause", "unpause"}) {
+		t.Errorf("unexpected calls %v", p.calls)
+	}
+
+	// the copy is identical to the upper dir
+	upper := describeTree(t, opts.UpperDir)
+	copied := describeTree(t, filepath.Join(dest, upperSnapshotDir))
+	if !reflect.DeepEqual(upper, copied) {
+		t.Fatalf("snapshot differs from the upper dir:\n%v\n%v", copied, upper)
+	}
+
+	files := map[string]SnapshotFile{}
+	for _, file := range snapshot.Files {
+		files[file.Path] = file
+	}
+	if len(files) != len(upper) {
+		t.Errorf("manifest has %d files, expected %d", len(files), len(upper))
+	}
+	if !files["etc/hosts"].Whiteout || files["etc/new"].Whiteout {
+		t.Error("unexpected whiteouts")
+	}
+	if files["etc/new"].Digest != "sha256:11507a0e2f5e69d5dfa40a62a1bd7b6ee57e6bcd85c67c9b8431b36fff21c437" {
+		t.Errorf("unexpected digest %s", files["etc/new"].Digest)
+	}
+	if files["etc/new-link"].HardLink != "etc/new" || files["etc/new-link"].Digest != "" {
+		t.Errorf("unexpected hardlink %+v", files["etc/new-link"])
+	}
+	if _, ok := files["opt/renamed"].Xattrs["trusted.overlay.redirect"]; !ok {
+		t.Errorf("missing redirect xattr %+v", files["opt/renamed"])
+	}
+	if files["suid"].UID != 1000 || files["suid"].Mode&fs.ModeSetuid == 0 {
+		t.Errorf("unexpected owner or mode %+v", files["suid"])
+	}
+
+	if _, err := snapshotUpper(ctx, p, opts.UpperDir, dest); err == nil {
+		t.Error("snapshot overwrote an existing snapshot")
+	}
+
+	// restored into an empty upper dir
+	restored := t.TempDir()
+	if err := restoreUpper(ctx, dest, restored); err != nil {
+		t.Fatal(err)
+	}
+	if got := describeTree(t, restored); !reflect.DeepEqual(upper, got) {
+		t.Fatalf("restored upper dir differs:\n%v\n%v", got, upper)
+	}
+
+	// a corrupted snapshot is not restored
+	if err := os.WriteFile(filepath.Join(dest, upperSnapshotDir, "etc/new"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreUpper(ctx, dest, t.TempDir()); err == nil {
+		t.Error("corrupted snapshot restored")
+	}
+}
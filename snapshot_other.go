@@ -0,0 +1,15 @@
+//go:build !linux
+
+package container
+
+import (
+	"io/fs"
+)
+
+func copyUpperEntry(src, dst string, fi fs.FileInfo) error {
+	return ErrNotImplemented
+}
+
+func setFileTimes(dst string, fi fs.FileInfo) error {
+	return ErrNotImplemented
+}
@@ -0,0 +1,222 @@
+package container
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Socket is an entry of the socket table of a network namespace, in the style of ss.
+type Socket struct {
+	// Protocol is one of tcp, tcp6, udp, udp6 and unix.
+	Protocol string `json:"protocol"`
+
+	// LocalAddr and RemoteAddr are ip:port for inet sockets, eg: [::]:8080
+	// LocalAddr is the path of a unix socket, prefixed by @ if abstract, and RemoteAddr is empty.
+	LocalAddr  string `json:"localAddr"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+
+	// State is the state as shown by ss, eg: LISTEN, ESTAB, TIME-WAIT, UNCONN
+	State string `json:"state"`
+
+	// Type is the socket type of a unix socket: stream, dgram or seqpacket.
+	Type string `json:"type,omitempty"`
+
+	Inode uint64 `json:"inode"`
+	UID   int    `json:"uid"`
+
+	// Processes are the processes holding the socket open, empty if none could be resolved.
+	Processes []SocketProcess `json:"processes,omitempty"`
+}
+
+type SocketProcess struct {
+	PID  int    `json:"pid"`
+	Comm string `json:"comm"`
+}
+
+// tcpStates maps the st column of /proc/net/tcp to the state names of ss.
+var tcpStates = map[uint64]string{
+	0x01: "ESTAB",
+	0x02: "SYN-SENT",
+	0x03: "SYN-RECV",
+	0x04: "FIN-WAIT-1",
+	0x05: "FIN-WAIT-2",
+	0x06: "TIME-WAIT",
+	0x07: "UNCONN",
+	0x08: "CLOSE-WAIT",
+	0x09: "LAST-ACK",
+	0x0A: "LISTEN",
+	0x0B: "CLOSING",
+	0x0C: "NEW-SYN-RECV",
+}
+
+// parseInetSockets parses the content of /proc/net/{tcp,tcp6,udp,udp6} for the given protocol.
+func parseInetSockets(protocol string, r io.Reader) ([]Socket, error) {
+	var sockets = []Socket{}
+
+	scanner := bufio.NewScanner(r)
+	for first := true; scanner.Scan(); first = false {
+		if first {
+			// header
+			continue
+		}
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 10 {
+			return nil, fmt.Errorf("unexpected %s line: (%s)", protocol, scanner.Text())
+		}
+
+		local, err := parseInetSocketAddr(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("parse local address (%s) failed, err: %s", fields[1], err)
+		}
+		remote, err := parseInetSocketAddr(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("parse remote address (%s) failed, err: %s", fields[2], err)
+		}
+
+		st, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("parse state (%s) failed, err: %s", fields[3], err)
+		}
+		state, ok := tcpStates[st]
+		if !ok {
+			state = "UNKNOWN"
+		}
+
+		uid, err := strconv.Atoi(fields[7])
+		if err != nil {
+			return nil, fmt.Errorf("parse uid (%s) failed, err: %s", fields[7], err)
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse inode (%s) failed, err: %s", fields[9], err)
+		}
+
+		sockets = append(sockets, Socket{
+			Protocol:   protocol,
+			LocalAddr:  local,
+			RemoteAddr: remote,
+			State:      state,
+			Inode:      inode,
+			UID:        uid,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return sockets, nil
+}
+
+// parseInetSocketAddr parses an address of /proc/net/tcp, eg: 0100007F:0CEA
+// The ip is printed as 32-bit words in host byte order, the port in network byte order.
+func parseInetSocketAddr(s string) (string, error) {
+	ipHex, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return "", fmt.Errorf("missing port")
+	}
+
+	b, err := hex.DecodeString(ipHex)
+	if err != nil {
+		return "", err
+	}
+	if len(b) != net.IPv4len && len(b) != net.IPv6len {
+		return "", fmt.Errorf("unexpected ip length %d", len(b))
+	}
+
+	ip := make(net.IP, len(b))
+	for i := 0; i < len(b); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.NativeEndian.Uint32(b[i:]))
+	}
+
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(ip.String(), strconv.FormatUint(port, 10)), nil
+}
+
+var unixSocketTypes = map[uint64]string{
+	1: "stream",
+	2: "dgram",
+	5: "seqpacket",
+}
+
+// unixAcceptCon is the __SO_ACCEPTCON flag of a listening unix socket.
+const unixAcceptCon = 0x10000
+
+// parseUnixSockets parses the content of /proc/net/unix.
+func parseUnixSockets(r io.Reader) ([]Socket, error) {
+	var sockets = []Socket{}
+
+	scanner := bufio.NewScanner(r)
+	for first := true; scanner.Scan(); first = false {
+		if first {
+			// header
+			continue
+		}
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 7 {
+			return nil, fmt.Errorf("unexpected unix line: (%s)", scanner.Text())
+		}
+
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("parse flags (%s) failed, err: %s", fields[3], err)
+		}
+		typ, err := strconv.ParseUint(fields[4], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("parse type (%s) failed, err: %s", fields[4], err)
+		}
+		st, err := strconv.ParseUint(fields[5], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("parse state (%s) failed, err: %s", fields[5], err)
+		}
+		inode, err := strconv.ParseUint(fields[6], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse inode (%s) failed, err: %s", fields[6], err)
+		}
+
+		socket := Socket{
+			Protocol: "unix",
+			Type:     unixSocketTypes[typ],
+			Inode:    inode,
+		}
+		if len(fields) > 7 {
+			// the path may contain spaces
+			socket.LocalAddr = strings.Join(fields[7:], " ")
+		}
+
+		switch {
+		case flags&unixAcceptCon != 0:
+			socket.State = "LISTEN"
+		case st == 3:
+			socket.State = "ESTAB"
+		case st == 2:
+			socket.State = "SYN-SENT"
+		default:
+			socket.State = "UNCONN"
+		}
+
+		sockets = append(sockets, socket)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return sockets, nil
+}
@@ -0,0 +1,115 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// socketsInNetNS lists the tcp, udp and unix sockets of the network namespace at netnsPath,
+// and resolves their owning processes from the proc filesystem under hostRoot.
+func socketsInNetNS(ctx context.Context, netnsPath string, hostRoot string) ([]Socket, error) {
+	var sockets = []Socket{}
+
+	err := doInNamespaces(ctx, map[NamespaceType]string{NamespaceNet: netnsPath}, func() error {
+		// /proc/thread-self/net shows the network namespace of the current thread,
+		// whereas /proc/net follows the main thread of the process.
+		for _, protocol := range []string{"tcp", "tcp6", "udp", "udp6", "unix"} {
+			b, err := os.ReadFile(filepath.Join("/proc/thread-self/net", protocol))
+			if os.IsNotExist(err) {
+				// eg: tcp6 with ipv6 disabled
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("read %s sockets failed, err: %s", protocol, err)
+			}
+
+			var s []Socket
+			if protocol == "unix" {
+				s, err = parseUnixSockets(bytes.NewReader(b))
+			} else {
+				s, err = parseInetSockets(protocol, bytes.NewReader(b))
+			}
+			if err != nil {
+				return fmt.Errorf("parse %s sockets failed, err: %s", protocol, err)
+			}
+			sockets = append(sockets, s...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed inside ns, err: %s", err)
+	}
+
+	owners, err := socketOwners(netnsPath, hostRoot)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sockets {
+		sockets[i].Processes = owners[sockets[i].Inode]
+	}
+
+	return sockets, nil
+}
+
+// socketOwners maps the socket inodes held open by the processes in the network namespace
+// at netnsPath to these processes. Processes which exit or can not be inspected are skipped.
+func socketOwners(netnsPath string, hostRoot string) (map[uint64][]SocketProcess, error) {
+	netnsInode, err := namespaceInode(netnsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	procDir := filepath.Join(hostRoot, "proc")
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return nil, fmt.Errorf("read dir (%s) failed, err: %s", procDir, err)
+	}
+
+	var owners = map[uint64][]SocketProcess{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		inode, err := namespaceInode(filepath.Join(procDir, entry.Name(), "ns", "net"))
+		if err != nil || inode != netnsInode {
+			continue
+		}
+
+		fdDir := filepath.Join(procDir, entry.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+
+		comm, _ := os.ReadFile(filepath.Join(procDir, entry.Name(), "comm"))
+		process := SocketProcess{PID: pid, Comm: strings.TrimSpace(string(comm))}
+
+		var seen = map[uint64]bool{}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(target, "socket:[") {
+				continue
+			}
+			socketInode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(target, "socket:["), "]"), 10, 64)
+			if err != nil || seen[socketInode] {
+				continue
+			}
+			seen[socketInode] = true
+			owners[socketInode] = append(owners[socketInode], process)
+		}
+	}
+
+	for _, processes := range owners {
+		sort.Slice(processes, func(i, j int) bool { return processes[i].PID < processes[j].PID })
+	}
+
+	return owners, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package container
+
+import (
+	"context"
+)
+
+func socketsInNetNS(ctx context.Context, netnsPath string, hostRoot string) ([]Socket, error) {
+	return nil, ErrNotImplemented
+}
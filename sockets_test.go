@@ -0,0 +1,69 @@
+package container
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseInetSockets(t *testing.T) {
+	tcp := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 24861 1 0000000000000000 100 0 0 10 0
+   1: 0200000A:D2F0 0101A8C0:01BB 01 00000000:00000000 02:000A7A00 00000000  1000        0 31337 2 0000000000000000 20 4 30 10 -1
+`
+	tcp6 := `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000001000000:0050 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 11111 1 0000000000000000 100 0 0 10 0
+   1: 000080FE00000000FF4E420203AC11FE:1F90 00000000000000000000000000000000:0000 07 00000000:00000000 00:00000000 00000000    65534        0 22222 1 0000000000000000 100 0 0 10 0
+`
+
+	got, err := parseInetSockets("tcp", strings.NewReader(tcp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Socket{
+		{Protocol: "tcp", LocalAddr: "127.0.0.1:8080", RemoteAddr: "0.0.0.0:0", State: "LISTEN", Inode: 24861, UID: 0},
+		{Protocol: "tcp", LocalAddr: "10.0.0.2:54000", RemoteAddr: "192.168.1.1:443", State: "ESTAB", Inode: 31337, UID: 1000},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %+v, expected %+v", got, expected)
+	}
+
+	got, err = parseInetSockets("tcp6", strings.NewReader(tcp6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []Socket{
+		{Protocol: "tcp6", LocalAddr: "[::1]:80", RemoteAddr: "[::]:0", State: "LISTEN", Inode: 11111, UID: 0},
+		{Protocol: "tcp6", LocalAddr: "[fe80::242:4eff:fe11:ac03]:8080", RemoteAddr: "[::]:0", State: "UNCONN", Inode: 22222, UID: 65534},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %+v, expected %+v", got, expected)
+	}
+
+	if _, err := parseInetSockets("tcp", strings.NewReader("header\n   0: 0100007F 00000000:0000 0A\n")); err == nil {
+		t.Errorf("expected an error for a malformed line")
+	}
+}
+
+func Test_parseUnixSockets(t *testing.T) {
+	unix := `Num       RefCount Protocol Flags    Type St Inode Path
+0000000000000000: 00000002 00000000 00010000 0001 01 18000 /run/app/admin.sock
+0000000000000000: 00000003 00000000 00000000 0001 03 18001
+0000000000000000: 00000002 00000000 00000000 0002 01 18002 @/containerd-shim/abc.sock
+0000000000000000: 00000002 00000000 00000000 0005 01 18003 /tmp/with space.sock
+`
+
+	got, err := parseUnixSockets(strings.NewReader(unix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Socket{
+		{Protocol: "unix", LocalAddr: "/run/app/admin.sock", State: "LISTEN", Type: "stream", Inode: 18000},
+		{Protocol: "unix", State: "ESTAB", Type: "stream", Inode: 18001},
+		{Protocol: "unix", LocalAddr: "@/containerd-shim/abc.sock", State: "UNCONN", Type: "dgram", Inode: 18002},
+		{Protocol: "unix", LocalAddr: "/tmp/with space.sock", State: "UNCONN", Type: "seqpacket", Inode: 18003},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %+v, expected %+v", got, expected)
+	}
+}
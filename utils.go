@@ -1,6 +1,14 @@
 package container
 
-import "os"
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
 
 func FileExists(item string) (bool, error) {
 	info, err := os.Stat(item)
@@ -14,3 +22,76 @@ func FileExists(item string) (bool, error) {
 	// item exists
 	return !info.IsDir(), nil
 }
+
+// hardlinks records the first name of each file with several links met in a tree, so that its other names
+// are written as links to it.
+type hardlinks map[fileID]string
+
+// first returns the name recorded for the regular file of fi if it has several links, and records name for it
+// when it is met for the first time.
+func (h hardlinks) first(fi fs.FileInfo, name string) (string, bool) {
+	if !fi.Mode().IsRegular() {
+		return "", false
+	}
+	id, ok := linkedFileID(fi)
+	if !ok {
+		return "", false
+	}
+	if first, ok := h[id]; ok {
+		return first, true
+	}
+	h[id] = name
+	return "", false
+}
+
+// tarHeader returns the PAX header of the file fi, named name, with link as the target of a symbolic link.
+// The names of the owner and group are left out, the names of the users of the host are meaningless
+// in a container.
+func tarHeader(fi fs.FileInfo, name, link string) (*tar.Header, error) {
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return nil, err
+	}
+	hdr.Name = name
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Uname, hdr.Gname = "", ""
+	hdr.Format = tar.FormatPAX
+	return hdr, nil
+}
+
+// fileDigest returns the sha256 digest of the content of the regular file name of fsys, eg: "sha256:e3b0c442..."
+func fileDigest(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("read (%s) failed, err: %s", name, err)
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// dirTimes holds the setting of the times of the dirs of a tree being written until all their entries are created,
+// which changes the times of a dir.
+type dirTimes []func() error
+
+// add defers set, which sets the times of a dir.
+func (d *dirTimes) add(set func() error) {
+	*d = append(*d, set)
+}
+
+// set sets the times of the dirs, the deepest first.
+func (d dirTimes) set() error {
+	for i := len(d) - 1; i >= 0; i-- {
+		if err := d[i](); err != nil {
+			return err
+		}
+	}
+	return nil
+}